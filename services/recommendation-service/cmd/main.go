@@ -1,9 +1,11 @@
 package main
 
 import (
+	"context"
 	"log"
 	"polyforge-recommendation/internal/api"
 	"polyforge-recommendation/internal/config"
+	"polyforge-recommendation/internal/consumers"
 	"polyforge-recommendation/pkg/middleware"
 
 	"github.com/gofiber/fiber/v2"
@@ -23,11 +25,13 @@ func main() {
 
 	rdc := redis.NewClient(&redis.Options{Addr: cfg.GetCacheAddress()})
 
-	app := fiber.New()
+	app := fiber.New(fiber.Config{StreamRequestBody: true})
 
 	app.Use(middleware.ContextTransformer)
 
 	api.SetupRoutes(app, db, rdc, cfg)
 
+	consumers.Start(context.Background(), db, cfg)
+
 	log.Fatal(app.Listen(":8000"))
 }
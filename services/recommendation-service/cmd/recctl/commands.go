@@ -0,0 +1,442 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"reflect"
+	"strings"
+	"time"
+
+	"polyforge-recommendation/internal/config"
+	"polyforge-recommendation/internal/evaluation"
+	"polyforge-recommendation/internal/models"
+	"polyforge-recommendation/internal/services"
+	"polyforge-recommendation/internal/synthetic"
+)
+
+// rebuild runs a full rebuild and waits for it, or rescores one user into
+// the active snapshot.
+func rebuild(ctx context.Context, cfg config.Config, args []string) error {
+	flags := flag.NewFlagSet("rebuild", flag.ExitOnError)
+	userID := flags.String("user", "", "rebuild only this user's list in the active snapshot")
+	flags.Parse(args)
+
+	service, err := recommendationService(cfg)
+	if err != nil {
+		return err
+	}
+
+	if *userID != "" {
+		recommendation, err := service.RebuildUserRecommendations(ctx, *userID)
+		if err != nil {
+			return fmt.Errorf("failed to rebuild recommendations of user %s: %w", *userID, err)
+		}
+		return printJSON(recommendation)
+	}
+
+	snapshot, err := service.RebuildSnapshot(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to rebuild recommendations: %w", err)
+	}
+	if err := printJSON(snapshot); err != nil {
+		return err
+	}
+	if snapshot.Status != models.SnapshotStatusReady {
+		return fmt.Errorf("snapshot %d failed to build", snapshot.Version)
+	}
+	return nil
+}
+
+func cache(ctx context.Context, cfg config.Config, args []string) error {
+	if len(args) != 1 || args[0] != "clear" {
+		return errUsage
+	}
+
+	service, err := recommendationService(cfg)
+	if err != nil {
+		return err
+	}
+	if err := service.ClearCache(ctx); err != nil {
+		return fmt.Errorf("failed to clear cache: %w", err)
+	}
+	fmt.Fprintln(os.Stderr, "Cache cleared")
+	return nil
+}
+
+// export writes stored user lists or the trending list to a file or stdout,
+// e.g. to a mounted volume read by the data warehouse.
+func export(ctx context.Context, cfg config.Config, args []string) error {
+	flags := flag.NewFlagSet("export", flag.ExitOnError)
+	dataset := flags.String("dataset", services.ExportDatasetUsers, "users or trending")
+	format := flags.String("format", services.ExportFormatNDJSON, "ndjson or csv")
+	gzipped := flags.Bool("gzip", false, "gzip the output")
+	version := flags.Int("version", 0, "snapshot to export user lists from (default: active)")
+	updatedSince := flags.String("updated-since", "", "only user lists stored since this RFC 3339 time")
+	out := flags.String("out", "-", "output file, - for stdout")
+	flags.Parse(args)
+
+	opts := services.ExportOptions{Dataset: *dataset, Format: *format, Gzip: *gzipped, Version: *version}
+	if *updatedSince != "" {
+		since, err := time.Parse(time.RFC3339, *updatedSince)
+		if err != nil {
+			return fmt.Errorf("invalid -updated-since: %w", err)
+		}
+		opts.UpdatedSince = since
+	}
+	if err := opts.Validate(); err != nil {
+		return err
+	}
+
+	service, err := recommendationService(cfg)
+	if err != nil {
+		return err
+	}
+
+	var w io.WriteCloser = os.Stdout
+	if *out != "-" {
+		if w, err = os.Create(*out); err != nil {
+			return fmt.Errorf("failed to create output file: %w", err)
+		}
+	}
+
+	count, err := service.Export(ctx, w, opts)
+	if err != nil {
+		return fmt.Errorf("failed to export recommendations: %w", err)
+	}
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("failed to write output file: %w", err)
+	}
+	fmt.Fprintf(os.Stderr, "Exported %d %s lists\n", count, opts.Dataset)
+	return nil
+}
+
+// importData loads a catalog dump or backfills historical events, e.g. the
+// order history of the order service, and prints the import report.
+func importData(ctx context.Context, cfg config.Config, args []string) error {
+	if len(args) == 0 || (args[0] != "catalog" && args[0] != "events") {
+		return errUsage
+	}
+	flags := flag.NewFlagSet("import "+args[0], flag.ExitOnError)
+	file := flags.String("file", "-", "file to import, - for stdin")
+	dryRun := flags.Bool("dry-run", false, "validate events without importing them")
+	flags.Parse(args[1:])
+
+	var r io.ReadCloser = os.Stdin
+	if *file != "-" {
+		f, err := os.Open(*file)
+		if err != nil {
+			return fmt.Errorf("failed to open %s: %w", *file, err)
+		}
+		r = f
+	}
+	defer r.Close()
+
+	db, rdc, err := connect(cfg)
+	if err != nil {
+		return err
+	}
+
+	if args[0] == "catalog" {
+		report, err := services.NewProductService(db, cfg).ImportCatalog(ctx, r)
+		if printErr := printJSON(report); printErr != nil {
+			return printErr
+		}
+		if err != nil {
+			return fmt.Errorf("failed to import catalog: %w", err)
+		}
+		return nil
+	}
+
+	report, err := services.NewRecommendationService(db, rdc, cfg).ImportEvents(ctx, r, services.EventImportOptions{
+		DryRun: *dryRun,
+		Progress: func(report models.EventImportReport) {
+			fmt.Fprintf(os.Stderr, "read %d, imported %d, duplicates %d, rejected %d\n",
+				report.Read, report.Imported, report.Duplicates, report.Rejected)
+		},
+	})
+	if printErr := printJSON(report); printErr != nil {
+		return printErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to import events: %w", err)
+	}
+	return nil
+}
+
+// evaluate scores every user, or a sample of them, with the configured
+// weights, or the ones given, and reports how the lists would differ from the
+// active snapshot.
+func evaluate(ctx context.Context, cfg config.Config, args []string) error {
+	if len(args) > 0 && args[0] == "offline" {
+		return evaluateOffline(ctx, cfg, args[1:])
+	}
+
+	weights := cfg.Scoring.Personal
+	flags := flag.NewFlagSet("evaluate", flag.ExitOnError)
+	weightFlags(flags, &weights)
+	topK := flags.Int("k", 10, "number of top products compared per user")
+	sample := flags.Int("sample", 0, "number of randomly chosen users to score (default: all)")
+	flags.Parse(args)
+	if *topK <= 0 {
+		*topK = 10
+	}
+
+	service, err := recommendationService(cfg)
+	if err != nil {
+		return err
+	}
+	report, err := service.DryRunRebuild(ctx, weights, *topK, *sample)
+	if err != nil {
+		return fmt.Errorf("failed to evaluate weights: %w", err)
+	}
+	return printJSON(report)
+}
+
+// evaluateOffline fits scoring strategies on older events and reports how
+// well they predict the newer ones.
+func evaluateOffline(ctx context.Context, cfg config.Config, args []string) error {
+	scoring := cfg.Scoring
+	opts := evaluation.Options{}
+	flags := flag.NewFlagSet("evaluate offline", flag.ExitOnError)
+	weightFlags(flags, &scoring.Personal)
+	flags.IntVar(&opts.K, "k", 10, "length of the scored lists")
+	flags.Float64Var(&opts.TestFraction, "test-fraction", 0.2, "share of the newest events held out")
+	relevant := flags.String("relevant", "", "comma-separated event types that make a held-out product relevant (default: all)")
+	strategies := flags.String("strategies", strings.Join([]string{evaluation.StrategyPersonal, evaluation.StrategyTrending, evaluation.StrategyPersonalTrending}, ","), "comma-separated strategies to compare")
+	events := flags.String("events", "", "NDJSON event dump to evaluate on instead of the events collection")
+	flags.Parse(args)
+	if *relevant != "" {
+		opts.RelevantTypes = strings.Split(*relevant, ",")
+	}
+
+	var candidates []evaluation.Strategy
+	for _, name := range strings.Split(*strategies, ",") {
+		strategy, err := evaluation.NewStrategy(strings.TrimSpace(name), scoring)
+		if err != nil {
+			return err
+		}
+		candidates = append(candidates, strategy)
+	}
+
+	var dataset []models.UserActivity
+	if *events != "" {
+		f, err := os.Open(*events)
+		if err != nil {
+			return fmt.Errorf("failed to open %s: %w", *events, err)
+		}
+		defer f.Close()
+		if dataset, err = evaluation.LoadEventsNDJSON(f); err != nil {
+			return fmt.Errorf("failed to read %s: %w", *events, err)
+		}
+	} else {
+		db, _, err := connect(cfg)
+		if err != nil {
+			return err
+		}
+		if dataset, err = evaluation.LoadEvents(ctx, db); err != nil {
+			return fmt.Errorf("failed to load events: %w", err)
+		}
+	}
+	fmt.Fprintf(os.Stderr, "Evaluating on %d events\n", len(dataset))
+
+	report, err := evaluation.Evaluate(dataset, candidates, opts)
+	if err != nil {
+		return fmt.Errorf("failed to evaluate: %w", err)
+	}
+	return printJSON(report)
+}
+
+// weightFlags lets the personal weights be overridden from the command line.
+func weightFlags(flags *flag.FlagSet, weights *config.ScoringWeights) {
+	flags.Float64Var(&weights.View, "view-weight", weights.View, "weight of VIEW events")
+	flags.Float64Var(&weights.CartAdd, "cart-add-weight", weights.CartAdd, "weight of CART_ADD events")
+	flags.Float64Var(&weights.Purchase, "purchase-weight", weights.Purchase, "weight of PURCHASE events")
+}
+
+// generate writes synthetic products and events to Mongo or NDJSON, or
+// replays the events against the event endpoint.
+func generate(ctx context.Context, cfg config.Config, args []string) error {
+	opts := synthetic.DefaultOptions()
+	flags := flag.NewFlagSet("generate", flag.ExitOnError)
+	flags.Int64Var(&opts.Seed, "seed", opts.Seed, "random seed; the same seed gives the same data")
+	flags.IntVar(&opts.Users, "users", opts.Users, "number of users")
+	flags.IntVar(&opts.Products, "products", opts.Products, "number of products")
+	flags.IntVar(&opts.Categories, "categories", opts.Categories, "number of product categories")
+	flags.IntVar(&opts.Days, "days", opts.Days, "number of days of activity")
+	start := flags.String("start", "", "first day of activity as an RFC 3339 time (default: -days before today)")
+	flags.Float64Var(&opts.SessionsPerUser, "sessions", opts.SessionsPerUser, "mean sessions per user")
+	flags.Float64Var(&opts.ViewsPerSession, "views", opts.ViewsPerSession, "mean views per session")
+	flags.Float64Var(&opts.ZipfS, "zipf", opts.ZipfS, "Zipf exponent of product popularity, above 1")
+	flags.Float64Var(&opts.CategoryAffinity, "affinity", opts.CategoryAffinity, "chance a view stays in the session's category")
+	flags.Float64Var(&opts.CartRate, "cart-rate", opts.CartRate, "chance a view leads to a cart add")
+	flags.Float64Var(&opts.PurchaseRate, "purchase-rate", opts.PurchaseRate, "chance a cart add is bought")
+	flags.Float64Var(&opts.Seasonality, "seasonality", opts.Seasonality, "strength of the evening and weekend peaks, 0 to 1")
+	to := flags.String("to", "ndjson", "mongo, ndjson or replay")
+	out := flags.String("out", "-", "event file for -to ndjson, - for stdout")
+	productsOut := flags.String("products-out", "", "also write the products as NDJSON to this file")
+	url := flags.String("url", "http://localhost:8000", "service URL for -to replay")
+	rate := flags.Float64("rate", 50, "events per second for -to replay, 0 for no limit")
+	concurrency := flags.Int("concurrency", 8, "requests in flight for -to replay")
+	flags.Parse(args)
+
+	if *start != "" {
+		at, err := time.Parse(time.RFC3339, *start)
+		if err != nil {
+			return fmt.Errorf("invalid -start: %w", err)
+		}
+		opts.Start = at
+	} else {
+		opts.Start = time.Now().UTC().AddDate(0, 0, -opts.Days).Truncate(24 * time.Hour)
+	}
+	if *to != "mongo" && *to != "ndjson" && *to != "replay" {
+		return fmt.Errorf("invalid -to %q", *to)
+	}
+
+	dataset, err := synthetic.Generate(opts)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "Generated %d products, %d users and %d events\n", len(dataset.Products), len(dataset.Users), len(dataset.Events))
+
+	if *productsOut != "" {
+		if err := writeFile(*productsOut, func(w io.Writer) error { return synthetic.WriteProductsNDJSON(w, dataset.Products) }); err != nil {
+			return err
+		}
+	}
+
+	switch *to {
+	case "ndjson":
+		return writeFile(*out, func(w io.Writer) error { return synthetic.WriteEventsNDJSON(w, dataset.Events) })
+	case "replay":
+		report, err := synthetic.Replay(ctx, http.DefaultClient, dataset.Events, synthetic.ReplayOptions{
+			URL:         *url,
+			Rate:        *rate,
+			Concurrency: *concurrency,
+			Progress: func(report models.ReplayReport) {
+				fmt.Fprintf(os.Stderr, "sent %d, failed %d, %.1f events/s\n", report.Sent, report.Failed, report.Rate)
+			},
+		})
+		if printErr := printJSON(report); printErr != nil {
+			return printErr
+		}
+		if err != nil {
+			return fmt.Errorf("failed to replay events: %w", err)
+		}
+		return nil
+	}
+
+	db, rdc, err := connect(cfg)
+	if err != nil {
+		return err
+	}
+	if _, err := services.NewProductService(db, cfg).UpsertProducts(ctx, dataset.Products); err != nil {
+		return fmt.Errorf("failed to store products: %w", err)
+	}
+
+	// events go through the backfill, which batches and deduplicates them,
+	// so generating again with the same seed adds nothing
+	r, w := io.Pipe()
+	go func() { w.CloseWithError(synthetic.WriteEventsNDJSON(w, dataset.Events)) }()
+	report, err := services.NewRecommendationService(db, rdc, cfg).ImportEvents(ctx, r, services.EventImportOptions{})
+	r.Close()
+	if printErr := printJSON(report); printErr != nil {
+		return printErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to store events: %w", err)
+	}
+	return nil
+}
+
+// writeFile writes to the named file, or to stdout for "-".
+func writeFile(name string, write func(io.Writer) error) error {
+	if name == "-" {
+		return write(os.Stdout)
+	}
+
+	f, err := os.Create(name)
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %w", name, err)
+	}
+	if err := write(f); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to write %s: %w", name, err)
+	}
+	return f.Close()
+}
+
+func inspect(ctx context.Context, cfg config.Config, args []string) error {
+	if len(args) != 2 || args[0] != "user" {
+		return errUsage
+	}
+
+	service, err := recommendationService(cfg)
+	if err != nil {
+		return err
+	}
+	inspection, err := service.InspectUser(ctx, args[1])
+	if err != nil {
+		return fmt.Errorf("failed to inspect user %s: %w", args[1], err)
+	}
+	return printJSON(inspection)
+}
+
+func show(cfg config.Config, args []string) error {
+	if len(args) != 1 || args[0] != "config" {
+		return errUsage
+	}
+
+	cfg.Database.Password = redacted(cfg.Database.Password)
+	cfg.Messaging.Password = redacted(cfg.Messaging.Password)
+	return printJSON(printable(reflect.ValueOf(cfg)))
+}
+
+func redacted(secret string) string {
+	if secret == "" {
+		return ""
+	}
+	return "********"
+}
+
+// printable converts a config value for printing, spelling durations as
+// "30s" rather than nanoseconds.
+func printable(value reflect.Value) interface{} {
+	if value.Type() == reflect.TypeOf(time.Duration(0)) {
+		return value.Interface().(time.Duration).String()
+	}
+
+	switch value.Kind() {
+	case reflect.Struct:
+		fields := map[string]interface{}{}
+		for i := 0; i < value.NumField(); i++ {
+			if field := value.Type().Field(i); field.IsExported() {
+				fields[field.Name] = printable(value.Field(i))
+			}
+		}
+		return fields
+	case reflect.Map:
+		entries := map[string]interface{}{}
+		iter := value.MapRange()
+		for iter.Next() {
+			entries[fmt.Sprint(iter.Key().Interface())] = printable(iter.Value())
+		}
+		return entries
+	case reflect.Slice:
+		items := make([]interface{}, value.Len())
+		for i := range items {
+			items[i] = printable(value.Index(i))
+		}
+		return items
+	case reflect.Ptr:
+		if value.IsNil() {
+			return nil
+		}
+		return printable(value.Elem())
+	default:
+		return value.Interface()
+	}
+}
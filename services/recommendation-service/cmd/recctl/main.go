@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"polyforge-recommendation/internal/config"
+	"polyforge-recommendation/internal/services"
+
+	"github.com/redis/go-redis/v9"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+const usage = `Usage: recctl <command> [flags]
+
+Commands:
+  rebuild [-user ID]          rebuild every list into a new snapshot, or one user's list
+  cache clear                 delete cached user lists, blends and trending
+  export [flags]              write stored user lists or trending as NDJSON or CSV
+  import catalog|events       bulk load a catalog dump or backfill historical events
+  evaluate [flags]            score with other weights and compare with the active snapshot
+  evaluate offline [flags]    measure strategies on held-out events (precision, recall, NDCG, ...)
+  generate [flags]            generate synthetic products and events for development and load tests
+  inspect user ID             show a user's stored and cached lists and recent events
+  show config                 print the configuration, without secrets
+
+Run "recctl <command> -h" for the flags of a command.
+`
+
+// errUsage reports arguments that match no command.
+var errUsage = errors.New("invalid arguments")
+
+// recctl runs operator tasks with the service's own configuration and code,
+// directly against its Mongo and Redis rather than through the HTTP API.
+func main() {
+	log.SetFlags(0)
+	flag.Usage = func() { fmt.Fprint(os.Stderr, usage) }
+	flag.Parse()
+	args := flag.Args()
+	if len(args) == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	cfg := config.LoadConfig()
+	ctx := context.Background()
+
+	var err error
+	switch args[0] {
+	case "rebuild":
+		err = rebuild(ctx, cfg, args[1:])
+	case "cache":
+		err = cache(ctx, cfg, args[1:])
+	case "export":
+		err = export(ctx, cfg, args[1:])
+	case "import":
+		err = importData(ctx, cfg, args[1:])
+	case "evaluate":
+		err = evaluate(ctx, cfg, args[1:])
+	case "generate":
+		err = generate(ctx, cfg, args[1:])
+	case "inspect":
+		err = inspect(ctx, cfg, args[1:])
+	case "show":
+		err = show(cfg, args[1:])
+	default:
+		err = errUsage
+	}
+
+	if errors.Is(err, errUsage) {
+		flag.Usage()
+		os.Exit(2)
+	} else if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// connect opens the service's Mongo database and Redis client.
+func connect(cfg config.Config) (*mongo.Database, *redis.Client, error) {
+	dbc, err := mongo.Connect(options.Client().ApplyURI(cfg.GetDatabaseURI()))
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to connect to database: %w", err)
+	}
+	return dbc.Database(cfg.Database.DatabaseName), redis.NewClient(&redis.Options{Addr: cfg.GetCacheAddress()}), nil
+}
+
+func recommendationService(cfg config.Config) (*services.RecommendationService, error) {
+	db, rdc, err := connect(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return services.NewRecommendationService(db, rdc, cfg), nil
+}
+
+// printJSON writes a command's result to stdout; progress and errors go to
+// stderr so the output can be piped.
+func printJSON(value interface{}) error {
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(value)
+}
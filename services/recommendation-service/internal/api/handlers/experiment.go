@@ -0,0 +1,170 @@
+package handlers
+
+import (
+	"errors"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/gofiber/fiber/v2"
+
+	"polyforge-recommendation/internal/models"
+	"polyforge-recommendation/internal/services"
+)
+
+type ExperimentHandlers struct {
+	service   *services.ExperimentService
+	validator *validator.Validate
+}
+
+// NewExperimentHandlers serves the experiments of the given service, which
+// must be the one the recommendation handlers assign users with so that
+// starting or stopping an experiment resets its running experiments.
+func NewExperimentHandlers(service *services.ExperimentService) *ExperimentHandlers {
+	return &ExperimentHandlers{
+		service:   service,
+		validator: validator.New(),
+	}
+}
+
+type ExperimentPayload struct {
+	Name       string                     `json:"name" validate:"required"`
+	Placements []string                   `json:"placements"`
+	Variants   []ExperimentVariantPayload `json:"variants" validate:"required,min=2,dive"`
+}
+
+type ExperimentVariantPayload struct {
+	Name            string             `json:"name" validate:"required"`
+	Weight          int                `json:"weight" validate:"gt=0"`
+	BlendMode       string             `json:"blendMode" validate:"omitempty,oneof=weighted interleave"`
+	BlendWeights    map[string]float64 `json:"blendWeights"`
+	DiversityMethod string             `json:"diversityMethod" validate:"omitempty,oneof=none mmr category_cap"`
+	DiversityLambda *float64           `json:"diversityLambda" validate:"omitempty,gte=0,lte=1"`
+}
+
+func (p ExperimentPayload) experiment() models.Experiment {
+	variants := make([]models.ExperimentVariant, len(p.Variants))
+	for i, variant := range p.Variants {
+		variants[i] = models.ExperimentVariant{
+			Name:   variant.Name,
+			Weight: variant.Weight,
+			Strategy: models.VariantStrategy{
+				BlendMode:       variant.BlendMode,
+				BlendWeights:    variant.BlendWeights,
+				DiversityMethod: variant.DiversityMethod,
+				DiversityLambda: variant.DiversityLambda,
+			},
+		}
+	}
+	return models.Experiment{Name: p.Name, Placements: p.Placements, Variants: variants}
+}
+
+func experimentErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, services.ErrExperimentNotFound):
+		return fiber.StatusNotFound
+	case errors.Is(err, services.ErrInvalidExperiment):
+		return fiber.StatusBadRequest
+	default:
+		return fiber.StatusInternalServerError
+	}
+}
+
+func (h *ExperimentHandlers) ListExperimentsHandler() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		experiments, err := h.service.ListExperiments(c.Context())
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"message": "Failed to get experiments: " + err.Error(),
+				"data":    nil,
+			})
+		}
+
+		return c.JSON(fiber.Map{
+			"message": "Experiments fetched successfully",
+			"data":    experiments,
+		})
+	}
+}
+
+func (h *ExperimentHandlers) GetExperimentHandler() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		experiment, err := h.service.GetExperiment(c.Context(), c.Params("experimentID"))
+		if err != nil {
+			return c.Status(experimentErrorStatus(err)).JSON(fiber.Map{
+				"message": "Failed to get experiment: " + err.Error(),
+				"data":    nil,
+			})
+		}
+
+		return c.JSON(fiber.Map{
+			"message": "Experiment fetched successfully",
+			"data":    experiment,
+		})
+	}
+}
+
+func (h *ExperimentHandlers) CreateExperimentHandler() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		payload := new(ExperimentPayload)
+		if err := c.BodyParser(payload); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"message": "Invalid request payload: " + err.Error(),
+				"data":    nil,
+			})
+		}
+
+		if err := h.validator.Struct(payload); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"message": "Validation failed: " + err.Error(),
+				"data":    nil,
+			})
+		}
+
+		experiment, err := h.service.CreateExperiment(c.Context(), payload.experiment())
+		if err != nil {
+			return c.Status(experimentErrorStatus(err)).JSON(fiber.Map{
+				"message": "Failed to create experiment: " + err.Error(),
+				"data":    nil,
+			})
+		}
+
+		return c.Status(fiber.StatusCreated).JSON(fiber.Map{
+			"message": "Experiment created successfully",
+			"data":    experiment,
+		})
+	}
+}
+
+func (h *ExperimentHandlers) StopExperimentHandler() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		experiment, err := h.service.StopExperiment(c.Context(), c.Params("experimentID"))
+		if err != nil {
+			return c.Status(experimentErrorStatus(err)).JSON(fiber.Map{
+				"message": "Failed to stop experiment: " + err.Error(),
+				"data":    nil,
+			})
+		}
+
+		return c.JSON(fiber.Map{
+			"message": "Experiment stopped successfully",
+			"data":    experiment,
+		})
+	}
+}
+
+// GetExperimentResultsHandler reports CTR and conversion rate per variant.
+func (h *ExperimentHandlers) GetExperimentResultsHandler() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		results, err := h.service.Results(c.Context(), c.Params("experimentID"))
+		if err != nil {
+			return c.Status(experimentErrorStatus(err)).JSON(fiber.Map{
+				"message": "Failed to get experiment results: " + err.Error(),
+				"data":    nil,
+			})
+		}
+
+		return c.JSON(fiber.Map{
+			"message": "Experiment results fetched successfully",
+			"data":    results,
+		})
+	}
+}
@@ -0,0 +1,188 @@
+package handlers
+
+import (
+	"errors"
+	"time"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/gofiber/fiber/v2"
+
+	"polyforge-recommendation/internal/models"
+	"polyforge-recommendation/internal/services"
+)
+
+type MerchandisingHandlers struct {
+	service   *services.MerchandisingService
+	validator *validator.Validate
+}
+
+// NewMerchandisingHandlers serves the rules of the given service, which must
+// be the one the recommendation handlers apply so that rule changes reset
+// its cached rules.
+func NewMerchandisingHandlers(service *services.MerchandisingService) *MerchandisingHandlers {
+	return &MerchandisingHandlers{
+		service:   service,
+		validator: validator.New(),
+	}
+}
+
+type MerchandisingRulePayload struct {
+	Name       string     `json:"name" validate:"required"`
+	Action     string     `json:"action" validate:"required,oneof=PIN BOOST BURY BLOCK"`
+	Placements []string   `json:"placements"`
+	ProductIDs []string   `json:"productIds" validate:"dive,uuid4"`
+	Brands     []string   `json:"brands"`
+	Categories []string   `json:"categories"`
+	Tags       []string   `json:"tags"`
+	Position   int        `json:"position" validate:"gte=0"`
+	Factor     float64    `json:"factor" validate:"gte=0"`
+	StartsAt   *time.Time `json:"startsAt"`
+	EndsAt     *time.Time `json:"endsAt"`
+	Enabled    *bool      `json:"enabled"`
+}
+
+func (p MerchandisingRulePayload) rule() models.MerchandisingRule {
+	return models.MerchandisingRule{
+		Name:       p.Name,
+		Action:     p.Action,
+		Placements: p.Placements,
+		Match: models.RuleMatch{
+			ProductIDs: p.ProductIDs,
+			Brands:     p.Brands,
+			Categories: p.Categories,
+			Tags:       p.Tags,
+		},
+		Position: p.Position,
+		Factor:   p.Factor,
+		StartsAt: p.StartsAt,
+		EndsAt:   p.EndsAt,
+		// rules are enabled unless explicitly disabled
+		Enabled: p.Enabled == nil || *p.Enabled,
+	}
+}
+
+// parseRulePayload reads and validates a rule payload, writing the error
+// response itself when the payload is invalid.
+func (h *MerchandisingHandlers) parseRulePayload(c *fiber.Ctx) (*MerchandisingRulePayload, error) {
+	payload := new(MerchandisingRulePayload)
+	if err := c.BodyParser(payload); err != nil {
+		return nil, c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"message": "Invalid request payload: " + err.Error(),
+			"data":    nil,
+		})
+	}
+
+	if err := h.validator.Struct(payload); err != nil {
+		return nil, c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"message": "Validation failed: " + err.Error(),
+			"data":    nil,
+		})
+	}
+	return payload, nil
+}
+
+func ruleErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, services.ErrRuleNotFound):
+		return fiber.StatusNotFound
+	case errors.Is(err, services.ErrInvalidRule):
+		return fiber.StatusBadRequest
+	default:
+		return fiber.StatusInternalServerError
+	}
+}
+
+func (h *MerchandisingHandlers) ListRulesHandler() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		rules, err := h.service.ListRules(c.Context())
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"message": "Failed to get merchandising rules: " + err.Error(),
+				"data":    nil,
+			})
+		}
+
+		return c.JSON(fiber.Map{
+			"message": "Merchandising rules fetched successfully",
+			"data":    rules,
+		})
+	}
+}
+
+func (h *MerchandisingHandlers) GetRuleHandler() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		rule, err := h.service.GetRule(c.Context(), c.Params("ruleID"))
+		if err != nil {
+			return c.Status(ruleErrorStatus(err)).JSON(fiber.Map{
+				"message": "Failed to get merchandising rule: " + err.Error(),
+				"data":    nil,
+			})
+		}
+
+		return c.JSON(fiber.Map{
+			"message": "Merchandising rule fetched successfully",
+			"data":    rule,
+		})
+	}
+}
+
+func (h *MerchandisingHandlers) CreateRuleHandler() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		payload, err := h.parseRulePayload(c)
+		if payload == nil {
+			return err
+		}
+
+		rule, err := h.service.CreateRule(c.Context(), payload.rule())
+		if err != nil {
+			return c.Status(ruleErrorStatus(err)).JSON(fiber.Map{
+				"message": "Failed to create merchandising rule: " + err.Error(),
+				"data":    nil,
+			})
+		}
+
+		return c.Status(fiber.StatusCreated).JSON(fiber.Map{
+			"message": "Merchandising rule created successfully",
+			"data":    rule,
+		})
+	}
+}
+
+func (h *MerchandisingHandlers) UpdateRuleHandler() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		payload, err := h.parseRulePayload(c)
+		if payload == nil {
+			return err
+		}
+
+		rule, err := h.service.UpdateRule(c.Context(), c.Params("ruleID"), payload.rule())
+		if err != nil {
+			return c.Status(ruleErrorStatus(err)).JSON(fiber.Map{
+				"message": "Failed to update merchandising rule: " + err.Error(),
+				"data":    nil,
+			})
+		}
+
+		return c.JSON(fiber.Map{
+			"message": "Merchandising rule updated successfully",
+			"data":    rule,
+		})
+	}
+}
+
+func (h *MerchandisingHandlers) DeleteRuleHandler() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		ruleID := c.Params("ruleID")
+		if err := h.service.DeleteRule(c.Context(), ruleID); err != nil {
+			return c.Status(ruleErrorStatus(err)).JSON(fiber.Map{
+				"message": "Failed to delete merchandising rule: " + err.Error(),
+				"data":    nil,
+			})
+		}
+
+		return c.JSON(fiber.Map{
+			"message": "Merchandising rule deleted successfully",
+			"data":    fiber.Map{"id": ruleID},
+		})
+	}
+}
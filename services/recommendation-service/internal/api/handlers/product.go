@@ -0,0 +1,165 @@
+package handlers
+
+import (
+	"github.com/go-playground/validator/v10"
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+
+	"polyforge-recommendation/internal/config"
+	"polyforge-recommendation/internal/models"
+	"polyforge-recommendation/internal/services"
+)
+
+type ProductHandlers struct {
+	service   *services.ProductService
+	validator *validator.Validate
+}
+
+func NewProductHandlers(db *mongo.Database, cfg config.Config) *ProductHandlers {
+	return &ProductHandlers{
+		service:   services.NewProductService(db, cfg),
+		validator: validator.New(),
+	}
+}
+
+type ProductPayload struct {
+	ProductID   string   `json:"productId" validate:"required,uuid4"`
+	SKU         string   `json:"sku"`
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	Category    string   `json:"category"`
+	Brand       string   `json:"brand"`
+	Tags        []string `json:"tags"`
+	Price       float64  `json:"price" validate:"gte=0"`
+	Currency    string   `json:"currency"`
+	ImageURL    string   `json:"imageUrl"`
+}
+
+// UpsertProductsHandler receives catalog data for a batch of products.
+func (h *ProductHandlers) UpsertProductsHandler() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		var payload []ProductPayload
+		if err := c.BodyParser(&payload); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"message": "Invalid request payload: " + err.Error(),
+				"data":    nil,
+			})
+		}
+
+		products := make([]models.Product, 0, len(payload))
+		for _, item := range payload {
+			if err := h.validator.Struct(item); err != nil {
+				return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+					"message": "Validation failed: " + err.Error(),
+					"data":    nil,
+				})
+			}
+			products = append(products, models.Product{
+				ProductID:   item.ProductID,
+				SKU:         item.SKU,
+				Name:        item.Name,
+				Description: item.Description,
+				Category:    item.Category,
+				Brand:       item.Brand,
+				Tags:        item.Tags,
+				Price:       item.Price,
+				Currency:    item.Currency,
+				ImageURL:    item.ImageURL,
+			})
+		}
+
+		count, err := h.service.UpsertProducts(c.Context(), products)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"message": "Failed to store products: " + err.Error(),
+				"data":    nil,
+			})
+		}
+
+		return c.JSON(fiber.Map{
+			"message": "Products stored successfully",
+			"data":    fiber.Map{"count": count},
+		})
+	}
+}
+
+// ImportCatalogHandler bulk loads a catalog dump sent as a JSON array or as
+// NDJSON with one product per line. The dump is read as it streams in, so
+// it is not bounded by the body limit of the other routes.
+func (h *ProductHandlers) ImportCatalogHandler() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		report, err := h.service.ImportCatalog(c.Context(), importBody(c))
+		if err != nil {
+			c.Context().SetConnectionClose()
+			return c.Status(importErrorStatus(err)).JSON(fiber.Map{
+				"message": "Failed to import catalog: " + err.Error(),
+				"data":    report,
+			})
+		}
+
+		return c.JSON(fiber.Map{
+			"message": "Catalog imported successfully",
+			"data":    report,
+		})
+	}
+}
+
+type ProductStockPayload struct {
+	Available *int `json:"available" validate:"required"`
+}
+
+// UpdateStockHandler receives the available stock of a product.
+func (h *ProductHandlers) UpdateStockHandler() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		payload := new(ProductStockPayload)
+		if err := c.BodyParser(payload); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"message": "Invalid request payload: " + err.Error(),
+				"data":    nil,
+			})
+		}
+
+		if err := h.validator.Struct(payload); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"message": "Validation failed: " + err.Error(),
+				"data":    nil,
+			})
+		}
+
+		productID := c.Params("productID")
+		if err := h.service.UpdateStock(c.Context(), productID, *payload.Available); err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"message": "Failed to update product stock: " + err.Error(),
+				"data":    nil,
+			})
+		}
+
+		return c.JSON(fiber.Map{
+			"message": "Product stock updated successfully",
+			"data":    fiber.Map{"productId": productID, "available": *payload.Available},
+		})
+	}
+}
+
+func (h *ProductHandlers) GetProductHandler() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		product, err := h.service.GetProduct(c.Context(), c.Params("productID"))
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"message": "Failed to get product: " + err.Error(),
+				"data":    nil,
+			})
+		}
+		if product == nil {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"message": "Product not found",
+				"data":    nil,
+			})
+		}
+
+		return c.JSON(fiber.Map{
+			"message": "Product fetched successfully",
+			"data":    product,
+		})
+	}
+}
@@ -1,79 +1,295 @@
 package handlers
 
 import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/go-playground/validator/v10"
 	"github.com/gofiber/fiber/v2"
-	"github.com/redis/go-redis/v9"
-	"go.mongodb.org/mongo-driver/v2/mongo"
 
 	"polyforge-recommendation/internal/config"
+	"polyforge-recommendation/internal/models"
 	"polyforge-recommendation/internal/services"
 )
 
 type RecommendationHandlers struct {
 	service   *services.RecommendationService
 	validator *validator.Validate
+	cfg       config.Config
 }
 
-func NewRecommendationHandlers(db *mongo.Database, cache *redis.Client, cfg config.Config) *RecommendationHandlers {
+func NewRecommendationHandlers(service *services.RecommendationService, cfg config.Config) *RecommendationHandlers {
 	return &RecommendationHandlers{
-		service:   services.NewRecommendationService(db, cache, cfg),
+		service:   service,
 		validator: validator.New(),
+		cfg:       cfg,
 	}
 }
 
 func (h *RecommendationHandlers) GetRecommendationsHandler() fiber.Handler {
 	return func(c *fiber.Ctx) error {
 		userID := c.Locals("userID").(string)
-		limit := 10
-		if l := c.Query("limit"); l != "" {
-			if parsedLimit, err := strconv.Atoi(l); err == nil && parsedLimit > 0 {
-				limit = parsedLimit
-			}
+		query, err := parseRecommendationQuery(c)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"message": "Invalid query: " + err.Error(),
+				"data":    nil,
+			})
 		}
 
-		recommendations, err := h.service.GetUserRecommendations(c.Context(), userID, limit)
+		query.Experiment = h.service.AssignExperiment(c.Context(), userID, query.PlacementOr(services.PlacementPersonal))
+
+		recommendations, nextCursor, cacheStatus, err := h.service.GetUserRecommendations(c.Context(), userID, query)
 		if err != nil {
-			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			return c.Status(pageErrorStatus(err)).JSON(fiber.Map{
 				"message": "Failed to get recommendations: " + err.Error(),
 				"data":    nil,
 			})
 		}
 
-		go h.service.SaveUserRecommendation(c.Context(), recommendations)
+		c.Set("X-Cache", cacheStatus)
+		h.service.RecordExperimentImpression(query.Experiment)
+		return c.JSON(listResponse(query, fiber.Map{
+			"message":    "Recommendations fetched successfully",
+			"data":       recommendations,
+			"nextCursor": nullableCursor(nextCursor),
+		}))
+	}
+}
+
+func (h *RecommendationHandlers) GetTrendingRecommendationHandler() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		query, err := parseRecommendationQuery(c)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"message": "Invalid query: " + err.Error(),
+				"data":    nil,
+			})
+		}
+
+		// without paging parameters the whole list is returned, as before
+		if c.Query("limit") == "" && c.Query("cursor") == "" {
+			query.Limit = math.MaxInt32
+		}
+
+		userID, _ := c.Locals("userID").(string)
+		query.Experiment = h.service.AssignExperiment(c.Context(), userID, query.PlacementOr(services.PlacementTrending))
+
+		data, nextCursor, err := h.service.GetTrendingPage(c.Context(), query)
+		if err != nil {
+			return c.Status(pageErrorStatus(err)).JSON(fiber.Map{
+				"message": "Failed to get trending recommendations: " + err.Error(),
+				"data":    nil,
+			})
+		}
+
+		h.service.RecordExperimentImpression(query.Experiment)
+		return c.JSON(listResponse(query, fiber.Map{
+			"message":    "Trending recommendations fetched successfully",
+			"data":       data,
+			"nextCursor": nullableCursor(nextCursor),
+		}))
+	}
+}
+
+// GetSimilarProductsHandler returns the products closest to a product by
+// catalog attributes.
+func (h *RecommendationHandlers) GetSimilarProductsHandler() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		query, err := parseRecommendationQuery(c)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"message": "Invalid query: " + err.Error(),
+				"data":    nil,
+			})
+		}
+
+		userID, _ := c.Locals("userID").(string)
+		query.Experiment = h.service.AssignExperiment(c.Context(), userID, query.PlacementOr(services.PlacementSimilar))
+
+		data, nextCursor, err := h.service.GetSimilarProducts(c.Context(), c.Params("productID"), query)
+		if err != nil {
+			return c.Status(pageErrorStatus(err)).JSON(fiber.Map{
+				"message": "Failed to get similar products: " + err.Error(),
+				"data":    nil,
+			})
+		}
+
+		h.service.RecordExperimentImpression(query.Experiment)
+		return c.JSON(listResponse(query, fiber.Map{
+			"message":    "Similar products fetched successfully",
+			"data":       data,
+			"nextCursor": nullableCursor(nextCursor),
+		}))
+	}
+}
+
+// GetPlacementRecommendationsHandler serves a registered placement. The
+// caller passes the surface's context: the user (header or userId), the
+// product shown (productId) and the cart (cart, comma-separated).
+func (h *RecommendationHandlers) GetPlacementRecommendationsHandler() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		query, err := parseRecommendationQuery(c)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"message": "Invalid query: " + err.Error(),
+				"data":    nil,
+			})
+		}
+		// without a limit the placement's own applies
+		if c.Query("limit") == "" {
+			query.Limit = 0
+		}
+
+		userID, _ := c.Locals("userID").(string)
+		placement := services.PlacementContext{
+			UserID:    c.Query("userId", userID),
+			ProductID: c.Query("productId"),
+			Cart:      splitQueryList(c.Query("cart")),
+		}
+		query.Placement = c.Params("placement")
+		query.Experiment = h.service.AssignExperiment(c.Context(), placement.UserID, query.Placement)
+
+		data, nextCursor, err := h.service.GetPlacementRecommendations(c.Context(), query.Placement, placement, query)
+		if errors.Is(err, services.ErrUnknownPlacement) {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"message": "Failed to get placement recommendations: " + err.Error(),
+				"data":    nil,
+			})
+		} else if err != nil {
+			return c.Status(pageErrorStatus(err)).JSON(fiber.Map{
+				"message": "Failed to get placement recommendations: " + err.Error(),
+				"data":    nil,
+			})
+		}
+
+		h.service.RecordExperimentImpression(query.Experiment)
+		return c.JSON(listResponse(query, fiber.Map{
+			"message":    "Placement recommendations fetched successfully",
+			"data":       data,
+			"nextCursor": nullableCursor(nextCursor),
+		}))
+	}
+}
+
+func (h *RecommendationHandlers) RebuildRecommendationsHandler() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if c.QueryBool("dryRun") {
+			return h.dryRunRebuild(c)
+		}
+
+		version, err := h.service.CreateSnapshot(c.Context())
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"message": "Failed to start recommendation rebuilding: " + err.Error(),
+				"data":    nil,
+			})
+		}
+
+		// the request context is recycled once the response is sent
+		go h.service.ReCalculateUserRecommendations(context.Background(), version)
+
 		return c.JSON(fiber.Map{
-			"message": "Recommendations fetched successfully",
-			"data":    recommendations,
+			"message": "Recommendation rebuilding started",
+			"data":    fiber.Map{"version": version},
 		})
 	}
 }
 
-func (h *RecommendationHandlers) GetTrendingRecommendationHandler() fiber.Handler {
+// dryRunRebuild scores a sample of users with the configured weights, or
+// with the weights given in the query, and reports how the result differs
+// from the active snapshot. The sample is required and capped so the request
+// stays short; recctl evaluate compares every user.
+func (h *RecommendationHandlers) dryRunRebuild(c *fiber.Ctx) error {
+	sample := c.QueryInt("sample", 0)
+	if maxSample := h.cfg.Snapshot.DryRunMaxSample; sample <= 0 || sample > maxSample {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"message": fmt.Sprintf("Invalid sample: a dry run scores between 1 and %d users", maxSample),
+			"data":    nil,
+		})
+	}
+
+	weights := h.cfg.Scoring.Personal
+	weights.View = c.QueryFloat("viewWeight", weights.View)
+	weights.CartAdd = c.QueryFloat("cartAddWeight", weights.CartAdd)
+	weights.Purchase = c.QueryFloat("purchaseWeight", weights.Purchase)
+
+	topK := c.QueryInt("k", 10)
+	if topK <= 0 {
+		topK = 10
+	}
+
+	report, err := h.service.DryRunRebuild(c.Context(), weights, topK, sample)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"message": "Failed to run recommendation rebuild dry run: " + err.Error(),
+			"data":    nil,
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"message": "Recommendation rebuild dry run completed",
+		"data":    report,
+	})
+}
+
+func (h *RecommendationHandlers) GetSnapshotsHandler() fiber.Handler {
 	return func(c *fiber.Ctx) error {
-		data, err := h.service.GetTrendingRecommendations(c.Context())
+		data, err := h.service.ListSnapshots(c.Context())
 		if err != nil {
 			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-				"message": "Failed to get trending recommendations: " + err.Error(),
+				"message": "Failed to get recommendation snapshots: " + err.Error(),
 				"data":    nil,
 			})
 		}
 
 		return c.JSON(fiber.Map{
-			"message": "Trending recommendations fetched successfully",
+			"message": "Recommendation snapshots fetched successfully",
 			"data":    data,
 		})
 	}
 }
 
-func (h *RecommendationHandlers) RebuildRecommendationsHandler() fiber.Handler {
+func (h *RecommendationHandlers) ActivateSnapshotHandler() fiber.Handler {
 	return func(c *fiber.Ctx) error {
-		go h.service.ReCalculateUserRecommendations(c.Context())
+		version, err := c.ParamsInt("version")
+		if err != nil || version <= 0 {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"message": "Invalid snapshot version",
+				"data":    nil,
+			})
+		}
+
+		err = h.service.ActivateSnapshot(c.Context(), version)
+		if errors.Is(err, services.ErrSnapshotNotFound) {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"message": "Failed to activate snapshot: " + err.Error(),
+				"data":    nil,
+			})
+		} else if errors.Is(err, services.ErrSnapshotNotReady) {
+			return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+				"message": "Failed to activate snapshot: " + err.Error(),
+				"data":    nil,
+			})
+		} else if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"message": "Failed to activate snapshot: " + err.Error(),
+				"data":    nil,
+			})
+		}
 
 		return c.JSON(fiber.Map{
-			"message": "Recommendation rebuilding started",
-			"data":    nil,
+			"message": "Snapshot activated successfully",
+			"data":    fiber.Map{"version": version},
 		})
 	}
 }
@@ -81,31 +297,140 @@ func (h *RecommendationHandlers) RebuildRecommendationsHandler() fiber.Handler {
 func (h *RecommendationHandlers) GetRecommendationsByUserIDHandler() fiber.Handler {
 	return func(c *fiber.Ctx) error {
 		userID := c.Params("userID")
-		limit := 10
-		if l := c.Query("limit"); l != "" {
-			if parsedLimit, err := strconv.Atoi(l); err == nil && parsedLimit > 0 {
-				limit = parsedLimit
-			}
+		query, err := parseRecommendationQuery(c)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"message": "Invalid query: " + err.Error(),
+				"data":    nil,
+			})
 		}
 
-		data, err := h.service.GetUserRecommendations(c.Context(), userID, limit)
+		query.Experiment = h.service.AssignExperiment(c.Context(), userID, query.PlacementOr(services.PlacementPersonal))
+
+		data, nextCursor, cacheStatus, err := h.service.GetUserRecommendations(c.Context(), userID, query)
 		if err != nil {
-			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			return c.Status(pageErrorStatus(err)).JSON(fiber.Map{
 				"message": "Failed to get recommendations: " + err.Error(),
 				"data":    nil,
 			})
 		}
 
-		return c.JSON(fiber.Map{
-			"message": "Recommendations fetched successfully",
-			"data":    data,
+		c.Set("X-Cache", cacheStatus)
+		h.service.RecordExperimentImpression(query.Experiment)
+		return c.JSON(listResponse(query, fiber.Map{
+			"message":    "Recommendations fetched successfully",
+			"data":       data,
+			"nextCursor": nullableCursor(nextCursor),
+		}))
+	}
+}
+
+type BatchRecommendationPayload struct {
+	UserIDs []string `json:"userIds" validate:"required,min=1,dive,required"`
+	Limit   int      `json:"limit" validate:"gte=0"`
+}
+
+// GetBatchRecommendationsHandler streams the stored lists of many users as
+// NDJSON, one {"userId", "version", "products"} object per line in the
+// order of the request. A failure after streaming started ends the stream
+// with an {"error"} line.
+func (h *RecommendationHandlers) GetBatchRecommendationsHandler() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		payload := new(BatchRecommendationPayload)
+		if err := c.BodyParser(payload); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"message": "Invalid request payload: " + err.Error(),
+				"data":    nil,
+			})
+		}
+
+		if err := h.validator.Struct(payload); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"message": "Validation failed: " + err.Error(),
+				"data":    nil,
+			})
+		}
+		if len(payload.UserIDs) > h.cfg.Batch.MaxUsers {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"message": fmt.Sprintf("Validation failed: at most %d users per request", h.cfg.Batch.MaxUsers),
+				"data":    nil,
+			})
+		}
+		limit := payload.Limit
+		if limit == 0 {
+			limit = 10
+		}
+
+		c.Set(fiber.HeaderContentType, "application/x-ndjson")
+		c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+			encoder := json.NewEncoder(w)
+			// the request context is recycled once the handler returns
+			err := h.service.StreamUserRecommendations(context.Background(), payload.UserIDs, limit, func(list models.UserRecommendation) error {
+				return encoder.Encode(list)
+			})
+			if err != nil {
+				fmt.Printf("Error streaming batch recommendations: %v\n", err)
+				encoder.Encode(fiber.Map{"error": err.Error()})
+			}
+			w.Flush()
+		})
+		return nil
+	}
+}
+
+// ExportHandler streams stored user lists or the trending list as NDJSON or
+// CSV, optionally gzipped, as a file download.
+func (h *RecommendationHandlers) ExportHandler() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		opts := services.ExportOptions{
+			Dataset: c.Query("dataset", services.ExportDatasetUsers),
+			Format:  c.Query("format", services.ExportFormatNDJSON),
+			Gzip:    c.QueryBool("gzip"),
+			Version: c.QueryInt("version"),
+		}
+		if since := c.Query("updatedSince"); since != "" {
+			updatedSince, err := time.Parse(time.RFC3339, since)
+			if err != nil {
+				return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+					"message": "Invalid query: updatedSince must be an RFC 3339 time",
+					"data":    nil,
+				})
+			}
+			opts.UpdatedSince = updatedSince
+		}
+		if err := opts.Validate(); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"message": "Invalid query: " + err.Error(),
+				"data":    nil,
+			})
+		}
+
+		contentType := "application/x-ndjson"
+		if opts.Gzip {
+			contentType = "application/gzip"
+		} else if opts.Format == services.ExportFormatCSV {
+			contentType = "text/csv"
+		}
+		c.Attachment(opts.FileName())
+		c.Set(fiber.HeaderContentType, contentType)
+		c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+			// the request context is recycled once the handler returns; a
+			// failure can only truncate the file, so it is logged
+			if _, err := h.service.Export(context.Background(), w, opts); err != nil {
+				fmt.Printf("Error exporting %s recommendations: %v\n", opts.Dataset, err)
+			}
+			w.Flush()
 		})
+		return nil
 	}
 }
 
 type RecommendationEventPayload struct {
 	ProductID string `json:"productId" validate:"required,uuid4"`
 	EventType string `json:"eventType" validate:"required,oneof=VIEW PURCHASE CART_ADD"`
+	// Placement is where the product was recommended, if it was; it
+	// attributes the event to the user's experiment variant there.
+	Placement string `json:"placement"`
 }
 
 func (h *RecommendationHandlers) RecordUserInteractionHandler() fiber.Handler {
@@ -125,9 +450,13 @@ func (h *RecommendationHandlers) RecordUserInteractionHandler() fiber.Handler {
 			})
 		}
 
-		userID := c.Locals("userID").(string)
-
-		data, err := h.service.RecordUserInteraction(c.Context(), userID, payload.ProductID, payload.EventType)
+		data, err := h.service.RecordUserInteraction(c.Context(), models.UserActivity{
+			UserID:    c.Locals("userID").(string),
+			ProductID: payload.ProductID,
+			EventType: payload.EventType,
+			Segment:   c.Locals("userSegment").(string),
+			Placement: payload.Placement,
+		})
 		if err != nil {
 			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 				"message": "Failed to record user interaction: " + err.Error(),
@@ -141,3 +470,134 @@ func (h *RecommendationHandlers) RecordUserInteractionHandler() fiber.Handler {
 		})
 	}
 }
+
+// ImportEventsHandler backfills historical events sent as NDJSON or CSV.
+// With dryRun the file is only validated. The file is read as it streams in,
+// so it is not bounded by the body limit of the other routes.
+func (h *RecommendationHandlers) ImportEventsHandler() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		report, err := h.service.ImportEvents(c.Context(), importBody(c), services.EventImportOptions{
+			DryRun: c.QueryBool("dryRun"),
+		})
+		if err != nil {
+			c.Context().SetConnectionClose()
+			return c.Status(importErrorStatus(err)).JSON(fiber.Map{
+				"message": "Failed to import events: " + err.Error(),
+				"data":    report,
+			})
+		}
+
+		return c.JSON(fiber.Map{
+			"message": "Events imported successfully",
+			"data":    report,
+		})
+	}
+}
+
+// importBody returns the request body of an import route as it streams in.
+// A failed import may leave part of it unread, so the handlers close the
+// connection after responding to one.
+func importBody(c *fiber.Ctx) io.Reader {
+	if stream := c.Context().RequestBodyStream(); stream != nil {
+		return stream
+	}
+	return bytes.NewReader(c.Body())
+}
+
+// importErrorStatus rejects files that cannot be parsed; other import
+// failures are the server's.
+func importErrorStatus(err error) int {
+	if errors.Is(err, services.ErrInvalidImport) {
+		return fiber.StatusBadRequest
+	}
+	return fiber.StatusInternalServerError
+}
+
+// pageErrorStatus maps pagination errors to client errors: malformed cursors
+// are rejected and cursors whose list is gone ask the client to start over.
+func pageErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, services.ErrInvalidCursor):
+		return fiber.StatusBadRequest
+	case errors.Is(err, services.ErrCursorExpired):
+		return fiber.StatusGone
+	default:
+		return fiber.StatusInternalServerError
+	}
+}
+
+// listResponse adds the caller's experiment variant to a list response and,
+// when the query asked for it, how the list was shaped.
+func listResponse(query services.RecommendationQuery, response fiber.Map) fiber.Map {
+	if query.Experiment != nil {
+		response["experiment"] = query.Experiment
+	}
+	if query.Debug != nil {
+		response["debug"] = query.Debug
+	}
+	return response
+}
+
+func nullableCursor(cursor string) interface{} {
+	if cursor == "" {
+		return nil
+	}
+	return cursor
+}
+
+// parseRecommendationQuery reads the paging and filtering parameters shared
+// by the recommendation list endpoints.
+func parseRecommendationQuery(c *fiber.Ctx) (services.RecommendationQuery, error) {
+	segment, _ := c.Locals("userSegment").(string)
+	query := services.RecommendationQuery{
+		Cursor:    c.Query("cursor"),
+		Limit:     10,
+		Segment:   c.Query("segment", segment),
+		Placement: c.Query("placement"),
+		Filter: services.ProductFilter{
+			Categories: splitQueryList(c.Query("category")),
+			Brands:     splitQueryList(c.Query("brand")),
+			InStock:    c.QueryBool("inStock"),
+		},
+	}
+
+	if l := c.Query("limit"); l != "" {
+		if parsedLimit, err := strconv.Atoi(l); err == nil && parsedLimit > 0 {
+			query.Limit = parsedLimit
+		}
+	}
+
+	if c.QueryBool("debug") {
+		query.Debug = &services.ListDebug{}
+	}
+	query.Explain = c.QueryBool("explain")
+
+	for _, expand := range splitQueryList(c.Query("expand")) {
+		if expand != "product" {
+			return query, fmt.Errorf("unknown expand %q", expand)
+		}
+		query.ExpandProduct = true
+	}
+
+	for param, target := range map[string]**float64{"minPrice": &query.Filter.MinPrice, "maxPrice": &query.Filter.MaxPrice} {
+		if raw := c.Query(param); raw != "" {
+			value, err := strconv.ParseFloat(raw, 64)
+			if err != nil {
+				return query, fmt.Errorf("%s must be a number", param)
+			}
+			*target = &value
+		}
+	}
+
+	return query, nil
+}
+
+func splitQueryList(raw string) []string {
+	var values []string
+	for _, value := range strings.Split(raw, ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			values = append(values, value)
+		}
+	}
+	return values
+}
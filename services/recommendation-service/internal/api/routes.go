@@ -3,6 +3,8 @@ package api
 import (
 	"polyforge-recommendation/internal/api/handlers"
 	"polyforge-recommendation/internal/config"
+	"polyforge-recommendation/internal/services"
+	"polyforge-recommendation/pkg/middleware"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/redis/go-redis/v9"
@@ -11,25 +13,61 @@ import (
 
 type HandlerFactory struct {
 	Recommendation *handlers.RecommendationHandlers
+	Product        *handlers.ProductHandlers
+	Merchandising  *handlers.MerchandisingHandlers
+	Experiment     *handlers.ExperimentHandlers
 }
 
+// NewHandlerFactory builds the handlers around a single recommendation
+// service, so the rules and experiments they manage are the ones the lists
+// apply.
 func NewHandlerFactory(db *mongo.Database, cache *redis.Client, cfg config.Config) *HandlerFactory {
+	recommendations := services.NewRecommendationService(db, cache, cfg)
 	return &HandlerFactory{
-		Recommendation: handlers.NewRecommendationHandlers(db, cache, cfg),
+		Recommendation: handlers.NewRecommendationHandlers(recommendations, cfg),
+		Product:        handlers.NewProductHandlers(db, cfg),
+		Merchandising:  handlers.NewMerchandisingHandlers(recommendations.Rules()),
+		Experiment:     handlers.NewExperimentHandlers(recommendations.Experiments()),
 	}
 }
 
 func SetupRoutes(app *fiber.App, db *mongo.Database, cache *redis.Client, cfg config.Config) {
-	// health check route
-	app.Get("/", handlers.NewHealthCheckHandler(db, cache).HealthCheck())
-
+	healthCheck := handlers.NewHealthCheckHandler(db, cache).HealthCheck()
 	handlers := NewHandlerFactory(db, cache, cfg)
+	recommendationGroup := app.Group("/recommendations")
+
+	// imports read their files as the body streams in; every other route
+	// has its body buffered up to fiber's default limit
+	recommendationGroup.Post("/products/import", handlers.Product.ImportCatalogHandler())
+	recommendationGroup.Post("/events/import", handlers.Recommendation.ImportEventsHandler())
+	app.Use(middleware.BodyLimit(fiber.DefaultBodyLimit))
+
+	// health check route
+	app.Get("/", healthCheck)
 
 	// recommendation routes
-	recommendationGroup := app.Group("/recommendations")
 	recommendationGroup.Get("/", handlers.Recommendation.GetRecommendationsHandler())
 	recommendationGroup.Get("/trending", handlers.Recommendation.GetTrendingRecommendationHandler())
 	recommendationGroup.Post("/rebuild", handlers.Recommendation.RebuildRecommendationsHandler())
+	recommendationGroup.Get("/snapshots", handlers.Recommendation.GetSnapshotsHandler())
+	recommendationGroup.Post("/snapshots/:version/activate", handlers.Recommendation.ActivateSnapshotHandler())
+	recommendationGroup.Put("/products", handlers.Product.UpsertProductsHandler())
+	recommendationGroup.Get("/products/:productID", handlers.Product.GetProductHandler())
+	recommendationGroup.Put("/products/:productID/stock", handlers.Product.UpdateStockHandler())
+	recommendationGroup.Get("/products/:productID/similar", handlers.Recommendation.GetSimilarProductsHandler())
+	recommendationGroup.Get("/rules", handlers.Merchandising.ListRulesHandler())
+	recommendationGroup.Post("/rules", handlers.Merchandising.CreateRuleHandler())
+	recommendationGroup.Get("/rules/:ruleID", handlers.Merchandising.GetRuleHandler())
+	recommendationGroup.Put("/rules/:ruleID", handlers.Merchandising.UpdateRuleHandler())
+	recommendationGroup.Delete("/rules/:ruleID", handlers.Merchandising.DeleteRuleHandler())
+	recommendationGroup.Get("/export", handlers.Recommendation.ExportHandler())
+	recommendationGroup.Post("/users\\:batch", handlers.Recommendation.GetBatchRecommendationsHandler())
+	recommendationGroup.Get("/placements/:placement", handlers.Recommendation.GetPlacementRecommendationsHandler())
+	recommendationGroup.Get("/experiments", handlers.Experiment.ListExperimentsHandler())
+	recommendationGroup.Post("/experiments", handlers.Experiment.CreateExperimentHandler())
+	recommendationGroup.Get("/experiments/:experimentID", handlers.Experiment.GetExperimentHandler())
+	recommendationGroup.Post("/experiments/:experimentID/stop", handlers.Experiment.StopExperimentHandler())
+	recommendationGroup.Get("/experiments/:experimentID/results", handlers.Experiment.GetExperimentResultsHandler())
 	recommendationGroup.Get("/:userID", handlers.Recommendation.GetRecommendationsByUserIDHandler())
 	recommendationGroup.Post("/event", handlers.Recommendation.RecordUserInteractionHandler())
 }
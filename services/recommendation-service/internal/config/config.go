@@ -1,14 +1,30 @@
 package config
 
 import (
+	"encoding/json"
 	"fmt"
 	"os"
 	"strconv"
+	"strings"
+	"time"
 )
 
 type Config struct {
-	Database DatabaseConfig
-	Cache    CacheConfig
+	Database     DatabaseConfig
+	Cache        CacheConfig
+	Snapshot     SnapshotConfig
+	Scoring      ScoringConfig
+	Messaging    MessagingConfig
+	Availability AvailabilityConfig
+	Content      ContentConfig
+	Blend        BlendConfig
+	Fallback     FallbackConfig
+	Diversity    DiversityConfig
+	Rules        RulesConfig
+	Experiments  ExperimentsConfig
+	Bandit       BanditConfig
+	Placements   map[string]PlacementConfig
+	Batch        BatchConfig
 }
 
 type DatabaseConfig struct {
@@ -23,6 +39,211 @@ type CacheConfig struct {
 	Host   string
 	Port   int
 	Prefix string
+	// KeyVersion is part of every cache key; bumping it abandons all
+	// previously cached values at once.
+	KeyVersion        string
+	UserKeyFormat     string
+	TrendingKeyFormat string
+	UserTTL           time.Duration
+	UserStaleTTL      time.Duration
+	TrendingTTL       time.Duration
+	// CursorTTL is how long a pagination cursor keeps pointing at the list
+	// its first page came from.
+	CursorTTL time.Duration
+	Local     LocalCacheConfig
+}
+
+// LocalCacheConfig configures the optional in-process tier kept in front of
+// Redis. Replicas keep their tiers consistent through Redis pub/sub.
+type LocalCacheConfig struct {
+	Enabled bool
+	Size    int
+	TTL     time.Duration
+}
+
+type SnapshotConfig struct {
+	Retention int
+	// DryRunMaxSample caps how many users a dry-run rebuild requested over
+	// HTTP may score; the CLI can score every user.
+	DryRunMaxSample int
+}
+
+type ScoringConfig struct {
+	Personal ScoringWeights
+	Trending ScoringWeights
+	// HistoryLimit is how many of a user's most recent events describe their
+	// current interests for the candidate generators and the fallback.
+	HistoryLimit int
+}
+
+// ScoringWeights are the per-event-type multipliers used when aggregating
+// events into a product score.
+type ScoringWeights struct {
+	View     float64
+	CartAdd  float64
+	Purchase float64
+}
+
+// MessagingConfig configures the RabbitMQ connection used to receive updates
+// from other services. Consumers only run when it is enabled.
+type MessagingConfig struct {
+	Enabled             bool
+	Username            string
+	Password            string
+	Host                string
+	Port                int
+	InventoryExchange   string
+	InventoryRoutingKey string
+	InventoryQueue      string
+	CatalogExchange     string
+	CatalogRoutingKey   string
+	CatalogQueue        string
+	RetryDelay          time.Duration
+}
+
+const (
+	AvailabilityModeRemove = "remove"
+	AvailabilityModeDemote = "demote"
+)
+
+// AvailabilityConfig controls what happens to out-of-stock products in
+// recommendation lists: they are either removed or moved to the end.
+type AvailabilityConfig struct {
+	Mode string
+}
+
+// ContentConfig configures the content-based model built from catalog
+// attributes. It is rebuilt from the products collection once ModelTTL has
+// passed.
+type ContentConfig struct {
+	ModelTTL time.Duration
+}
+
+const (
+	BlendModeWeighted   = "weighted"
+	BlendModeInterleave = "interleave"
+)
+
+// BlendConfig configures how the candidate sources of a personal list are
+// merged: by weighted score or by interleaving proportionally to the weights.
+// Sources without a positive weight are not queried.
+type BlendConfig struct {
+	Mode    string
+	Weights map[string]float64
+	// CandidateLimit caps the candidates of every source but the user's own
+	// stored list.
+	CandidateLimit int
+	// CacheTTL is how long a blended list is reused before its sources are
+	// queried again.
+	CacheTTL time.Duration
+}
+
+const (
+	FallbackSourceSegment  = "segment"
+	FallbackSourceCategory = "category"
+	FallbackSourceTrending = "trending"
+)
+
+// FallbackConfig configures how short personal lists are filled: the
+// sources in Chain are tried in order until the list holds MinItems products,
+// or as many as the request asks for when that is more.
+type FallbackConfig struct {
+	Chain    []string
+	MinItems int
+}
+
+const (
+	DiversityMethodNone        = "none"
+	DiversityMethodMMR         = "mmr"
+	DiversityMethodCategoryCap = "category_cap"
+)
+
+// DiversitySettings configures the re-ranking of the head of a list. MMR
+// trades relevance against similarity to the products already ranked (Lambda
+// 1 is pure relevance); category caps allow at most CategoryCap products of
+// a category in the head.
+type DiversitySettings struct {
+	Method      string  `json:"method"`
+	Lambda      float64 `json:"lambda"`
+	CategoryCap int     `json:"categoryCap"`
+	// Window is how many products at the head of the list are re-ranked.
+	Window int `json:"window"`
+}
+
+// DiversityConfig holds the default settings and per-placement overrides.
+type DiversityConfig struct {
+	Default    DiversitySettings
+	Placements map[string]DiversitySettings
+}
+
+// Settings returns the settings of a placement, or the defaults.
+func (c DiversityConfig) Settings(placement string) DiversitySettings {
+	if settings, ok := c.Placements[placement]; ok {
+		return settings
+	}
+	return c.Default
+}
+
+// RulesConfig configures merchandising rules. Each replica reloads the
+// rules at most once per CacheTTL.
+type RulesConfig struct {
+	CacheTTL time.Duration
+}
+
+// ExperimentsConfig configures A/B experiments. Each replica reloads the
+// running experiments at most once per CacheTTL.
+type ExperimentsConfig struct {
+	CacheTTL time.Duration
+}
+
+const (
+	BanditMethodThompson      = "thompson"
+	BanditMethodEpsilonGreedy = "epsilon_greedy"
+)
+
+// BanditConfig configures exploration on the lists of Placements: the first
+// Slots positions are filled by a multi-armed bandit choosing among the
+// first Arms products of the list and the NewProducts most recently added
+// to the catalog. Epsilon is the exploration rate of epsilon-greedy.
+type BanditConfig struct {
+	Method      string
+	Epsilon     float64
+	Placements  []string
+	Slots       int
+	Arms        int
+	NewProducts int
+}
+
+const (
+	PlacementStrategyPersonal  = "personal"
+	PlacementStrategyTrending  = "trending"
+	PlacementStrategySimilar   = "similar"
+	PlacementStrategyCoVisited = "co_visited"
+)
+
+// Exclusions a placement can apply.
+const (
+	PlacementExcludeContext   = "context"
+	PlacementExcludePurchased = "purchased"
+)
+
+// PlacementConfig describes a surface that shows recommendations: the
+// strategy producing its list, how many products it shows, the filters it
+// always applies and what it excludes. Strategies needing a context product
+// fall back to trending without one.
+type PlacementConfig struct {
+	Strategy   string   `json:"strategy"`
+	Limit      int      `json:"limit"`
+	Categories []string `json:"categories"`
+	Brands     []string `json:"brands"`
+	InStock    bool     `json:"inStock"`
+	Exclude    []string `json:"exclude"`
+}
+
+// BatchConfig limits batch lookups: a request may name at most MaxUsers
+// users.
+type BatchConfig struct {
+	MaxUsers int
 }
 
 func LoadConfig() Config {
@@ -35,14 +256,133 @@ func LoadConfig() Config {
 	}
 
 	cacheCfg := CacheConfig{
-		Host:   getEnv("CACHE_HOST", "localhost"),
-		Port:   getEnvInt("CACHE_PORT", 6379),
-		Prefix: getEnv("CACHE_PREFIX", "polyforge:recommendation"),
+		Host:              getEnv("CACHE_HOST", "localhost"),
+		Port:              getEnvInt("CACHE_PORT", 6379),
+		Prefix:            getEnv("CACHE_PREFIX", "polyforge:recommendation"),
+		KeyVersion:        getEnv("CACHE_KEY_VERSION", "v1"),
+		UserKeyFormat:     getEnv("CACHE_USER_KEY_FORMAT", "{prefix}:{version}:user_recommendations:{id}"),
+		TrendingKeyFormat: getEnv("CACHE_TRENDING_KEY_FORMAT", "{prefix}:{version}:trending_recommendations"),
+		UserTTL:           getEnvDuration("CACHE_USER_TTL", 12*time.Hour),
+		UserStaleTTL:      getEnvDuration("CACHE_USER_STALE_TTL", 1*time.Hour),
+		TrendingTTL:       getEnvDuration("CACHE_TRENDING_TTL", 5*time.Minute),
+		CursorTTL:         getEnvDuration("CACHE_CURSOR_TTL", 30*time.Minute),
+		Local: LocalCacheConfig{
+			Enabled: getEnvBool("CACHE_LOCAL_ENABLED", false),
+			Size:    getEnvInt("CACHE_LOCAL_SIZE", 10000),
+			TTL:     getEnvDuration("CACHE_LOCAL_TTL", 30*time.Second),
+		},
+	}
+
+	snapshotCfg := SnapshotConfig{
+		Retention:       getEnvInt("SNAPSHOT_RETENTION", 5),
+		DryRunMaxSample: getEnvInt("REBUILD_DRY_RUN_MAX_SAMPLE", 1000),
+	}
+
+	scoringCfg := ScoringConfig{
+		Personal: ScoringWeights{
+			View:     getEnvFloat("SCORING_VIEW_WEIGHT", 1),
+			CartAdd:  getEnvFloat("SCORING_CART_ADD_WEIGHT", 3),
+			Purchase: getEnvFloat("SCORING_PURCHASE_WEIGHT", 5),
+		},
+		Trending: ScoringWeights{
+			View:     getEnvFloat("TRENDING_VIEW_WEIGHT", 5),
+			CartAdd:  getEnvFloat("TRENDING_CART_ADD_WEIGHT", 3),
+			Purchase: getEnvFloat("TRENDING_PURCHASE_WEIGHT", 2),
+		},
+		HistoryLimit: getEnvInt("SCORING_HISTORY_LIMIT", 100),
+	}
+
+	messagingCfg := MessagingConfig{
+		Enabled:             getEnvBool("MQ_ENABLED", false),
+		Username:            getEnv("MQ_USER", "guest"),
+		Password:            getEnv("MQ_PASSWORD", "guest"),
+		Host:                getEnv("MQ_HOST", "localhost"),
+		Port:                getEnvInt("MQ_PORT", 5672),
+		InventoryExchange:   getEnv("MQ_INVENTORY_EXCHANGE", "inventory"),
+		InventoryRoutingKey: getEnv("MQ_INVENTORY_ROUTING_KEY", "inventory.stock.changed"),
+		InventoryQueue:      getEnv("MQ_INVENTORY_QUEUE", "recommendation.inventory.stock"),
+		CatalogExchange:     getEnv("MQ_CATALOG_EXCHANGE", "catalog"),
+		CatalogRoutingKey:   getEnv("MQ_CATALOG_ROUTING_KEY", "catalog.product.updated"),
+		CatalogQueue:        getEnv("MQ_CATALOG_QUEUE", "recommendation.catalog.product"),
+		RetryDelay:          getEnvDuration("MQ_RETRY_DELAY", 5*time.Second),
+	}
+
+	availabilityCfg := AvailabilityConfig{
+		Mode: getEnv("AVAILABILITY_MODE", AvailabilityModeRemove),
+	}
+
+	contentCfg := ContentConfig{
+		ModelTTL: getEnvDuration("CONTENT_MODEL_TTL", 10*time.Minute),
+	}
+
+	blendCfg := BlendConfig{
+		Mode:           getEnv("BLEND_MODE", BlendModeWeighted),
+		Weights:        getEnvWeights("BLEND_WEIGHTS", map[string]float64{"personal": 1, "item_item": 0.6, "content": 0.4}),
+		CandidateLimit: getEnvInt("BLEND_CANDIDATE_LIMIT", 50),
+		CacheTTL:       getEnvDuration("BLEND_CACHE_TTL", 10*time.Minute),
+	}
+
+	fallbackCfg := FallbackConfig{
+		Chain:    getEnvList("FALLBACK_CHAIN", []string{FallbackSourceSegment, FallbackSourceCategory, FallbackSourceTrending}),
+		MinItems: getEnvInt("FALLBACK_MIN_ITEMS", 10),
+	}
+
+	diversityDefault := DiversitySettings{
+		Method:      getEnv("DIVERSITY_METHOD", DiversityMethodNone),
+		Lambda:      getEnvFloat("DIVERSITY_LAMBDA", 0.7),
+		CategoryCap: getEnvInt("DIVERSITY_CATEGORY_CAP", 3),
+		Window:      getEnvInt("DIVERSITY_WINDOW", 50),
+	}
+	diversityCfg := DiversityConfig{
+		Default:    diversityDefault,
+		Placements: getEnvDiversityPlacements("DIVERSITY_PLACEMENTS", diversityDefault),
+	}
+
+	rulesCfg := RulesConfig{
+		CacheTTL: getEnvDuration("RULES_CACHE_TTL", 30*time.Second),
+	}
+
+	experimentsCfg := ExperimentsConfig{
+		CacheTTL: getEnvDuration("EXPERIMENTS_CACHE_TTL", 30*time.Second),
+	}
+
+	banditCfg := BanditConfig{
+		Method:      getEnv("BANDIT_METHOD", BanditMethodThompson),
+		Epsilon:     getEnvFloat("BANDIT_EPSILON", 0.1),
+		Placements:  getEnvList("BANDIT_PLACEMENTS", []string{"trending", "home"}),
+		Slots:       getEnvInt("BANDIT_SLOTS", 3),
+		Arms:        getEnvInt("BANDIT_ARMS", 20),
+		NewProducts: getEnvInt("BANDIT_NEW_PRODUCTS", 10),
+	}
+
+	placementsCfg := getEnvPlacements("PLACEMENTS", map[string]PlacementConfig{
+		"home":          {Strategy: PlacementStrategyPersonal, Limit: 20},
+		"pdp":           {Strategy: PlacementStrategySimilar, Limit: 12, Exclude: []string{PlacementExcludeContext}},
+		"cart":          {Strategy: PlacementStrategyCoVisited, Limit: 8, InStock: true, Exclude: []string{PlacementExcludeContext}},
+		"email":         {Strategy: PlacementStrategyPersonal, Limit: 6, InStock: true, Exclude: []string{PlacementExcludePurchased}},
+		"post_purchase": {Strategy: PlacementStrategyCoVisited, Limit: 8, InStock: true, Exclude: []string{PlacementExcludeContext, PlacementExcludePurchased}},
+	})
+
+	batchCfg := BatchConfig{
+		MaxUsers: getEnvInt("BATCH_MAX_USERS", 10000),
 	}
 
 	return Config{
-		Database: dbCfg,
-		Cache:    cacheCfg,
+		Database:     dbCfg,
+		Cache:        cacheCfg,
+		Snapshot:     snapshotCfg,
+		Scoring:      scoringCfg,
+		Messaging:    messagingCfg,
+		Availability: availabilityCfg,
+		Content:      contentCfg,
+		Blend:        blendCfg,
+		Fallback:     fallbackCfg,
+		Diversity:    diversityCfg,
+		Rules:        rulesCfg,
+		Experiments:  experimentsCfg,
+		Bandit:       banditCfg,
+		Placements:   placementsCfg,
+		Batch:        batchCfg,
 	}
 }
 
@@ -50,10 +390,38 @@ func (c Config) GetDatabaseURI() string {
 	return fmt.Sprintf("mongodb://%s:%s@%s:%d/", c.Database.Username, c.Database.Password, c.Database.Host, c.Database.Port)
 }
 
+func (c Config) GetMessagingURI() string {
+	return fmt.Sprintf("amqp://%s:%s@%s:%d/", c.Messaging.Username, c.Messaging.Password, c.Messaging.Host, c.Messaging.Port)
+}
+
 func (c Config) GetCacheAddress() string {
 	return fmt.Sprintf("%s:%d", c.Cache.Host, c.Cache.Port)
 }
 
+// Key builds a cache key for an internal name under the configured prefix
+// and key version.
+func (c CacheConfig) Key(name string) string {
+	return fmt.Sprintf("%s:%s:%s", c.Prefix, c.KeyVersion, name)
+}
+
+func (c CacheConfig) UserRecommendationKey(userID string) string {
+	return c.formatKey(c.UserKeyFormat, userID)
+}
+
+// UserGenerationKey holds the generation of the cached user lists, which
+// invalidation bumps to mark them all as stale.
+func (c CacheConfig) UserGenerationKey() string {
+	return c.Key("user_recommendations_generation")
+}
+
+func (c CacheConfig) TrendingKey() string {
+	return c.formatKey(c.TrendingKeyFormat, "")
+}
+
+func (c CacheConfig) formatKey(format, id string) string {
+	return strings.NewReplacer("{prefix}", c.Prefix, "{version}", c.KeyVersion, "{id}", id).Replace(format)
+}
+
 func getEnv(key, defaultValue string) string {
 	if value := os.Getenv(key); value != "" {
 		return value
@@ -69,3 +437,114 @@ func getEnvInt(key string, defaultValue int) int {
 	}
 	return defaultValue
 }
+
+func getEnvFloat(key string, defaultValue float64) float64 {
+	if value := os.Getenv(key); value != "" {
+		if floatValue, err := strconv.ParseFloat(value, 64); err == nil {
+			return floatValue
+		}
+	}
+	return defaultValue
+}
+
+func getEnvBool(key string, defaultValue bool) bool {
+	if value := os.Getenv(key); value != "" {
+		if boolValue, err := strconv.ParseBool(value); err == nil {
+			return boolValue
+		}
+	}
+	return defaultValue
+}
+
+func getEnvDuration(key string, defaultValue time.Duration) time.Duration {
+	if value := os.Getenv(key); value != "" {
+		if durationValue, err := time.ParseDuration(value); err == nil {
+			return durationValue
+		}
+	}
+	return defaultValue
+}
+
+// getEnvList reads a comma-separated list; an empty value disables the list.
+func getEnvList(key string, defaultValue []string) []string {
+	value, ok := os.LookupEnv(key)
+	if !ok {
+		return defaultValue
+	}
+
+	values := []string{}
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			values = append(values, item)
+		}
+	}
+	return values
+}
+
+// getEnvWeights reads comma-separated name:weight pairs, e.g.
+// "personal:1,content:0.5". Malformed pairs are ignored.
+func getEnvWeights(key string, defaultValue map[string]float64) map[string]float64 {
+	value, ok := os.LookupEnv(key)
+	if !ok {
+		return defaultValue
+	}
+
+	weights := map[string]float64{}
+	for _, pair := range strings.Split(value, ",") {
+		name, raw, found := strings.Cut(pair, ":")
+		if !found {
+			continue
+		}
+		if weight, err := strconv.ParseFloat(strings.TrimSpace(raw), 64); err == nil {
+			weights[strings.TrimSpace(name)] = weight
+		}
+	}
+	return weights
+}
+
+// getEnvPlacements reads placements as JSON keyed by name. Listed
+// placements replace the default of the same name and add to the others.
+func getEnvPlacements(key string, defaults map[string]PlacementConfig) map[string]PlacementConfig {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaults
+	}
+
+	var placements map[string]PlacementConfig
+	if err := json.Unmarshal([]byte(value), &placements); err != nil {
+		fmt.Printf("Error parsing %s: %v\n", key, err)
+		return defaults
+	}
+	for name, placement := range defaults {
+		if _, ok := placements[name]; !ok {
+			placements[name] = placement
+		}
+	}
+	return placements
+}
+
+// getEnvDiversityPlacements reads per-placement diversity settings as a JSON
+// object keyed by placement, e.g. {"trending": {"method": "mmr"}}. Fields a
+// placement leaves out take the default settings.
+func getEnvDiversityPlacements(key string, defaults DiversitySettings) map[string]DiversitySettings {
+	placements := map[string]DiversitySettings{}
+	value := os.Getenv(key)
+	if value == "" {
+		return placements
+	}
+
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(value), &raw); err != nil {
+		fmt.Printf("Error parsing %s: %v\n", key, err)
+		return placements
+	}
+	for placement, data := range raw {
+		settings := defaults
+		if err := json.Unmarshal(data, &settings); err != nil {
+			fmt.Printf("Error parsing %s for placement %s: %v\n", key, placement, err)
+			continue
+		}
+		placements[placement] = settings
+	}
+	return placements
+}
@@ -0,0 +1,36 @@
+package consumers
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/v2/mongo"
+
+	"polyforge-recommendation/internal/config"
+	"polyforge-recommendation/internal/messaging"
+	"polyforge-recommendation/internal/services"
+)
+
+// Start subscribes to the messages of other services when messaging is
+// enabled. Consumers run until the context is cancelled.
+func Start(ctx context.Context, db *mongo.Database, cfg config.Config) {
+	if !cfg.Messaging.Enabled {
+		return
+	}
+
+	consumer := messaging.NewConsumer(cfg.GetMessagingURI(), cfg.Messaging.RetryDelay)
+	products := services.NewProductService(db, cfg)
+
+	// inventory stock changes
+	go consumer.Consume(ctx, messaging.Subscription{
+		Exchange:   cfg.Messaging.InventoryExchange,
+		RoutingKey: cfg.Messaging.InventoryRoutingKey,
+		Queue:      cfg.Messaging.InventoryQueue,
+	}, products.HandleStockChanged)
+
+	// catalog product updates
+	go consumer.Consume(ctx, messaging.Subscription{
+		Exchange:   cfg.Messaging.CatalogExchange,
+		RoutingKey: cfg.Messaging.CatalogRoutingKey,
+		Queue:      cfg.Messaging.CatalogQueue,
+	}, products.HandleProductChanged)
+}
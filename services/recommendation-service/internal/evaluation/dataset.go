@@ -0,0 +1,82 @@
+package evaluation
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+
+	"polyforge-recommendation/internal/models"
+)
+
+// LoadEvents reads the whole events collection, oldest first.
+func LoadEvents(ctx context.Context, db *mongo.Database) ([]models.UserActivity, error) {
+	cursor, err := db.Collection("events").Find(ctx, bson.M{},
+		options.Find().
+			SetSort(bson.M{"timestamp": 1}).
+			SetProjection(bson.M{"userId": 1, "productId": 1, "eventType": 1, "timestamp": 1}),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	events := []models.UserActivity{}
+	if err := cursor.All(ctx, &events); err != nil {
+		return nil, err
+	}
+	return events, nil
+}
+
+// LoadEventsNDJSON reads a dump with one event per line, shaped like the
+// files of the event backfill.
+func LoadEventsNDJSON(r io.Reader) ([]models.UserActivity, error) {
+	events := []models.UserActivity{}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 4*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		raw := bytes.TrimSpace(scanner.Bytes())
+		if len(raw) == 0 {
+			continue
+		}
+		var event models.UserActivity
+		if err := json.Unmarshal(raw, &event); err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		events = append(events, event)
+	}
+	return events, scanner.Err()
+}
+
+// Split divides events in time: the oldest events up to 1-testFraction of
+// them train the strategies and the rest are held out to test them. Events
+// at the cutoff time all go to the test part, so no moment is split.
+func Split(events []models.UserActivity, testFraction float64) (train, test []models.UserActivity, cutoff time.Time) {
+	if len(events) == 0 {
+		return nil, nil, time.Time{}
+	}
+
+	sorted := append([]models.UserActivity{}, events...)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Timestamp.Before(sorted[j].Timestamp) })
+
+	index := int(float64(len(sorted)) * (1 - testFraction))
+	if index >= len(sorted) {
+		return sorted, nil, sorted[len(sorted)-1].Timestamp.Add(time.Nanosecond)
+	}
+	if index < 0 {
+		index = 0
+	}
+	cutoff = sorted[index].Timestamp
+	for index > 0 && !sorted[index-1].Timestamp.Before(cutoff) {
+		index--
+	}
+	return sorted[:index], sorted[index:], cutoff
+}
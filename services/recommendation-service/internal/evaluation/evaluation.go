@@ -0,0 +1,130 @@
+// Package evaluation scores recommendation strategies offline: it splits
+// past events in time, fits each strategy on the older part and measures how
+// well its lists predict what users did in the newer part.
+package evaluation
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"polyforge-recommendation/internal/models"
+)
+
+var (
+	ErrInvalidOptions = errors.New("invalid evaluation options")
+	ErrNoTestUsers    = errors.New("no user has relevant events in the test part")
+)
+
+// Options controls an evaluation.
+type Options struct {
+	// K is the length of the lists that are scored.
+	K int
+	// TestFraction is the share of the events, the newest, held out.
+	TestFraction float64
+	// RelevantTypes are the event types that make a test product relevant
+	// to its user, e.g. only PURCHASE; every type counts when empty.
+	RelevantTypes []string
+}
+
+func (o Options) Validate() error {
+	if o.K <= 0 {
+		return fmt.Errorf("%w: k must be positive", ErrInvalidOptions)
+	}
+	if o.TestFraction <= 0 || o.TestFraction >= 1 {
+		return fmt.Errorf("%w: the test fraction must be between 0 and 1", ErrInvalidOptions)
+	}
+	return nil
+}
+
+// Evaluate splits the events, fits every strategy on the training part and
+// reports its metrics at K, averaged over the users with relevant test
+// events. Users without training events are kept, so strategies are also
+// judged on cold-start users.
+func Evaluate(events []models.UserActivity, strategies []Strategy, opts Options) (models.EvaluationReport, error) {
+	report := models.EvaluationReport{K: opts.K, Results: []models.StrategyMetrics{}}
+	if err := opts.Validate(); err != nil {
+		return report, err
+	}
+
+	train, test, cutoff := Split(events, opts.TestFraction)
+	report.Cutoff, report.TrainEvents, report.TestEvents = cutoff, len(train), len(test)
+
+	relevantTypes := map[string]bool{}
+	for _, eventType := range opts.RelevantTypes {
+		relevantTypes[strings.ToUpper(eventType)] = true
+	}
+	relevant := map[string]map[string]bool{}
+	for _, event := range test {
+		if len(relevantTypes) > 0 && !relevantTypes[event.EventType] {
+			continue
+		}
+		if relevant[event.UserID] == nil {
+			relevant[event.UserID] = map[string]bool{}
+		}
+		relevant[event.UserID][event.ProductID] = true
+	}
+	if len(relevant) == 0 {
+		return report, ErrNoTestUsers
+	}
+	users := make([]string, 0, len(relevant))
+	for userID := range relevant {
+		users = append(users, userID)
+	}
+	sort.Strings(users)
+	report.Users = len(users)
+
+	catalog := map[string]bool{}
+	for _, event := range events {
+		catalog[event.ProductID] = true
+	}
+	report.Products = len(catalog)
+
+	// popularity is counted in users, so heavy users do not make a product
+	// look popular
+	trainUsers := map[string]bool{}
+	productUsers := map[string]map[string]bool{}
+	for _, event := range train {
+		trainUsers[event.UserID] = true
+		if productUsers[event.ProductID] == nil {
+			productUsers[event.ProductID] = map[string]bool{}
+		}
+		productUsers[event.ProductID][event.UserID] = true
+	}
+
+	for _, strategy := range strategies {
+		strategy.Fit(train)
+
+		var precision, recall, averagePrecision, ndcg, novelty float64
+		novel := 0
+		recommendedProducts := map[string]bool{}
+		for _, userID := range users {
+			recommended := head(strategy.Recommend(userID, opts.K), opts.K)
+			precision += precisionAt(recommended, relevant[userID], opts.K)
+			recall += recallAt(recommended, relevant[userID], opts.K)
+			averagePrecision += averagePrecisionAt(recommended, relevant[userID], opts.K)
+			ndcg += ndcgAt(recommended, relevant[userID], opts.K)
+			for _, id := range recommended {
+				recommendedProducts[id] = true
+				novelty += selfInformation(len(productUsers[id]), len(trainUsers))
+				novel++
+			}
+		}
+
+		n := float64(len(users))
+		metrics := models.StrategyMetrics{
+			Strategy:  strategy.Name(),
+			Precision: round4(precision / n),
+			Recall:    round4(recall / n),
+			MAP:       round4(averagePrecision / n),
+			NDCG:      round4(ndcg / n),
+			Coverage:  round4(float64(len(recommendedProducts)) / float64(len(catalog))),
+		}
+		if novel > 0 {
+			metrics.Novelty = round4(novelty / float64(novel))
+		}
+		report.Results = append(report.Results, metrics)
+	}
+	return report, nil
+}
@@ -0,0 +1,227 @@
+package evaluation
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"polyforge-recommendation/internal/config"
+	"polyforge-recommendation/internal/models"
+)
+
+var (
+	testScoring = config.ScoringConfig{
+		Personal: config.ScoringWeights{View: 1, CartAdd: 3, Purchase: 5},
+		Trending: config.ScoringWeights{View: 5, CartAdd: 3, Purchase: 2},
+	}
+	testStart = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+)
+
+// syntheticEvents builds the same dataset for the same seed: every user keeps
+// coming back to a few favourite products and otherwise browses products
+// whose popularity falls with their index, so personal lists should predict
+// the held-out events far better than trending does.
+func syntheticEvents(seed int64, users, products, eventsPerUser int) []models.UserActivity {
+	random := rand.New(rand.NewSource(seed))
+	eventTypes := []string{"VIEW", "VIEW", "VIEW", "CART_ADD", "PURCHASE"}
+
+	var events []models.UserActivity
+	for u := 0; u < users; u++ {
+		favourites := []int{random.Intn(products), random.Intn(products), random.Intn(products)}
+		for e := 0; e < eventsPerUser; e++ {
+			product := favourites[random.Intn(len(favourites))]
+			if random.Float64() < 0.3 {
+				product = int(float64(products) * random.Float64() * random.Float64())
+			}
+			events = append(events, models.UserActivity{
+				UserID:    fmt.Sprintf("u%03d", u),
+				ProductID: fmt.Sprintf("p%03d", product),
+				EventType: eventTypes[random.Intn(len(eventTypes))],
+				Timestamp: testStart.Add(time.Duration(random.Intn(60*24)) * time.Hour),
+			})
+		}
+	}
+	return events
+}
+
+func builtInStrategies(t *testing.T) []Strategy {
+	t.Helper()
+	var strategies []Strategy
+	for _, name := range []string{StrategyPersonal, StrategyTrending, StrategyPersonalTrending} {
+		strategy, err := NewStrategy(name, testScoring)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		strategies = append(strategies, strategy)
+	}
+	return strategies
+}
+
+func TestSplitHoldsOutTheNewestEvents(t *testing.T) {
+	events := []models.UserActivity{
+		{ProductID: "p3", Timestamp: testStart.Add(3 * time.Hour)},
+		{ProductID: "p1", Timestamp: testStart.Add(1 * time.Hour)},
+		{ProductID: "p4", Timestamp: testStart.Add(3 * time.Hour)},
+		{ProductID: "p2", Timestamp: testStart.Add(2 * time.Hour)},
+		{ProductID: "p5", Timestamp: testStart.Add(4 * time.Hour)},
+	}
+
+	// a 60/40 split falls between the two events at 3h; both go to the test part
+	train, test, cutoff := Split(events, 0.4)
+	if len(train) != 2 || train[0].ProductID != "p1" || train[1].ProductID != "p2" {
+		t.Errorf("expected p1 and p2 to train, got %v", train)
+	}
+	if len(test) != 3 {
+		t.Errorf("expected 3 test events, got %d", len(test))
+	}
+	if !cutoff.Equal(testStart.Add(3 * time.Hour)) {
+		t.Errorf("expected the cutoff at 3h, got %v", cutoff)
+	}
+	if events[0].ProductID != "p3" {
+		t.Errorf("expected the events to be left in their order")
+	}
+}
+
+func TestPersonalStrategyScoresLikeThePipeline(t *testing.T) {
+	var train []models.UserActivity
+	for i := 0; i < 3; i++ {
+		train = append(train, models.UserActivity{UserID: "u1", ProductID: "viewed", EventType: "VIEW"})
+	}
+	train = append(train,
+		models.UserActivity{UserID: "u1", ProductID: "bought", EventType: "PURCHASE"},
+		models.UserActivity{UserID: "u2", ProductID: "other", EventType: "PURCHASE"},
+	)
+
+	strategy, _ := NewStrategy(StrategyPersonal, testScoring)
+	strategy.Fit(train)
+
+	// bought: 5/1 * ln 2 = 3.47; viewed: 3/3 * ln 4 = 1.39
+	if got := strategy.Recommend("u1", 10); !reflect.DeepEqual(got, []string{"bought", "viewed"}) {
+		t.Errorf("expected [bought viewed], got %v", got)
+	}
+	if got := strategy.Recommend("u1", 1); !reflect.DeepEqual(got, []string{"bought"}) {
+		t.Errorf("expected the list to be cut at k, got %v", got)
+	}
+	if got := strategy.Recommend("new", 10); len(got) != 0 {
+		t.Errorf("expected nothing for a user without events, got %v", got)
+	}
+}
+
+func TestPersonalTrendingFillsColdStartUsers(t *testing.T) {
+	train := []models.UserActivity{
+		{UserID: "u1", ProductID: "p1", EventType: "VIEW"},
+		{UserID: "u2", ProductID: "p1", EventType: "VIEW"},
+		{UserID: "u2", ProductID: "p2", EventType: "VIEW"},
+	}
+
+	strategy, _ := NewStrategy(StrategyPersonalTrending, testScoring)
+	strategy.Fit(train)
+
+	if got := strategy.Recommend("new", 2); !reflect.DeepEqual(got, []string{"p1", "p2"}) {
+		t.Errorf("expected trending for a new user, got %v", got)
+	}
+	if got := strategy.Recommend("u1", 2); !reflect.DeepEqual(got, []string{"p1", "p2"}) {
+		t.Errorf("expected the personal list filled without repeats, got %v", got)
+	}
+}
+
+func TestEvaluateOnSyntheticDataset(t *testing.T) {
+	events := syntheticEvents(42, 60, 50, 40)
+	opts := Options{K: 5, TestFraction: 0.2}
+
+	report, err := Evaluate(events, builtInStrategies(t), opts)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if report.TrainEvents+report.TestEvents != len(events) {
+		t.Errorf("expected every event in a part, got %d + %d of %d", report.TrainEvents, report.TestEvents, len(events))
+	}
+	if report.Users == 0 || len(report.Results) != 3 {
+		t.Fatalf("expected 3 results over some users, got %d over %d", len(report.Results), report.Users)
+	}
+
+	results := map[string]models.StrategyMetrics{}
+	for _, result := range report.Results {
+		results[result.Strategy] = result
+		for name, value := range map[string]float64{
+			"precision": result.Precision, "recall": result.Recall, "map": result.MAP,
+			"ndcg": result.NDCG, "coverage": result.Coverage,
+		} {
+			if value < 0 || value > 1 {
+				t.Errorf("expected %s of %s within [0, 1], got %f", name, result.Strategy, value)
+			}
+		}
+	}
+
+	personal, trending, blended := results[StrategyPersonal], results[StrategyTrending], results[StrategyPersonalTrending]
+	if personal.Precision <= trending.Precision || personal.NDCG <= trending.NDCG {
+		t.Errorf("expected personal lists to beat trending, got %+v and %+v", personal, trending)
+	}
+	if blended.Recall < personal.Recall {
+		t.Errorf("expected filling with trending never to lose hits, got %f < %f", blended.Recall, personal.Recall)
+	}
+	if want := round4(float64(opts.K) / float64(report.Products)); trending.Coverage != want {
+		t.Errorf("expected trending to cover %f of the catalog, got %f", want, trending.Coverage)
+	}
+	if personal.Novelty <= trending.Novelty {
+		t.Errorf("expected personal lists to be more novel than trending, got %f <= %f", personal.Novelty, trending.Novelty)
+	}
+
+	again, err := Evaluate(syntheticEvents(42, 60, 50, 40), builtInStrategies(t), opts)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(report, again) {
+		t.Errorf("expected the same report for the same dataset, got %+v and %+v", report, again)
+	}
+}
+
+func TestEvaluateOnlyCountsRelevantTypes(t *testing.T) {
+	events := syntheticEvents(7, 30, 20, 20)
+
+	all, err := Evaluate(events, builtInStrategies(t), Options{K: 5, TestFraction: 0.2})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	purchases, err := Evaluate(events, builtInStrategies(t), Options{K: 5, TestFraction: 0.2, RelevantTypes: []string{"purchase"}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if purchases.Users == 0 || purchases.Users >= all.Users {
+		t.Errorf("expected fewer users with test purchases than with test events, got %d and %d", purchases.Users, all.Users)
+	}
+}
+
+func TestEvaluateRejectsInvalidOptions(t *testing.T) {
+	events := syntheticEvents(1, 5, 5, 5)
+	for _, opts := range []Options{{K: 0, TestFraction: 0.2}, {K: 5, TestFraction: 0}, {K: 5, TestFraction: 1}} {
+		if _, err := Evaluate(events, nil, opts); !errors.Is(err, ErrInvalidOptions) {
+			t.Errorf("expected ErrInvalidOptions for %+v, got %v", opts, err)
+		}
+	}
+	if _, err := Evaluate(nil, nil, Options{K: 5, TestFraction: 0.2}); !errors.Is(err, ErrNoTestUsers) {
+		t.Errorf("expected ErrNoTestUsers without events, got %v", err)
+	}
+}
+
+func TestLoadEventsNDJSON(t *testing.T) {
+	dump := `{"eventId":"e1","userId":"u1","productId":"p1","eventType":"VIEW","timestamp":"2024-01-01T10:00:00Z"}
+
+{"userId":"u2","productId":"p2","eventType":"PURCHASE","timestamp":"2024-01-02T10:00:00Z"}
+`
+	events, err := LoadEventsNDJSON(strings.NewReader(dump))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(events) != 2 || events[1].EventType != "PURCHASE" || !events[0].Timestamp.Equal(testStart.Add(10*time.Hour)) {
+		t.Errorf("expected the two events of the dump, got %+v", events)
+	}
+
+	if _, err := LoadEventsNDJSON(strings.NewReader("{\"userId\":\"u1\"}\nnot json\n")); err == nil || !strings.Contains(err.Error(), "line 2") {
+		t.Errorf("expected an error naming line 2, got %v", err)
+	}
+}
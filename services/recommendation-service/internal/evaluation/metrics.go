@@ -0,0 +1,77 @@
+package evaluation
+
+import "math"
+
+// The ranking metrics take a user's recommended products, best first, and
+// the products the user turned out to interact with in the test part.
+
+// precisionAt is the share of the k slots holding a relevant product.
+func precisionAt(recommended []string, relevant map[string]bool, k int) float64 {
+	return float64(hits(recommended, relevant, k)) / float64(k)
+}
+
+// recallAt is the share of the relevant products found in the k slots.
+func recallAt(recommended []string, relevant map[string]bool, k int) float64 {
+	if len(relevant) == 0 {
+		return 0
+	}
+	return float64(hits(recommended, relevant, k)) / float64(len(relevant))
+}
+
+// averagePrecisionAt averages the precision at the rank of every hit,
+// normalised by the most hits k slots can hold.
+func averagePrecisionAt(recommended []string, relevant map[string]bool, k int) float64 {
+	if len(relevant) == 0 {
+		return 0
+	}
+
+	found, sum := 0, 0.0
+	for i, id := range head(recommended, k) {
+		if relevant[id] {
+			found++
+			sum += float64(found) / float64(i+1)
+		}
+	}
+	return sum / float64(min(len(relevant), k))
+}
+
+// ndcgAt discounts every hit by the log of its rank and compares the sum
+// with the best possible ranking, relevance being binary.
+func ndcgAt(recommended []string, relevant map[string]bool, k int) float64 {
+	dcg := 0.0
+	for i, id := range head(recommended, k) {
+		if relevant[id] {
+			dcg += 1 / math.Log2(float64(i+2))
+		}
+	}
+
+	ideal := 0.0
+	for i := 0; i < min(len(relevant), k); i++ {
+		ideal += 1 / math.Log2(float64(i+2))
+	}
+	if ideal == 0 {
+		return 0
+	}
+	return dcg / ideal
+}
+
+func hits(recommended []string, relevant map[string]bool, k int) int {
+	count := 0
+	for _, id := range head(recommended, k) {
+		if relevant[id] {
+			count++
+		}
+	}
+	return count
+}
+
+// selfInformation is -log2 of the share of training users who interacted
+// with a product, smoothed so that products nobody interacted with stay
+// finite.
+func selfInformation(users, totalUsers int) float64 {
+	return -math.Log2(float64(users+1) / float64(totalUsers+1))
+}
+
+func round4(value float64) float64 {
+	return math.Round(value*10000) / 10000
+}
@@ -0,0 +1,58 @@
+package evaluation
+
+import (
+	"math"
+	"testing"
+)
+
+func relevantSet(ids ...string) map[string]bool {
+	set := map[string]bool{}
+	for _, id := range ids {
+		set[id] = true
+	}
+	return set
+}
+
+func assertClose(t *testing.T, name string, got, want float64) {
+	t.Helper()
+	if math.Abs(got-want) > 1e-9 {
+		t.Errorf("expected %s %.6f, got %.6f", name, want, got)
+	}
+}
+
+func TestRankingMetrics(t *testing.T) {
+	recommended := []string{"p1", "p2", "p3", "p4"}
+	relevant := relevantSet("p1", "p3", "p9")
+
+	assertClose(t, "precision@4", precisionAt(recommended, relevant, 4), 2.0/4)
+	assertClose(t, "recall@4", recallAt(recommended, relevant, 4), 2.0/3)
+	// hits at ranks 1 and 3: (1/1 + 2/3) / min(3, 4)
+	assertClose(t, "AP@4", averagePrecisionAt(recommended, relevant, 4), (1+2.0/3)/3)
+	// DCG 1/log2(2) + 1/log2(4) over the ideal 1 + 1/log2(3) + 1/log2(4)
+	assertClose(t, "NDCG@4", ndcgAt(recommended, relevant, 4), 1.5/(1.5+1/math.Log2(3)))
+}
+
+func TestRankingMetricsOnlyScoreTheFirstKSlots(t *testing.T) {
+	recommended := []string{"p2", "p1"}
+	relevant := relevantSet("p1")
+
+	assertClose(t, "precision@1", precisionAt(recommended, relevant, 1), 0)
+	assertClose(t, "recall@1", recallAt(recommended, relevant, 1), 0)
+	assertClose(t, "AP@1", averagePrecisionAt(recommended, relevant, 1), 0)
+	assertClose(t, "NDCG@1", ndcgAt(recommended, relevant, 1), 0)
+}
+
+func TestShortListsCountEmptySlotsAsMisses(t *testing.T) {
+	relevant := relevantSet("p1")
+
+	assertClose(t, "precision@5", precisionAt([]string{"p1"}, relevant, 5), 0.2)
+	assertClose(t, "AP@5", averagePrecisionAt([]string{"p1"}, relevant, 5), 1)
+	assertClose(t, "NDCG@5", ndcgAt([]string{"p1"}, relevant, 5), 1)
+	assertClose(t, "precision@5 of no list", precisionAt(nil, relevant, 5), 0)
+}
+
+func TestSelfInformation(t *testing.T) {
+	// a product every one of 3 users had is not novel at all
+	assertClose(t, "popular", selfInformation(3, 3), 0)
+	assertClose(t, "unseen", selfInformation(0, 3), 2)
+}
@@ -0,0 +1,184 @@
+package evaluation
+
+import (
+	"fmt"
+	"sort"
+
+	"polyforge-recommendation/internal/config"
+	"polyforge-recommendation/internal/models"
+	"polyforge-recommendation/internal/scoring"
+)
+
+const (
+	StrategyPersonal         = "personal"
+	StrategyTrending         = "trending"
+	StrategyPersonalTrending = "personal+trending"
+)
+
+// Strategy is a way of recommending products that can be fitted on past
+// events and asked for a user's top products.
+type Strategy interface {
+	Name() string
+	// Fit learns from the training events; it is called once, before any
+	// Recommend.
+	Fit(train []models.UserActivity)
+	// Recommend returns at most k product IDs for the user, best first.
+	Recommend(userID string, k int) []string
+}
+
+// NewStrategy returns a strategy built into the service by name, scoring
+// with the given weights.
+func NewStrategy(name string, scoring config.ScoringConfig) (Strategy, error) {
+	switch name {
+	case StrategyPersonal:
+		return &personalStrategy{weights: scoring.Personal}, nil
+	case StrategyTrending:
+		return &trendingStrategy{weights: scoring.Trending}, nil
+	case StrategyPersonalTrending:
+		return &personalTrendingStrategy{
+			personal: personalStrategy{weights: scoring.Personal},
+			trending: trendingStrategy{weights: scoring.Trending},
+		}, nil
+	default:
+		return nil, fmt.Errorf("unknown strategy %q", name)
+	}
+}
+
+// productStats counts a product's events, like the group stage of the
+// scoring pipeline.
+type productStats struct {
+	count    int
+	view     int
+	cartAdd  int
+	purchase int
+}
+
+func (p *productStats) add(eventType string) {
+	p.count++
+	switch eventType {
+	case "VIEW":
+		p.view++
+	case "CART_ADD":
+		p.cartAdd++
+	case "PURCHASE":
+		p.purchase++
+	}
+}
+
+// score computes a product's score the way the service's scoring pipeline
+// does, so offline results carry over to rebuilds.
+func (p *productStats) score(weights config.ScoringWeights) float64 {
+	return scoring.Score(scoring.Counts{Count: p.count, View: p.view, CartAdd: p.cartAdd, Purchase: p.purchase}, weights)
+}
+
+// rankProducts orders products by score; ties go by product ID so that runs
+// are repeatable.
+func rankProducts(stats map[string]*productStats, weights config.ScoringWeights) []string {
+	ids := make([]string, 0, len(stats))
+	scores := make(map[string]float64, len(stats))
+	for id, product := range stats {
+		ids = append(ids, id)
+		scores[id] = product.score(weights)
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		if scores[ids[i]] != scores[ids[j]] {
+			return scores[ids[i]] > scores[ids[j]]
+		}
+		return ids[i] < ids[j]
+	})
+	return ids
+}
+
+func head(ids []string, k int) []string {
+	if len(ids) > k {
+		return ids[:k]
+	}
+	return ids
+}
+
+// personalStrategy is the stored user list of a rebuild: the user's own
+// products scored with the personal weights.
+type personalStrategy struct {
+	weights config.ScoringWeights
+	lists   map[string][]string
+}
+
+func (s *personalStrategy) Name() string { return StrategyPersonal }
+
+func (s *personalStrategy) Fit(train []models.UserActivity) {
+	byUser := map[string]map[string]*productStats{}
+	for _, event := range train {
+		products := byUser[event.UserID]
+		if products == nil {
+			products = map[string]*productStats{}
+			byUser[event.UserID] = products
+		}
+		if products[event.ProductID] == nil {
+			products[event.ProductID] = &productStats{}
+		}
+		products[event.ProductID].add(event.EventType)
+	}
+
+	s.lists = make(map[string][]string, len(byUser))
+	for userID, products := range byUser {
+		s.lists[userID] = rankProducts(products, s.weights)
+	}
+}
+
+func (s *personalStrategy) Recommend(userID string, k int) []string {
+	return head(s.lists[userID], k)
+}
+
+// trendingStrategy recommends the global trending list to everyone.
+type trendingStrategy struct {
+	weights config.ScoringWeights
+	list    []string
+}
+
+func (s *trendingStrategy) Name() string { return StrategyTrending }
+
+func (s *trendingStrategy) Fit(train []models.UserActivity) {
+	products := map[string]*productStats{}
+	for _, event := range train {
+		if products[event.ProductID] == nil {
+			products[event.ProductID] = &productStats{}
+		}
+		products[event.ProductID].add(event.EventType)
+	}
+	s.list = rankProducts(products, s.weights)
+}
+
+func (s *trendingStrategy) Recommend(userID string, k int) []string {
+	return head(s.list, k)
+}
+
+// personalTrendingStrategy fills what the personal list leaves of the k
+// slots with trending products, like the service's trending fallback.
+type personalTrendingStrategy struct {
+	personal personalStrategy
+	trending trendingStrategy
+}
+
+func (s *personalTrendingStrategy) Name() string { return StrategyPersonalTrending }
+
+func (s *personalTrendingStrategy) Fit(train []models.UserActivity) {
+	s.personal.Fit(train)
+	s.trending.Fit(train)
+}
+
+func (s *personalTrendingStrategy) Recommend(userID string, k int) []string {
+	recommended := append([]string{}, s.personal.Recommend(userID, k)...)
+	seen := make(map[string]bool, len(recommended))
+	for _, id := range recommended {
+		seen[id] = true
+	}
+	for _, id := range s.trending.list {
+		if len(recommended) >= k {
+			break
+		}
+		if !seen[id] {
+			recommended = append(recommended, id)
+		}
+	}
+	return recommended
+}
@@ -0,0 +1,113 @@
+package messaging
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// ErrMalformedMessage marks messages that can never be processed. They are
+// dropped instead of being redelivered.
+var ErrMalformedMessage = errors.New("malformed message")
+
+type Handler func(ctx context.Context, body []byte) error
+
+// Subscription binds a durable queue to a topic exchange.
+type Subscription struct {
+	Exchange   string
+	RoutingKey string
+	Queue      string
+}
+
+type Consumer struct {
+	uri        string
+	retryDelay time.Duration
+}
+
+func NewConsumer(uri string, retryDelay time.Duration) *Consumer {
+	return &Consumer{uri: uri, retryDelay: retryDelay}
+}
+
+// Consume delivers messages of the subscription to the handler until the
+// context is cancelled, reconnecting after connection failures. Messages are
+// acknowledged once handled; failed ones are requeued unless malformed.
+func (c *Consumer) Consume(ctx context.Context, subscription Subscription, handler Handler) {
+	for {
+		err := c.consume(ctx, subscription, handler)
+		if ctx.Err() != nil {
+			return
+		}
+		fmt.Printf("Error consuming from queue %s: %v\n", subscription.Queue, err)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(c.retryDelay):
+		}
+	}
+}
+
+func (c *Consumer) consume(ctx context.Context, subscription Subscription, handler Handler) error {
+	conn, err := amqp.Dial(c.uri)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	ch, err := conn.Channel()
+	if err != nil {
+		return err
+	}
+	defer ch.Close()
+
+	if err := ch.ExchangeDeclare(subscription.Exchange, "topic", true, false, false, false, nil); err != nil {
+		return err
+	}
+	if _, err := ch.QueueDeclare(subscription.Queue, true, false, false, false, nil); err != nil {
+		return err
+	}
+	if err := ch.QueueBind(subscription.Queue, subscription.RoutingKey, subscription.Exchange, false, nil); err != nil {
+		return err
+	}
+
+	deliveries, err := ch.Consume(subscription.Queue, "", false, false, false, false, nil)
+	if err != nil {
+		return err
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case delivery, ok := <-deliveries:
+			if !ok {
+				return errors.New("delivery channel closed")
+			}
+			c.handle(ctx, subscription, delivery, handler)
+		}
+	}
+}
+
+func (c *Consumer) handle(ctx context.Context, subscription Subscription, delivery amqp.Delivery, handler Handler) {
+	err := handler(ctx, delivery.Body)
+	if err == nil {
+		delivery.Ack(false)
+		return
+	}
+
+	fmt.Printf("Error handling message from queue %s: %v\n", subscription.Queue, err)
+	if errors.Is(err, ErrMalformedMessage) {
+		delivery.Nack(false, false)
+		return
+	}
+
+	// give the failing dependency time to recover before the redelivery
+	select {
+	case <-ctx.Done():
+	case <-time.After(c.retryDelay):
+	}
+	delivery.Nack(false, true)
+}
@@ -0,0 +1,8 @@
+package models
+
+// CatalogImportReport summarises a bulk catalog import.
+type CatalogImportReport struct {
+	Imported int      `json:"imported"`
+	Skipped  int      `json:"skipped"`
+	Errors   []string `json:"errors,omitempty"`
+}
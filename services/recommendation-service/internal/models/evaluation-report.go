@@ -0,0 +1,31 @@
+package models
+
+import "time"
+
+// EvaluationReport compares scoring strategies offline: each is fitted on
+// the events before Cutoff and scored on what the users did from Cutoff on.
+type EvaluationReport struct {
+	Cutoff      time.Time `json:"cutoff"`
+	TrainEvents int       `json:"trainEvents"`
+	TestEvents  int       `json:"testEvents"`
+	// Users is the number of users with relevant events in the test part,
+	// over which the ranking metrics are averaged.
+	Users    int               `json:"users"`
+	Products int               `json:"products"`
+	K        int               `json:"k"`
+	Results  []StrategyMetrics `json:"results"`
+}
+
+// StrategyMetrics are the holdout metrics of a strategy at K.
+type StrategyMetrics struct {
+	Strategy  string  `json:"strategy"`
+	Precision float64 `json:"precision"`
+	Recall    float64 `json:"recall"`
+	MAP       float64 `json:"map"`
+	NDCG      float64 `json:"ndcg"`
+	// Coverage is the share of the catalog recommended to at least one user.
+	Coverage float64 `json:"coverage"`
+	// Novelty is the mean self-information, in bits, of the recommended
+	// products' popularity among training users; higher is less obvious.
+	Novelty float64 `json:"novelty"`
+}
@@ -0,0 +1,18 @@
+package models
+
+// EventImportReport summarises an event backfill. In a dry run nothing is
+// written and Imported counts the events that would have been.
+type EventImportReport struct {
+	DryRun     bool             `json:"dryRun"`
+	Read       int              `json:"read"`
+	Imported   int              `json:"imported"`
+	Duplicates int              `json:"duplicates"`
+	Rejected   int              `json:"rejected"`
+	Rejections []EventRejection `json:"rejections,omitempty"`
+}
+
+// EventRejection is a row of an event file that could not be imported.
+type EventRejection struct {
+	Line   int    `json:"line"`
+	Reason string `json:"reason"`
+}
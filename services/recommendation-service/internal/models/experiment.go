@@ -0,0 +1,68 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+const (
+	ExperimentStatusRunning = "RUNNING"
+	ExperimentStatusStopped = "STOPPED"
+)
+
+// Experiment splits users of some placements between variants that rank
+// recommendations differently.
+type Experiment struct {
+	ID   bson.ObjectID `json:"id" bson:"_id,omitempty"`
+	Name string        `json:"name" bson:"name"`
+	// Placements limits the experiment to some placements; empty means all.
+	Placements []string            `json:"placements" bson:"placements"`
+	Variants   []ExperimentVariant `json:"variants" bson:"variants"`
+	Status     string              `json:"status" bson:"status"`
+	CreatedAt  time.Time           `json:"createdAt" bson:"createdAt"`
+	StoppedAt  *time.Time          `json:"stoppedAt,omitempty" bson:"stoppedAt,omitempty"`
+}
+
+// ExperimentVariant receives a share of users proportional to its weight and
+// serves them with its strategy.
+type ExperimentVariant struct {
+	Name     string          `json:"name" bson:"name"`
+	Weight   int             `json:"weight" bson:"weight"`
+	Strategy VariantStrategy `json:"strategy" bson:"strategy"`
+}
+
+// VariantStrategy overrides the configured ranking; empty fields keep the
+// configured value.
+type VariantStrategy struct {
+	BlendMode       string             `json:"blendMode,omitempty" bson:"blendMode,omitempty"`
+	BlendWeights    map[string]float64 `json:"blendWeights,omitempty" bson:"blendWeights,omitempty"`
+	DiversityMethod string             `json:"diversityMethod,omitempty" bson:"diversityMethod,omitempty"`
+	DiversityLambda *float64           `json:"diversityLambda,omitempty" bson:"diversityLambda,omitempty"`
+}
+
+// ExperimentAssignment is the variant a user sees in an experiment.
+type ExperimentAssignment struct {
+	ExperimentID string          `json:"experimentId"`
+	Variant      string          `json:"variant"`
+	Strategy     VariantStrategy `json:"-"`
+}
+
+// VariantResult reports how a variant performed. Rates come with 95% Wilson
+// score intervals.
+type VariantResult struct {
+	Variant        string     `json:"variant"`
+	Impressions    int64      `json:"impressions"`
+	Clicks         int64      `json:"clicks"`
+	Conversions    int64      `json:"conversions"`
+	CTR            float64    `json:"ctr"`
+	CTRInterval    [2]float64 `json:"ctrInterval"`
+	ConversionRate float64    `json:"conversionRate"`
+	// ConversionInterval is the interval of conversions per impression.
+	ConversionInterval [2]float64 `json:"conversionInterval"`
+}
+
+type ExperimentResults struct {
+	Experiment Experiment      `json:"experiment"`
+	Variants   []VariantResult `json:"variants"`
+}
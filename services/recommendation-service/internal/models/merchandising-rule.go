@@ -0,0 +1,63 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+const (
+	RuleActionPin   = "PIN"
+	RuleActionBoost = "BOOST"
+	RuleActionBury  = "BURY"
+	RuleActionBlock = "BLOCK"
+)
+
+// MerchandisingRule changes the lists the service returns without a code
+// change: it pins a product to a position, multiplies the score of matching
+// products (boost), moves them to the end (bury) or removes them (block).
+type MerchandisingRule struct {
+	ID     bson.ObjectID `json:"id" bson:"_id,omitempty"`
+	Name   string        `json:"name" bson:"name"`
+	Action string        `json:"action" bson:"action"`
+	// Placements restricts the rule to some placements; empty means all.
+	Placements []string  `json:"placements" bson:"placements"`
+	Match      RuleMatch `json:"match" bson:"match"`
+	// Position is the 1-based position of pinned products.
+	Position int `json:"position,omitempty" bson:"position,omitempty"`
+	// Factor multiplies the score of boosted products.
+	Factor    float64    `json:"factor,omitempty" bson:"factor,omitempty"`
+	StartsAt  *time.Time `json:"startsAt,omitempty" bson:"startsAt,omitempty"`
+	EndsAt    *time.Time `json:"endsAt,omitempty" bson:"endsAt,omitempty"`
+	Enabled   bool       `json:"enabled" bson:"enabled"`
+	CreatedAt time.Time  `json:"createdAt" bson:"createdAt"`
+	UpdatedAt time.Time  `json:"updatedAt" bson:"updatedAt"`
+}
+
+// RuleMatch selects the products a rule applies to. A product matches when
+// it matches any of the given criteria.
+type RuleMatch struct {
+	ProductIDs []string `json:"productIds,omitempty" bson:"productIds,omitempty"`
+	Brands     []string `json:"brands,omitempty" bson:"brands,omitempty"`
+	Categories []string `json:"categories,omitempty" bson:"categories,omitempty"`
+	Tags       []string `json:"tags,omitempty" bson:"tags,omitempty"`
+}
+
+// ActiveAt reports whether the rule is enabled and within its validity.
+func (r MerchandisingRule) ActiveAt(now time.Time) bool {
+	if !r.Enabled {
+		return false
+	}
+	if r.StartsAt != nil && now.Before(*r.StartsAt) {
+		return false
+	}
+	return r.EndsAt == nil || now.Before(*r.EndsAt)
+}
+
+// AppliedRule records a rule that changed a returned list.
+type AppliedRule struct {
+	RuleID     string   `json:"ruleId"`
+	Name       string   `json:"name"`
+	Action     string   `json:"action"`
+	ProductIDs []string `json:"productIds"`
+}
@@ -0,0 +1,48 @@
+package models
+
+import "time"
+
+// Product is the recommendation service's copy of the catalog and inventory
+// data it needs to filter and enrich recommendations.
+type Product struct {
+	ProductID   string    `json:"productId" bson:"productId"`
+	SKU         string    `json:"sku,omitempty" bson:"sku,omitempty"`
+	Name        string    `json:"name,omitempty" bson:"name,omitempty"`
+	Description string    `json:"description,omitempty" bson:"description,omitempty"`
+	Category    string    `json:"category,omitempty" bson:"category,omitempty"`
+	Brand       string    `json:"brand,omitempty" bson:"brand,omitempty"`
+	Tags        []string  `json:"tags,omitempty" bson:"tags,omitempty"`
+	Price       float64   `json:"price" bson:"price"`
+	Currency    string    `json:"currency,omitempty" bson:"currency,omitempty"`
+	ImageURL    string    `json:"imageUrl,omitempty" bson:"imageUrl,omitempty"`
+	Available   *int      `json:"available,omitempty" bson:"available,omitempty"`
+	CreatedAt   time.Time `json:"createdAt" bson:"createdAt"`
+	UpdatedAt   time.Time `json:"updatedAt" bson:"updatedAt"`
+}
+
+// InStock treats products the inventory service has not reported on as
+// available; only a known stock of zero or less counts as out of stock.
+func (p Product) InStock() bool {
+	return p.Available == nil || *p.Available > 0
+}
+
+// ProductSummary is the catalog data embedded in expanded recommendations.
+type ProductSummary struct {
+	Name     string  `json:"name"`
+	ImageURL string  `json:"imageUrl"`
+	Price    float64 `json:"price"`
+	Currency string  `json:"currency"`
+	Category string  `json:"category"`
+	Brand    string  `json:"brand"`
+}
+
+func (p Product) Summary() *ProductSummary {
+	return &ProductSummary{
+		Name:     p.Name,
+		ImageURL: p.ImageURL,
+		Price:    p.Price,
+		Currency: p.Currency,
+		Category: p.Category,
+		Brand:    p.Brand,
+	}
+}
@@ -0,0 +1,30 @@
+package models
+
+type UserRecommendationDiff struct {
+	UserID          string   `json:"userId"`
+	Jaccard         float64  `json:"jaccard"`
+	RankCorrelation *float64 `json:"rankCorrelation"`
+	Added           []string `json:"added"`
+	Removed         []string `json:"removed"`
+}
+
+type RebuildDiffSummary struct {
+	UsersCompared       int      `json:"usersCompared"`
+	UsersChanged        int      `json:"usersChanged"`
+	UsersWithoutHistory int      `json:"usersWithoutHistory"`
+	MeanJaccard         float64  `json:"meanJaccard"`
+	MeanRankCorrelation *float64 `json:"meanRankCorrelation"`
+	ItemsAdded          int      `json:"itemsAdded"`
+	ItemsRemoved        int      `json:"itemsRemoved"`
+}
+
+// RebuildDiffReport compares a dry-run rebuild against the lists stored in
+// the active snapshot, looking at the top K products of each list. Sample is
+// the number of randomly chosen users scored, 0 when every user was.
+type RebuildDiffReport struct {
+	BaseVersion int                      `json:"baseVersion"`
+	TopK        int                      `json:"topK"`
+	Sample      int                      `json:"sample"`
+	Summary     RebuildDiffSummary       `json:"summary"`
+	Users       []UserRecommendationDiff `json:"users"`
+}
@@ -0,0 +1,18 @@
+package models
+
+import "time"
+
+const (
+	SnapshotStatusBuilding = "BUILDING"
+	SnapshotStatusReady    = "READY"
+	SnapshotStatusFailed   = "FAILED"
+)
+
+type RecommendationSnapshot struct {
+	Version     int        `json:"version" bson:"version"`
+	Status      string     `json:"status" bson:"status"`
+	UserCount   int        `json:"userCount" bson:"userCount"`
+	CreatedAt   time.Time  `json:"createdAt" bson:"createdAt"`
+	CompletedAt *time.Time `json:"completedAt,omitempty" bson:"completedAt,omitempty"`
+	Active      bool       `json:"active" bson:"-"`
+}
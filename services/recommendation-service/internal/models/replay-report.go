@@ -0,0 +1,10 @@
+package models
+
+// ReplayReport summarises events replayed against the event endpoint.
+type ReplayReport struct {
+	Sent   int `json:"sent"`
+	Failed int `json:"failed"`
+	// Rate is the achieved rate in events per second.
+	Rate      float64 `json:"rate"`
+	LastError string  `json:"lastError,omitempty"`
+}
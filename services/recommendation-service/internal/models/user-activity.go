@@ -11,5 +11,14 @@ type UserActivity struct {
 	UserID    string             `bson:"userId,omitempty" json:"userId,omitempty"`
 	ProductID string             `bson:"productId,omitempty" json:"productId,omitempty"`
 	EventType string             `bson:"eventType,omitempty" json:"eventType,omitempty"`
-	Timestamp time.Time          `bson:"timestamp" json:"timestamp"`
+	Segment   string             `bson:"segment,omitempty" json:"segment,omitempty"`
+	// EventID is the event's ID in the system it was imported from, which
+	// keeps repeated imports from duplicating it.
+	EventID string `bson:"eventId,omitempty" json:"eventId,omitempty"`
+	// Placement, ExperimentID and Variant attribute the event to a
+	// recommendation the user was shown.
+	Placement    string    `bson:"placement,omitempty" json:"placement,omitempty"`
+	ExperimentID string    `bson:"experimentId,omitempty" json:"experimentId,omitempty"`
+	Variant      string    `bson:"variant,omitempty" json:"variant,omitempty"`
+	Timestamp    time.Time `bson:"timestamp" json:"timestamp"`
 }
@@ -0,0 +1,25 @@
+package models
+
+import "time"
+
+// UserInspection is what the service holds about a user, for operators
+// debugging their recommendations.
+type UserInspection struct {
+	UserID        string `json:"userId"`
+	ActiveVersion int    `json:"activeVersion"`
+	// Stored is the user's list in the active snapshot.
+	Stored []ProductRecommendation `json:"stored"`
+	// Cached is the user's cached list, nil when none is cached.
+	Cached       *CachedListState `json:"cached"`
+	RecentEvents []UserActivity   `json:"recentEvents"`
+}
+
+// CachedListState describes a cached user list without its products.
+type CachedListState struct {
+	Version    int       `json:"version"`
+	Products   int       `json:"products"`
+	FreshUntil time.Time `json:"freshUntil"`
+	// Stale is set when the list is past its freshness or was cached
+	// before the last invalidation.
+	Stale bool `json:"stale"`
+}
@@ -7,9 +7,42 @@ type ProductRecommendation struct {
 	Score           float64   `json:"score" bson:"score"`
 	Count           int       `json:"count" bson:"count"`
 	LastInteraction time.Time `json:"lastInteraction" bson:"lastInteraction"`
+	// ViewCount, CartAddCount and PurchaseCount break Count down by event
+	// type for scored products.
+	ViewCount     int `json:"viewCount,omitempty" bson:"viewCount,omitempty"`
+	CartAddCount  int `json:"cartAddCount,omitempty" bson:"cartAddCount,omitempty"`
+	PurchaseCount int `json:"purchaseCount,omitempty" bson:"purchaseCount,omitempty"`
+	// Source names the candidate source that filled the slot on responses,
+	// e.g. personal or trending.
+	Source string `json:"source,omitempty" bson:"-"`
+	// Product is only set on responses with ?expand=product.
+	Product *ProductSummary `json:"product,omitempty" bson:"-"`
+	// Reason is only set on responses with ?explain=true.
+	Reason *RecommendationReason `json:"reason,omitempty" bson:"-"`
+}
+
+// RecommendationReason explains why a product was recommended.
+type RecommendationReason struct {
+	Source  string `json:"source"`
+	Message string `json:"message"`
+	// RelatedProductIDs are the products that led to this one, such as the
+	// user's product it was found from.
+	RelatedProductIDs []string `json:"relatedProductIds,omitempty"`
+	// Rules names the merchandising rules that moved the product.
+	Rules []string `json:"rules,omitempty"`
+	// Contributions splits the score of products scored from events.
+	Contributions *ScoreContributions `json:"contributions,omitempty"`
+}
+
+// ScoreContributions is the part of a score owed to each event type.
+type ScoreContributions struct {
+	View     float64 `json:"view"`
+	CartAdd  float64 `json:"cartAdd"`
+	Purchase float64 `json:"purchase"`
 }
 
 type UserRecommendation struct {
-	UserID   string                  `json:"userId"`
-	Products []ProductRecommendation `json:"products"`
+	UserID   string                  `json:"userId" bson:"userId"`
+	Version  int                     `json:"version" bson:"version"`
+	Products []ProductRecommendation `json:"products" bson:"products"`
 }
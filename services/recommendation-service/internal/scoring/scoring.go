@@ -0,0 +1,93 @@
+// Package scoring holds the event score of a product. The rebuild computes
+// it in Mongo with the service's scoring pipeline, which is built from the
+// constants here; explanations and offline evaluation compute it in Go with
+// the functions here, so all three agree.
+package scoring
+
+import (
+	"math"
+
+	"polyforge-recommendation/internal/config"
+	"polyforge-recommendation/internal/models"
+)
+
+// MaxScore caps every score.
+const MaxScore = 10
+
+// Decimals is the number of decimal places scores are rounded to.
+const Decimals = 2
+
+// CountFactorTier multiplies the score of products with at least MinCount
+// events by Factor.
+type CountFactorTier struct {
+	MinCount int
+	Factor   float64
+}
+
+// CountFactorTiers rewards products with many events, highest tier first.
+// Products below the last tier keep a factor of 1.
+var CountFactorTiers = []CountFactorTier{
+	{MinCount: 100, Factor: 2.0},
+	{MinCount: 50, Factor: 1.5},
+	{MinCount: 10, Factor: 1.2},
+}
+
+// Counts are a product's events, in total and by scored event type.
+type Counts struct {
+	Count    int
+	View     int
+	CartAdd  int
+	Purchase int
+}
+
+// CountFactor returns the factor of the first tier the count reaches.
+func CountFactor(count int) float64 {
+	for _, tier := range CountFactorTiers {
+		if count >= tier.MinCount {
+			return tier.Factor
+		}
+	}
+	return 1.0
+}
+
+// Score returns the average weighted score per event, scaled by the count
+// factor and the log of the event count, capped at MaxScore and rounded.
+// Products without events score 0.
+func Score(counts Counts, weights config.ScoringWeights) float64 {
+	if counts.Count <= 0 {
+		return 0
+	}
+	raw := float64(counts.View)*weights.View + float64(counts.CartAdd)*weights.CartAdd + float64(counts.Purchase)*weights.Purchase
+	count := float64(counts.Count)
+	return round(math.Min(raw/count*CountFactor(counts.Count)*math.Log(count+1), MaxScore))
+}
+
+// Contributions splits Score between the event types. When the score is
+// capped, the contributions shrink in proportion.
+func Contributions(counts Counts, weights config.ScoringWeights) models.ScoreContributions {
+	if counts.Count <= 0 {
+		return models.ScoreContributions{}
+	}
+	count := float64(counts.Count)
+	scale := CountFactor(counts.Count) * math.Log(count+1) / count
+
+	contributions := models.ScoreContributions{
+		View:     float64(counts.View) * weights.View * scale,
+		CartAdd:  float64(counts.CartAdd) * weights.CartAdd * scale,
+		Purchase: float64(counts.Purchase) * weights.Purchase * scale,
+	}
+	if total := contributions.View + contributions.CartAdd + contributions.Purchase; total > MaxScore {
+		contributions.View *= MaxScore / total
+		contributions.CartAdd *= MaxScore / total
+		contributions.Purchase *= MaxScore / total
+	}
+	contributions.View = round(contributions.View)
+	contributions.CartAdd = round(contributions.CartAdd)
+	contributions.Purchase = round(contributions.Purchase)
+	return contributions
+}
+
+func round(value float64) float64 {
+	scale := math.Pow(10, Decimals)
+	return math.Round(value*scale) / scale
+}
@@ -0,0 +1,72 @@
+package scoring
+
+import (
+	"testing"
+
+	"polyforge-recommendation/internal/config"
+	"polyforge-recommendation/internal/models"
+)
+
+var weights = config.ScoringWeights{View: 1, CartAdd: 3, Purchase: 5}
+
+func TestCountFactorTiers(t *testing.T) {
+	tests := []struct {
+		count  int
+		factor float64
+	}{
+		{1, 1.0}, {9, 1.0},
+		{10, 1.2}, {49, 1.2},
+		{50, 1.5}, {99, 1.5},
+		{100, 2.0}, {1000, 2.0},
+	}
+	for _, tt := range tests {
+		if got := CountFactor(tt.count); got != tt.factor {
+			t.Errorf("CountFactor(%d) = %v, expected %v", tt.count, got, tt.factor)
+		}
+	}
+}
+
+func TestScoreFollowsThePipelineFormula(t *testing.T) {
+	if MaxScore != 10 || Decimals != 2 {
+		t.Fatalf("expected scores capped at 10 and rounded to 2 decimals, got %v and %d", MaxScore, Decimals)
+	}
+
+	tests := []struct {
+		counts Counts
+		score  float64
+	}{
+		// 5/3 * ln(4)
+		{Counts{Count: 3, View: 2, CartAdd: 1}, 2.31},
+		// ln(10) below the first tier and 1.2 * ln(11) on it
+		{Counts{Count: 9, View: 9}, 2.30},
+		{Counts{Count: 10, View: 10}, 2.88},
+		// 1.2 * ln(50) and 1.5 * ln(51) either side of the second tier
+		{Counts{Count: 49, View: 49}, 4.69},
+		{Counts{Count: 50, View: 50}, 5.90},
+		// 5 * 2 * ln(101) is capped
+		{Counts{Count: 100, Purchase: 100}, 10},
+		{Counts{}, 0},
+	}
+	for _, tt := range tests {
+		if got := Score(tt.counts, weights); got != tt.score {
+			t.Errorf("Score(%+v) = %v, expected %v", tt.counts, got, tt.score)
+		}
+	}
+}
+
+func TestContributionsSplitTheScore(t *testing.T) {
+	tests := []struct {
+		counts        Counts
+		contributions models.ScoreContributions
+	}{
+		// 2 * ln(4)/3 and 3 * ln(4)/3, adding up to the score of 2.31
+		{Counts{Count: 3, View: 2, CartAdd: 1}, models.ScoreContributions{View: 0.92, CartAdd: 1.39}},
+		// a capped score of 10 split 50:250 between views and purchases
+		{Counts{Count: 100, View: 50, Purchase: 50}, models.ScoreContributions{View: 1.67, Purchase: 8.33}},
+	}
+	for _, tt := range tests {
+		if got := Contributions(tt.counts, weights); got != tt.contributions {
+			t.Errorf("Contributions(%+v) = %+v, expected %+v", tt.counts, got, tt.contributions)
+		}
+	}
+}
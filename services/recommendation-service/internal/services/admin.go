@@ -0,0 +1,117 @@
+package services
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+
+	"polyforge-recommendation/internal/models"
+)
+
+// inspectedEvents is how many of a user's events an inspection shows.
+const inspectedEvents = 20
+
+// RebuildSnapshot rebuilds every user's list into a new snapshot and waits
+// for it, returning the snapshot as it finished: ready and active, or failed.
+func (s *RecommendationService) RebuildSnapshot(ctx context.Context) (models.RecommendationSnapshot, error) {
+	var snapshot models.RecommendationSnapshot
+	version, err := s.CreateSnapshot(ctx)
+	if err != nil {
+		return snapshot, err
+	}
+
+	s.ReCalculateUserRecommendations(ctx, version)
+
+	err = s.db.Collection("recommendation_snapshots").FindOne(ctx, bson.M{"version": version}).Decode(&snapshot)
+	if err != nil {
+		return snapshot, err
+	}
+	activeVersion, err := s.GetActiveSnapshotVersion(ctx)
+	if err != nil {
+		return snapshot, err
+	}
+	snapshot.Active = snapshot.Version == activeVersion
+	return snapshot, nil
+}
+
+// RebuildUserRecommendations rescores a single user into the active snapshot
+// and drops their cached list on every replica, e.g. after fixing their
+// events. The rest of the snapshot is left as it is.
+func (s *RecommendationService) RebuildUserRecommendations(ctx context.Context, userID string) (models.UserRecommendation, error) {
+	recommendation := models.UserRecommendation{UserID: userID}
+	version, err := s.GetActiveSnapshotVersion(ctx)
+	if err != nil {
+		return recommendation, err
+	}
+	recommendation.Version = version
+
+	if recommendation.Products, err = s.scoreUserProducts(ctx, userID, s.cfg.Scoring.Personal); err != nil {
+		return recommendation, err
+	}
+	if err := s.storeUserRecommendations(ctx, recommendation); err != nil {
+		return recommendation, err
+	}
+
+	key := s.cfg.Cache.UserRecommendationKey(userID)
+	if err := s.cache.Del(ctx, key).Err(); err != nil {
+		return recommendation, err
+	}
+	if s.local != nil {
+		s.local.Delete(key)
+	}
+	return recommendation, s.publishCacheInvalidation(ctx, invalidateUserPrefix+userID)
+}
+
+// ClearCache deletes every cached user list, blend and the current trending
+// list, so the next reads go to Mongo. Pinned blends and trending lists stay
+// until they expire so open cursors keep working.
+func (s *RecommendationService) ClearCache(ctx context.Context) error {
+	if err := s.cache.Del(ctx, s.cfg.Cache.TrendingKey()).Err(); err != nil {
+		return err
+	}
+	return s.clearUserRecommendationCache(ctx)
+}
+
+// InspectUser gathers the user's stored and cached lists and recent events.
+func (s *RecommendationService) InspectUser(ctx context.Context, userID string) (models.UserInspection, error) {
+	inspection := models.UserInspection{UserID: userID}
+	version, err := s.GetActiveSnapshotVersion(ctx)
+	if err != nil {
+		return inspection, err
+	}
+	inspection.ActiveVersion = version
+
+	stored, err := s.store.FindUserRecommendation(ctx, userID, version)
+	if err != nil {
+		return inspection, err
+	}
+	inspection.Stored = stored.Products
+
+	entry, generation, err := s.userCache.GetUserRecommendation(ctx, userID)
+	if err != nil {
+		return inspection, err
+	}
+	if entry != nil {
+		inspection.Cached = &models.CachedListState{
+			Version:    entry.Version,
+			Products:   len(entry.Products),
+			FreshUntil: entry.FreshUntil,
+			Stale:      entry.Generation != generation || !time.Now().Before(entry.FreshUntil),
+		}
+	}
+
+	cursor, err := s.db.Collection("events").Find(ctx,
+		bson.M{"userId": userID},
+		options.Find().SetSort(bson.M{"timestamp": -1}).SetLimit(inspectedEvents),
+	)
+	if err != nil {
+		return inspection, err
+	}
+	inspection.RecentEvents = []models.UserActivity{}
+	if err := cursor.All(ctx, &inspection.RecentEvents); err != nil {
+		return inspection, err
+	}
+	return inspection, nil
+}
@@ -0,0 +1,263 @@
+package services
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+
+	"polyforge-recommendation/internal/models"
+)
+
+const (
+	eventImportBatchSize = 1000
+	// eventImportMaxRejections caps the rejected rows kept in a report.
+	eventImportMaxRejections = 100
+)
+
+// eventTypes are the event types the service scores.
+var eventTypes = map[string]bool{"VIEW": true, "CART_ADD": true, "PURCHASE": true}
+
+// importedEvent is a row of an event file.
+type importedEvent struct {
+	EventID   string `json:"eventId"`
+	UserID    string `json:"userId"`
+	ProductID string `json:"productId"`
+	EventType string `json:"eventType"`
+	Segment   string `json:"segment"`
+	Timestamp string `json:"timestamp"`
+}
+
+func (e importedEvent) activity() (models.UserActivity, error) {
+	for _, field := range []struct{ name, value string }{
+		{"eventId", e.EventID},
+		{"userId", e.UserID},
+		{"productId", e.ProductID},
+		{"timestamp", e.Timestamp},
+	} {
+		if field.value == "" {
+			return models.UserActivity{}, fmt.Errorf("missing %s", field.name)
+		}
+	}
+	eventType := strings.ToUpper(e.EventType)
+	if !eventTypes[eventType] {
+		return models.UserActivity{}, fmt.Errorf("unknown eventType %q", e.EventType)
+	}
+	timestamp, err := time.Parse(time.RFC3339, e.Timestamp)
+	if err != nil {
+		return models.UserActivity{}, fmt.Errorf("timestamp must be an RFC 3339 time")
+	}
+
+	return models.UserActivity{
+		EventID:   e.EventID,
+		UserID:    e.UserID,
+		ProductID: e.ProductID,
+		EventType: eventType,
+		Segment:   e.Segment,
+		Timestamp: timestamp,
+	}, nil
+}
+
+// EventImportOptions controls an event backfill.
+type EventImportOptions struct {
+	// DryRun validates the file and counts duplicates without writing. Only
+	// repeats within a batch of the file are counted as duplicates, since
+	// nothing is stored for later batches to match.
+	DryRun bool
+	// Progress, when set, receives the report after every batch.
+	Progress func(models.EventImportReport)
+}
+
+// ImportEvents backfills historical events from NDJSON, one event per line,
+// or CSV with a header row naming the columns eventId, userId, productId,
+// eventType, timestamp and optionally segment. Events keep their original
+// timestamps and are deduplicated by event ID, both within the file and
+// against events already imported, so a file can be imported again after a
+// failure. Invalid rows are rejected and reported; a file that cannot be
+// parsed at all fails with ErrInvalidImport.
+func (s *RecommendationService) ImportEvents(ctx context.Context, r io.Reader, opts EventImportOptions) (models.EventImportReport, error) {
+	report := models.EventImportReport{DryRun: opts.DryRun}
+	reader := bufio.NewReader(r)
+
+	first, err := firstNonSpace(reader)
+	if err == io.EOF {
+		return report, nil
+	} else if err != nil {
+		return report, err
+	}
+
+	if !opts.DryRun {
+		if err := s.ensureEventIDIndex(ctx); err != nil {
+			return report, err
+		}
+	}
+
+	batch := make([]models.UserActivity, 0, eventImportBatchSize)
+	inBatch := map[string]bool{}
+	flush := func() error {
+		if err := s.writeEventBatch(ctx, batch, &report, opts.DryRun); err != nil {
+			return err
+		}
+		batch = batch[:0]
+		clear(inBatch)
+		if opts.Progress != nil {
+			opts.Progress(report)
+		}
+		return nil
+	}
+	add := func(line int, event importedEvent) error {
+		report.Read++
+		activity, err := event.activity()
+		if err != nil {
+			rejectEvent(&report, line, err.Error())
+			return nil
+		}
+		if inBatch[activity.EventID] {
+			report.Duplicates++
+			return nil
+		}
+		inBatch[activity.EventID] = true
+		batch = append(batch, activity)
+		if len(batch) == eventImportBatchSize {
+			return flush()
+		}
+		return nil
+	}
+
+	if first == '{' {
+		err = readEventsNDJSON(reader, &report, add)
+	} else {
+		err = readEventsCSV(reader, add)
+	}
+	if err != nil {
+		return report, malformedImport(err)
+	}
+
+	if len(batch) > 0 {
+		if err := flush(); err != nil {
+			return report, err
+		}
+	}
+	return report, nil
+}
+
+func readEventsNDJSON(reader io.Reader, report *models.EventImportReport, add func(int, importedEvent) error) error {
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 64*1024), 4*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		raw := bytes.TrimSpace(scanner.Bytes())
+		if len(raw) == 0 {
+			continue
+		}
+		var event importedEvent
+		if err := json.Unmarshal(raw, &event); err != nil {
+			report.Read++
+			rejectEvent(report, line, err.Error())
+			continue
+		}
+		if err := add(line, event); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+func readEventsCSV(reader io.Reader, add func(int, importedEvent) error) error {
+	rows := csv.NewReader(reader)
+	rows.FieldsPerRecord = -1
+	header, err := rows.Read()
+	if err != nil {
+		return fmt.Errorf("reading CSV header: %w", err)
+	}
+	columns := map[string]int{}
+	for i, name := range header {
+		columns[strings.TrimSpace(name)] = i
+	}
+	field := func(row []string, name string) string {
+		if i, ok := columns[name]; ok && i < len(row) {
+			return strings.TrimSpace(row[i])
+		}
+		return ""
+	}
+
+	for {
+		row, err := rows.Read()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		line, _ := rows.FieldPos(0)
+		event := importedEvent{
+			EventID:   field(row, "eventId"),
+			UserID:    field(row, "userId"),
+			ProductID: field(row, "productId"),
+			EventType: field(row, "eventType"),
+			Segment:   field(row, "segment"),
+			Timestamp: field(row, "timestamp"),
+		}
+		if err := add(line, event); err != nil {
+			return err
+		}
+	}
+}
+
+func rejectEvent(report *models.EventImportReport, line int, reason string) {
+	report.Rejected++
+	if len(report.Rejections) < eventImportMaxRejections {
+		report.Rejections = append(report.Rejections, models.EventRejection{Line: line, Reason: reason})
+	}
+}
+
+// ensureEventIDIndex makes event IDs unique among imported events; events
+// recorded live have none. The index is created by the first import.
+func (s *RecommendationService) ensureEventIDIndex(ctx context.Context) error {
+	return s.eventIDIndex.ensure(ctx, s.db.Collection("events"), mongo.IndexModel{
+		Keys: bson.D{{Key: "eventId", Value: 1}},
+		Options: options.Index().
+			SetUnique(true).
+			SetPartialFilterExpression(bson.M{"eventId": bson.M{"$exists": true}}),
+	})
+}
+
+// writeEventBatch inserts the events whose IDs are not stored yet. A dry run
+// only counts them.
+func (s *RecommendationService) writeEventBatch(ctx context.Context, batch []models.UserActivity, report *models.EventImportReport, dryRun bool) error {
+	if dryRun {
+		ids := make([]string, len(batch))
+		for i, event := range batch {
+			ids[i] = event.EventID
+		}
+		existing, err := s.db.Collection("events").CountDocuments(ctx, bson.M{"eventId": bson.M{"$in": ids}})
+		if err != nil {
+			return err
+		}
+		report.Duplicates += int(existing)
+		report.Imported += len(batch) - int(existing)
+		return nil
+	}
+
+	writes := make([]mongo.WriteModel, len(batch))
+	for i, event := range batch {
+		writes[i] = mongo.NewUpdateOneModel().
+			SetFilter(bson.M{"eventId": event.EventID}).
+			SetUpdate(bson.M{"$setOnInsert": event}).
+			SetUpsert(true)
+	}
+	result, err := s.db.Collection("events").BulkWrite(ctx, writes, options.BulkWrite().SetOrdered(false))
+	if err != nil {
+		return err
+	}
+	report.Imported += int(result.UpsertedCount)
+	report.Duplicates += int(result.MatchedCount)
+	return nil
+}
@@ -0,0 +1,308 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+	"strconv"
+
+	"polyforge-recommendation/internal/config"
+	"polyforge-recommendation/internal/models"
+)
+
+// sourceExploration labels catalog products a bandit added to a list.
+const sourceExploration = "exploration"
+
+// banditArm is a product a bandit can put in a slot, with what the
+// placement has observed of it so far.
+type banditArm struct {
+	product     models.ProductRecommendation
+	impressions int64
+	clicks      int64
+}
+
+// banditKey holds state rather than cached values, so it is not versioned
+// with the cache keys. Counters of a placement are shared by all replicas.
+func (s *RecommendationService) banditKey(placement, counter string) string {
+	return fmt.Sprintf("%s:bandit:%s:%s", s.cfg.Cache.Prefix, placement, counter)
+}
+
+func (s *RecommendationService) banditPlacement(placement string) bool {
+	return s.cfg.Bandit.Method != "" && containsFold(s.cfg.Bandit.Placements, placement)
+}
+
+// exploration carries the products a bandit put on the first page of a list
+// to its later pages. The picked products lead the first page, so later
+// pages are cut from the list without them; otherwise they would show again
+// further down and the products they pushed off the first page would never
+// be served.
+type exploration struct {
+	later  bool
+	picked []string
+}
+
+// explorationFrom returns the exploration of the page the cursor points to.
+func explorationFrom(cursor *PageCursor) *exploration {
+	if cursor == nil {
+		return &exploration{}
+	}
+	return &exploration{later: true, picked: cursor.Explored}
+}
+
+// nextCursor records the exploration in the cursor of the page after page.
+// After a first page the picked products it showed are recorded and the
+// offset no longer counts them, matching the list later pages are cut from.
+func (e *exploration) nextCursor(next *PageCursor, page []models.ProductRecommendation) *PageCursor {
+	if e.later {
+		next.Explored = e.picked
+		return next
+	}
+	picked := make(map[string]bool, len(e.picked))
+	for _, productID := range e.picked {
+		picked[productID] = true
+	}
+	for _, product := range page {
+		if picked[product.ProductID] {
+			next.Explored = append(next.Explored, product.ProductID)
+		}
+	}
+	next.Offset -= len(next.Explored)
+	return next
+}
+
+// banditStage fills the first slots of a bandit placement's list. Arms are
+// the head of the list and the newest catalog products, which would
+// otherwise never be shown; new products must pass the candidate stages.
+// Only first pages are explored; later pages leave out what was picked.
+func (s *RecommendationService) banditStage(placement string, query RecommendationQuery, explored *exploration, candidateStages []ListStage) ListStage {
+	return func(ctx context.Context, products []models.ProductRecommendation) ([]models.ProductRecommendation, error) {
+		if explored.later {
+			return excludeStage(explored.picked)(ctx, products)
+		}
+		if !s.banditPlacement(placement) {
+			return products, nil
+		}
+		slots := s.cfg.Bandit.Slots
+		if query.Limit > 0 && query.Limit < slots {
+			slots = query.Limit
+		}
+		if slots <= 0 {
+			return products, nil
+		}
+
+		arms, err := s.banditArms(ctx, placement, products, candidateStages)
+		if err != nil {
+			// exploration is optional; the ranked list still serves
+			fmt.Printf("Error loading %s bandit arms: %v\n", placement, err)
+			return products, nil
+		}
+
+		var picked []models.ProductRecommendation
+		if s.cfg.Bandit.Method == config.BanditMethodEpsilonGreedy {
+			picked = pickEpsilonGreedy(arms, slots, s.cfg.Bandit.Epsilon)
+		} else {
+			picked = pickThompson(arms, slots)
+		}
+		s.recordBanditImpressions(placement, picked)
+		explored.picked = productIDs(picked)
+
+		result := make([]models.ProductRecommendation, 0, len(products)+len(picked))
+		result = append(result, picked...)
+		for _, product := range products {
+			if !containsProduct(picked, product.ProductID) {
+				result = append(result, product)
+			}
+		}
+		return result, nil
+	}
+}
+
+// banditArms returns the arms of a placement with their counters.
+func (s *RecommendationService) banditArms(ctx context.Context, placement string, products []models.ProductRecommendation, candidateStages []ListStage) ([]banditArm, error) {
+	head := products
+	if len(head) > s.cfg.Bandit.Arms {
+		head = head[:s.cfg.Bandit.Arms]
+	}
+
+	listed := make(map[string]bool, len(products))
+	for _, product := range products {
+		listed[product.ProductID] = true
+	}
+	newest, err := s.products.NewestProducts(ctx, s.cfg.Bandit.NewProducts)
+	if err != nil {
+		return nil, err
+	}
+	var candidates []models.ProductRecommendation
+	for _, productID := range newest {
+		if !listed[productID] {
+			candidates = append(candidates, models.ProductRecommendation{ProductID: productID, Source: sourceExploration})
+		}
+	}
+	if candidates, err = applyStages(ctx, candidates, candidateStages); err != nil {
+		return nil, err
+	}
+
+	arms := make([]banditArm, 0, len(head)+len(candidates))
+	for _, product := range append(append([]models.ProductRecommendation{}, head...), candidates...) {
+		arms = append(arms, banditArm{product: product})
+	}
+	if len(arms) == 0 {
+		return arms, nil
+	}
+
+	ids := make([]string, len(arms))
+	for i, arm := range arms {
+		ids[i] = arm.product.ProductID
+	}
+	impressions, err := s.cache.HMGet(ctx, s.banditKey(placement, "impressions"), ids...).Result()
+	if err != nil {
+		return nil, err
+	}
+	clicks, err := s.cache.HMGet(ctx, s.banditKey(placement, "clicks"), ids...).Result()
+	if err != nil {
+		return nil, err
+	}
+	for i := range arms {
+		arms[i].impressions = parseCounter(impressions[i])
+		arms[i].clicks = parseCounter(clicks[i])
+	}
+	return arms, nil
+}
+
+func parseCounter(value interface{}) int64 {
+	raw, ok := value.(string)
+	if !ok {
+		return 0
+	}
+	count, _ := strconv.ParseInt(raw, 10, 64)
+	return count
+}
+
+// pickThompson samples a click rate for every arm from its Beta posterior
+// and fills the slots with the highest samples, so arms are explored in
+// proportion to how likely they are to be the best.
+func pickThompson(arms []banditArm, slots int) []models.ProductRecommendation {
+	samples := make([]float64, len(arms))
+	for i, arm := range arms {
+		failures := arm.impressions - arm.clicks
+		if failures < 0 {
+			failures = 0
+		}
+		samples[i] = sampleBeta(float64(arm.clicks+1), float64(failures+1))
+	}
+
+	order := make([]int, len(arms))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool { return samples[order[i]] > samples[order[j]] })
+
+	picked := make([]models.ProductRecommendation, 0, slots)
+	for _, i := range order {
+		if len(picked) == slots {
+			break
+		}
+		picked = append(picked, arms[i].product)
+	}
+	return picked
+}
+
+// pickEpsilonGreedy fills each slot with a random arm with probability
+// epsilon and with the arm of the best observed click rate otherwise; arms
+// never shown rank by their position in the list.
+func pickEpsilonGreedy(arms []banditArm, slots int, epsilon float64) []models.ProductRecommendation {
+	remaining := append([]banditArm{}, arms...)
+	sort.SliceStable(remaining, func(i, j int) bool { return clickRate(remaining[i]) > clickRate(remaining[j]) })
+
+	picked := make([]models.ProductRecommendation, 0, slots)
+	for len(picked) < slots && len(remaining) > 0 {
+		pick := 0
+		if rand.Float64() < epsilon {
+			pick = rand.Intn(len(remaining))
+		}
+		picked = append(picked, remaining[pick].product)
+		remaining = append(remaining[:pick], remaining[pick+1:]...)
+	}
+	return picked
+}
+
+func clickRate(arm banditArm) float64 {
+	if arm.impressions == 0 {
+		return 0
+	}
+	return float64(arm.clicks) / float64(arm.impressions)
+}
+
+// sampleBeta draws from Beta(a, b) as the ratio of two Gamma draws.
+func sampleBeta(a, b float64) float64 {
+	x := sampleGamma(a)
+	y := sampleGamma(b)
+	if x+y == 0 {
+		return 0
+	}
+	return x / (x + y)
+}
+
+// sampleGamma draws from Gamma(shape, 1) with the Marsaglia-Tsang method;
+// shapes here are always at least 1.
+func sampleGamma(shape float64) float64 {
+	d := shape - 1.0/3
+	c := 1 / math.Sqrt(9*d)
+	for {
+		x := rand.NormFloat64()
+		v := 1 + c*x
+		if v <= 0 {
+			continue
+		}
+		v = v * v * v
+		u := rand.Float64()
+		if math.Log(u) < 0.5*x*x+d-d*v+d*math.Log(v) {
+			return d * v
+		}
+	}
+}
+
+// recordBanditImpressions counts the picked arms as shown, in the
+// background so that serving the list does not wait on it.
+func (s *RecommendationService) recordBanditImpressions(placement string, products []models.ProductRecommendation) {
+	if len(products) == 0 {
+		return
+	}
+
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), refreshTimeout)
+		defer cancel()
+
+		key := s.banditKey(placement, "impressions")
+		pipe := s.cache.Pipeline()
+		for _, product := range products {
+			pipe.HIncrBy(ctx, key, product.ProductID, 1)
+		}
+		if _, err := pipe.Exec(ctx); err != nil {
+			fmt.Printf("Error recording %s bandit impressions: %v\n", placement, err)
+		}
+	}()
+}
+
+// recordBanditClick counts a view of a product recommended on a bandit
+// placement as a click on its arm.
+func (s *RecommendationService) recordBanditClick(ctx context.Context, activity models.UserActivity) {
+	if activity.EventType != "VIEW" || !s.banditPlacement(activity.Placement) {
+		return
+	}
+
+	if err := s.cache.HIncrBy(ctx, s.banditKey(activity.Placement, "clicks"), activity.ProductID, 1).Err(); err != nil {
+		fmt.Printf("Error recording %s bandit click: %v\n", activity.Placement, err)
+	}
+}
+
+func containsProduct(products []models.ProductRecommendation, productID string) bool {
+	for _, product := range products {
+		if product.ProductID == productID {
+			return true
+		}
+	}
+	return false
+}
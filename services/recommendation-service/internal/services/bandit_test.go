@@ -0,0 +1,60 @@
+package services
+
+import (
+	"context"
+	"testing"
+
+	"polyforge-recommendation/internal/models"
+)
+
+func TestLaterPagesLeaveOutExploredProducts(t *testing.T) {
+	s := &RecommendationService{}
+	list := productList(10)
+
+	// the bandit put p7 and the new product x at the top of the first page
+	explored := explorationFrom(nil)
+	explored.picked = []string{"p7", "x"}
+	first := append([]models.ProductRecommendation{{ProductID: "p7"}, {ProductID: "x"}}, list[:7]...)
+	first = append(first, list[8:]...)
+
+	page, nextOffset := paginate(first, 0, 4)
+	cursor := explored.nextCursor(&PageCursor{Kind: cursorKindTrending, Pin: "t1", Offset: nextOffset}, page)
+	if cursor.Offset != 2 || len(cursor.Explored) != 2 {
+		t.Fatalf("unexpected cursor after the first page: %+v", cursor)
+	}
+
+	served := map[string]int{}
+	for _, product := range page {
+		served[product.ProductID]++
+	}
+	for cursor != nil {
+		decoded, err := DecodeCursor(EncodeCursor(cursor), cursorKindTrending)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		explored := explorationFrom(decoded)
+		products, err := s.banditStage(PlacementTrending, RecommendationQuery{}, explored, nil)(context.Background(), list)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		page, nextOffset = paginate(products, decoded.Offset, 4)
+		for _, product := range page {
+			served[product.ProductID]++
+		}
+		cursor = nil
+		if nextOffset >= 0 {
+			cursor = explored.nextCursor(&PageCursor{Kind: cursorKindTrending, Pin: "t1", Offset: nextOffset}, page)
+		}
+	}
+
+	// every listed product and the explored one are served exactly once
+	if len(served) != 11 {
+		t.Errorf("expected 11 distinct products over all pages, got %d", len(served))
+	}
+	for productID, count := range served {
+		if count != 1 {
+			t.Errorf("product %s served %d times", productID, count)
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package services
+
+import (
+	"context"
+	"fmt"
+
+	"polyforge-recommendation/internal/models"
+)
+
+// batchChunkSize is how many users a batch lookup reads per round trip to
+// Redis and Mongo.
+const batchChunkSize = 500
+
+// StreamUserRecommendations passes the first limit products of every user's
+// stored list to write, in the order of userIDs. Out-of-stock products are
+// handled as on personal lists and the personal placement's block, boost and
+// bury rules apply, so bulk readers never see a blocked product. Each chunk
+// of users costs one Redis MGET, one Mongo query for the cache misses and
+// one for the catalog data of their products. Lists are otherwise served as
+// stored, without blending, fallback, diversity, exploration or pins, and
+// misses are not cached so that bulk reads do not flood the cache. Writing
+// stops at the first error.
+func (s *RecommendationService) StreamUserRecommendations(ctx context.Context, userIDs []string, limit int, write func(models.UserRecommendation) error) error {
+	version, err := s.store.ActiveSnapshotVersion(ctx)
+	if err != nil {
+		return err
+	}
+	rules, err := s.rules.activeRules(ctx, PlacementPersonal)
+	if err != nil {
+		return err
+	}
+
+	for start := 0; start < len(userIDs); start += batchChunkSize {
+		end := min(start+batchChunkSize, len(userIDs))
+		lists, err := s.readUserChunk(ctx, userIDs[start:end], version)
+		if err != nil {
+			return err
+		}
+
+		var ids []string
+		for _, list := range lists {
+			ids = append(ids, productIDs(list.Products)...)
+		}
+		catalog, err := s.products.GetProducts(ctx, ids)
+		if err != nil {
+			return err
+		}
+		unavailable := outOfStock(catalog)
+
+		for _, list := range lists {
+			products := rankProducts(rules, catalog, s.products.applyAvailability(list.Products, unavailable), nil)
+			list.Products, _ = paginate(products, 0, limit)
+			if err := write(list); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// readUserChunk returns the full lists of the given users in the given
+// snapshot, from the cache where it holds that snapshot's list and from the
+// snapshot for the others.
+func (s *RecommendationService) readUserChunk(ctx context.Context, userIDs []string, version int) ([]models.UserRecommendation, error) {
+	cached, err := s.userCache.GetUserRecommendations(ctx, userIDs)
+	if err != nil {
+		// the store can serve the whole chunk
+		fmt.Printf("Error reading cached recommendations: %v\n", err)
+		cached = map[string]*CachedUserRecommendation{}
+	}
+
+	var misses []string
+	for _, userID := range userIDs {
+		// entries of an older snapshot wait for their refresh; bulk reads
+		// serve the active one
+		if entry := cached[userID]; entry == nil || entry.Version != version {
+			delete(cached, userID)
+			misses = append(misses, userID)
+		}
+	}
+	stored, err := s.store.FindUserRecommendations(ctx, misses, version)
+	if err != nil {
+		return nil, err
+	}
+
+	lists := make([]models.UserRecommendation, len(userIDs))
+	for i, userID := range userIDs {
+		if entry := cached[userID]; entry != nil {
+			lists[i] = models.UserRecommendation{UserID: userID, Version: entry.Version, Products: entry.Products}
+		} else if list, ok := stored[userID]; ok {
+			lists[i] = list
+		} else {
+			lists[i] = models.UserRecommendation{UserID: userID, Version: version, Products: []models.ProductRecommendation{}}
+		}
+	}
+	return lists, nil
+}
@@ -0,0 +1,38 @@
+package services
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"polyforge-recommendation/internal/models"
+)
+
+func TestStreamUserRecommendationsAppliesRulesAndAvailability(t *testing.T) {
+	store := &fakeStore{version: 2, lists: map[string][]models.ProductRecommendation{"u1": productList(5), "u2": productList(3)}}
+	cache := newFakeCache()
+	// u2's cached list is of another snapshot and read from the store
+	cache.entries["u2"] = &CachedUserRecommendation{UserID: "u2", Version: 1, Products: productList(1)}
+	s := newTestService(store, cache)
+	s.products = fakeProducts(s.cfg, models.Product{ProductID: "p2", Available: stock(0)})
+	s.rules = fakeRules(ruleFor(models.RuleActionBlock, "p1"), ruleFor(models.RuleActionBury, "p0"))
+
+	streamed := map[string][]string{}
+	err := s.StreamUserRecommendations(context.Background(), []string{"u1", "u2", "u3"}, 3, func(list models.UserRecommendation) error {
+		streamed[list.UserID] = listedIDs(list.Products)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// p1 is blocked, p2 out of stock and p0 buried
+	want := map[string][]string{
+		"u1": {"p3", "p4", "p0"},
+		"u2": {"p0"},
+		"u3": {},
+	}
+	if !reflect.DeepEqual(streamed, want) {
+		t.Errorf("expected %v, got %v", want, streamed)
+	}
+}
@@ -0,0 +1,257 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"sort"
+	"strconv"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+
+	"polyforge-recommendation/internal/config"
+	"polyforge-recommendation/internal/models"
+)
+
+// Candidate sources of a personal list.
+const (
+	sourcePersonal = "personal"
+	sourceItemItem = "item_item"
+	sourceContent  = "content"
+	sourceTrending = "trending"
+)
+
+// isBlendSource reports whether a blend weight names a candidate source.
+func isBlendSource(name string) bool {
+	switch name {
+	case sourcePersonal, sourceItemItem, sourceContent, sourceTrending:
+		return true
+	}
+	return false
+}
+
+// candidateSource is a ranked list of candidates from one generator.
+type candidateSource struct {
+	name     string
+	weight   float64
+	products []models.ProductRecommendation
+}
+
+// blendedList returns the user's stored list merged with the candidates of
+// the other sources that have a positive weight. Blends are cached per user
+// and blend configuration and only recomputed once they expire or the stored
+// list changes, so reads do not query every source each time.
+func (s *RecommendationService) blendedList(ctx context.Context, stored models.UserRecommendation, blend config.BlendConfig) (*cachedBlend, error) {
+	key := s.blendKey(stored.UserID, blend)
+	basis := listFingerprint(stored)
+	cached, err := s.readBlendKey(ctx, key)
+	if err != nil {
+		fmt.Printf("Error reading cached blended recommendations: %v\n", err)
+	}
+	if cached != nil && cached.Basis == basis {
+		return cached, nil
+	}
+
+	result, err, _ := s.group.Do(key+":"+basis, func() (interface{}, error) {
+		ctx, cancel := sharedLoadContext(ctx)
+		defer cancel()
+
+		products, complete := s.blendSources(ctx, stored.UserID, stored.Products, blend)
+		blended := &cachedBlend{
+			ID:       bson.NewObjectID().Hex(),
+			UserID:   stored.UserID,
+			Version:  stored.Version,
+			Basis:    basis,
+			Products: products,
+		}
+		// a blend missing a failed source is still pinned for its cursors but
+		// not reused by later reads
+		if err := s.cacheBlend(ctx, key, blended, complete); err != nil {
+			fmt.Printf("Error caching blended recommendations: %v\n", err)
+		}
+		return blended, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result.(*cachedBlend), nil
+}
+
+// blendSources merges the stored products with the candidates of the other
+// sources. Every product appears once and is labelled with the source that
+// contributed most to its rank. A failing source is left out rather than
+// failing the list, and reported by complete being false.
+func (s *RecommendationService) blendSources(ctx context.Context, userID string, products []models.ProductRecommendation, blend config.BlendConfig) ([]models.ProductRecommendation, bool) {
+	complete := true
+	var sources []candidateSource
+	for _, name := range blendSourceNames(blend.Weights) {
+		candidates := products
+		if name != sourcePersonal {
+			var err error
+			if candidates, err = s.sourceCandidates(ctx, name, userID, blend.CandidateLimit); err != nil {
+				fmt.Printf("Error reading %s candidates for user %s: %v\n", name, userID, err)
+				complete = false
+				continue
+			}
+		}
+		sources = append(sources, candidateSource{name: name, weight: blend.Weights[name], products: candidates})
+	}
+
+	if blend.Mode == config.BlendModeInterleave {
+		return interleaveSources(sources), complete
+	}
+	return weightSources(sources), complete
+}
+
+// blendKeyHash identifies a blend configuration in cache keys.
+func blendKeyHash(blend config.BlendConfig) string {
+	hash := fnv.New64a()
+	fmt.Fprintf(hash, "%s|%d", blend.Mode, blend.CandidateLimit)
+	for _, name := range blendSourceNames(blend.Weights) {
+		fmt.Fprintf(hash, "|%s:%g", name, blend.Weights[name])
+	}
+	return strconv.FormatUint(hash.Sum64(), 16)
+}
+
+// listFingerprint identifies a stored list by its snapshot and contents, so
+// that a user rescored into the same snapshot gets a new blend.
+func listFingerprint(list models.UserRecommendation) string {
+	hash := fnv.New64a()
+	fmt.Fprintf(hash, "%d", list.Version)
+	for _, product := range list.Products {
+		fmt.Fprintf(hash, "|%s:%g", product.ProductID, product.Score)
+	}
+	return strconv.FormatUint(hash.Sum64(), 16)
+}
+
+// blendSourceNames returns the sources with a positive weight, heaviest
+// first.
+func blendSourceNames(weights map[string]float64) []string {
+	var names []string
+	for name, weight := range weights {
+		if weight > 0 {
+			names = append(names, name)
+		}
+	}
+	sort.Slice(names, func(i, j int) bool {
+		wi, wj := weights[names[i]], weights[names[j]]
+		if wi != wj {
+			return wi > wj
+		}
+		return names[i] < names[j]
+	})
+	return names
+}
+
+func (s *RecommendationService) sourceCandidates(ctx context.Context, name, userID string, limit int) ([]models.ProductRecommendation, error) {
+	switch name {
+	case sourceItemItem:
+		return s.itemItemProducts(ctx, userID, limit)
+	case sourceContent:
+		return s.content.userProducts(ctx, userID, limit)
+	case sourceTrending:
+		trending, err := s.getTrending(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if len(trending.Products) > limit {
+			return trending.Products[:limit], nil
+		}
+		return trending.Products, nil
+	default:
+		return nil, fmt.Errorf("unknown candidate source %q", name)
+	}
+}
+
+// weightSources scores every product with the weighted sum of its scores in
+// each source, normalised by the source's best score so that sources on
+// different scales are comparable.
+func weightSources(sources []candidateSource) []models.ProductRecommendation {
+	type blended struct {
+		product      models.ProductRecommendation
+		score        float64
+		contribution float64
+	}
+
+	entries := map[string]*blended{}
+	var order []string
+	for _, source := range sources {
+		var best float64
+		for _, product := range source.products {
+			best = math.Max(best, product.Score)
+		}
+
+		for _, product := range source.products {
+			normalised := 1.0
+			if best > 0 {
+				normalised = product.Score / best
+			}
+			contribution := source.weight * normalised
+
+			entry, ok := entries[product.ProductID]
+			if !ok {
+				entry = &blended{product: product}
+				entries[product.ProductID] = entry
+				order = append(order, product.ProductID)
+			}
+			entry.score += contribution
+			if !ok || contribution > entry.contribution {
+				entry.contribution = contribution
+				entry.product.Source = source.name
+			}
+		}
+	}
+
+	// a stable sort keeps ties in the order the heavier sources listed them
+	sort.SliceStable(order, func(i, j int) bool {
+		return entries[order[i]].score > entries[order[j]].score
+	})
+
+	merged := make([]models.ProductRecommendation, len(order))
+	for i, productID := range order {
+		merged[i] = entries[productID].product
+		merged[i].Score = math.Round(entries[productID].score*1000) / 1000
+	}
+	return merged
+}
+
+// interleaveSources takes products from the sources in turn, each source
+// getting turns in proportion to its weight, and keeps the source's order
+// and score.
+func interleaveSources(sources []candidateSource) []models.ProductRecommendation {
+	total := 0
+	for _, source := range sources {
+		total += len(source.products)
+	}
+
+	merged := make([]models.ProductRecommendation, 0, total)
+	listed := make(map[string]bool, total)
+	positions := make([]int, len(sources))
+	taken := make([]float64, len(sources))
+	for {
+		// the source furthest behind its share goes next
+		next := -1
+		for i, source := range sources {
+			if positions[i] >= len(source.products) {
+				continue
+			}
+			if next < 0 || taken[i]/source.weight < taken[next]/sources[next].weight {
+				next = i
+			}
+		}
+		if next < 0 {
+			return merged
+		}
+
+		product := sources[next].products[positions[next]]
+		positions[next]++
+		if listed[product.ProductID] {
+			continue
+		}
+		listed[product.ProductID] = true
+		taken[next]++
+		product.Source = sources[next].name
+		merged = append(merged, product)
+	}
+}
@@ -0,0 +1,396 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"go.mongodb.org/mongo-driver/v2/bson"
+
+	"polyforge-recommendation/internal/config"
+	"polyforge-recommendation/internal/localcache"
+	"polyforge-recommendation/internal/models"
+)
+
+// redisRecommendationCache keeps user lists in Redis, optionally behind the
+// in-process tier.
+type redisRecommendationCache struct {
+	client *redis.Client
+	cfg    config.CacheConfig
+	local  *localcache.LRU
+}
+
+// GetUserRecommendation returns the cached entry for a user together with the
+// current cache generation, fetched from Redis in a single round trip.
+func (c *redisRecommendationCache) GetUserRecommendation(ctx context.Context, userID string) (*CachedUserRecommendation, int64, error) {
+	key := c.cfg.UserRecommendationKey(userID)
+
+	// the local tier is purged whenever the generation changes, so its
+	// entries are always of the current generation
+	if c.local != nil {
+		if value, ok := c.local.Get(key); ok {
+			entry := value.(*CachedUserRecommendation)
+			return entry, entry.Generation, nil
+		}
+	}
+
+	values, err := c.client.MGet(ctx, key, c.cfg.UserGenerationKey()).Result()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var generation int64
+	if raw, ok := values[1].(string); ok {
+		fmt.Sscan(raw, &generation)
+	}
+
+	raw, ok := values[0].(string)
+	if !ok {
+		return nil, generation, nil
+	}
+
+	var entry CachedUserRecommendation
+	if err := json.Unmarshal([]byte(raw), &entry); err != nil {
+		fmt.Printf("Error unmarshaling cached recommendations: %v\n", err)
+		return nil, generation, nil
+	}
+
+	if c.local != nil {
+		c.local.Set(key, &entry)
+	}
+	return &entry, generation, nil
+}
+
+// GetUserRecommendations returns the cached entries of many users, whatever
+// their generation or snapshot version, in a single round trip; callers
+// decide which entries are current. Users without an entry are left out.
+// The local tier is bypassed so bulk reads do not evict hot entries.
+func (c *redisRecommendationCache) GetUserRecommendations(ctx context.Context, userIDs []string) (map[string]*CachedUserRecommendation, error) {
+	entries := make(map[string]*CachedUserRecommendation, len(userIDs))
+	if len(userIDs) == 0 {
+		return entries, nil
+	}
+
+	keys := make([]string, len(userIDs))
+	for i, userID := range userIDs {
+		keys[i] = c.cfg.UserRecommendationKey(userID)
+	}
+	values, err := c.client.MGet(ctx, keys...).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	for i, value := range values {
+		raw, ok := value.(string)
+		if !ok {
+			continue
+		}
+		var entry CachedUserRecommendation
+		if err := json.Unmarshal([]byte(raw), &entry); err != nil {
+			fmt.Printf("Error unmarshaling cached recommendations: %v\n", err)
+			continue
+		}
+		entries[userIDs[i]] = &entry
+	}
+	return entries, nil
+}
+
+func (c *redisRecommendationCache) SetUserRecommendation(ctx context.Context, entry *CachedUserRecommendation) error {
+	entry.FreshUntil = time.Now().Add(c.cfg.UserTTL)
+	jsonData, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	key := c.cfg.UserRecommendationKey(entry.UserID)
+	if err := c.client.Set(ctx, key, string(jsonData), c.cfg.UserTTL+c.cfg.UserStaleTTL).Err(); err != nil {
+		return err
+	}
+
+	if c.local != nil {
+		c.local.Set(key, entry)
+	}
+	return nil
+}
+
+// listCache holds computed lists, such as blends and trending lists, as
+// JSON under their keys.
+type listCache interface {
+	// getList returns the value stored under key, nil when there is none.
+	getList(ctx context.Context, key string) ([]byte, error)
+	// setLists stores the values in one transaction.
+	setLists(ctx context.Context, lists ...listEntry) error
+}
+
+type listEntry struct {
+	key   string
+	value []byte
+	ttl   time.Duration
+}
+
+type redisListCache struct {
+	client *redis.Client
+}
+
+func (c *redisListCache) getList(ctx context.Context, key string) ([]byte, error) {
+	raw, err := c.client.Get(ctx, key).Bytes()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	return raw, err
+}
+
+func (c *redisListCache) setLists(ctx context.Context, lists ...listEntry) error {
+	_, err := c.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		for _, list := range lists {
+			pipe.Set(ctx, list.key, list.value, list.ttl)
+		}
+		return nil
+	})
+	return err
+}
+
+// invalidateUserRecommendationCache marks every cached list as stale without
+// deleting it, so reads keep being served while each key is refreshed once.
+func (s *RecommendationService) invalidateUserRecommendationCache(ctx context.Context) error {
+	if err := s.cache.Incr(ctx, s.cfg.Cache.UserGenerationKey()).Err(); err != nil {
+		return err
+	}
+	return s.publishCacheInvalidation(ctx, invalidateAll)
+}
+
+func (s *RecommendationService) clearUserRecommendationCache(ctx context.Context) error {
+	if err := s.deleteKeys(ctx, s.cfg.Cache.UserRecommendationKey("*")); err != nil {
+		return err
+	}
+	// current blends are keyed by user and configuration; pinned ones, keyed
+	// by ID alone, stay so open cursors keep working
+	if err := s.deleteKeys(ctx, s.cfg.Cache.Key("blended_recommendations:*:*")); err != nil {
+		return err
+	}
+	return s.publishCacheInvalidation(ctx, invalidateAll)
+}
+
+// deleteKeys deletes every key matching the pattern.
+func (s *RecommendationService) deleteKeys(ctx context.Context, pattern string) error {
+	var cursor uint64
+	for {
+		keys, nextCursor, err := s.cache.Scan(ctx, cursor, pattern, 100).Result()
+		if err != nil {
+			return err
+		}
+
+		if len(keys) > 0 {
+			if err := s.cache.Del(ctx, keys...).Err(); err != nil {
+				return err
+			}
+		}
+
+		cursor = nextCursor
+		if cursor == 0 {
+			return nil
+		}
+	}
+}
+
+// cachedTrending is a computed trending list. Every computation gets its own
+// ID so paginated reads can stay on the list their first page came from.
+type cachedTrending struct {
+	ID       string                         `json:"id"`
+	Products []models.ProductRecommendation `json:"products"`
+}
+
+func (s *RecommendationService) pinnedTrendingKey(id string) string {
+	return s.cfg.Cache.Key("trending_recommendations:" + id)
+}
+
+// getCachedTrending returns the current trending list from the local tier or
+// Redis, or nil when neither holds it.
+func (s *RecommendationService) getCachedTrending(ctx context.Context) (*cachedTrending, error) {
+	return s.readTrendingKey(ctx, s.cfg.Cache.TrendingKey())
+}
+
+// getPinnedTrending returns a previously computed trending list that is kept
+// for paginated reads, or nil once it has expired.
+func (s *RecommendationService) getPinnedTrending(ctx context.Context, id string) (*cachedTrending, error) {
+	return s.readTrendingKey(ctx, s.pinnedTrendingKey(id))
+}
+
+func (s *RecommendationService) readTrendingKey(ctx context.Context, key string) (*cachedTrending, error) {
+	if s.local != nil {
+		if value, ok := s.local.Get(key); ok {
+			return value.(*cachedTrending), nil
+		}
+	}
+
+	raw, err := s.lists.getList(ctx, key)
+	if raw == nil || err != nil {
+		return nil, err
+	}
+
+	var trending cachedTrending
+	if err := json.Unmarshal(raw, &trending); err != nil {
+		fmt.Printf("Error unmarshaling cached trending recommendations: %v\n", err)
+		return nil, nil
+	}
+
+	if s.local != nil {
+		s.local.Set(key, &trending)
+	}
+	return &trending, nil
+}
+
+// cacheTrending stores the list as the current trending list and keeps a
+// pinned copy around for as long as cursors into it stay valid.
+func (s *RecommendationService) cacheTrending(ctx context.Context, trending *cachedTrending) error {
+	jsonData, err := json.Marshal(trending)
+	if err != nil {
+		return err
+	}
+
+	key := s.cfg.Cache.TrendingKey()
+	err = s.lists.setLists(ctx,
+		listEntry{key: key, value: jsonData, ttl: s.cfg.Cache.TrendingTTL},
+		listEntry{key: s.pinnedTrendingKey(trending.ID), value: jsonData, ttl: s.cfg.Cache.TrendingTTL + s.cfg.Cache.CursorTTL},
+	)
+	if err != nil {
+		return err
+	}
+
+	if s.local != nil {
+		s.local.Set(key, trending)
+	}
+	return nil
+}
+
+func (s *RecommendationService) pinnedListKey(id string) string {
+	return s.cfg.Cache.Key("pinned_recommendations:" + id)
+}
+
+// getPinnedList returns a list kept by pinList, or nil once it has expired.
+func (s *RecommendationService) getPinnedList(ctx context.Context, id string) (*cachedTrending, error) {
+	return s.readTrendingKey(ctx, s.pinnedListKey(id))
+}
+
+// pinList keeps a list computed for a first page, such as the similar or
+// co-visited products, for as long as cursors into it stay valid and
+// returns its ID.
+func (s *RecommendationService) pinList(ctx context.Context, products []models.ProductRecommendation) (string, error) {
+	pinned := &cachedTrending{ID: bson.NewObjectID().Hex(), Products: products}
+	jsonData, err := json.Marshal(pinned)
+	if err != nil {
+		return "", err
+	}
+	return pinned.ID, s.lists.setLists(ctx, listEntry{key: s.pinnedListKey(pinned.ID), value: jsonData, ttl: s.cfg.Cache.CursorTTL})
+}
+
+// pagedList returns the list a page is cut from: the one pinned for the
+// cursor, which must belong to the anchor, or, for a first page, the one
+// compute returns.
+func (s *RecommendationService) pagedList(ctx context.Context, cursor *PageCursor, anchor string, compute func(ctx context.Context) ([]models.ProductRecommendation, error)) ([]models.ProductRecommendation, error) {
+	if cursor == nil {
+		return compute(ctx)
+	}
+
+	id, ok := strings.CutPrefix(cursor.Pin, anchor+":")
+	if !ok {
+		return nil, ErrInvalidCursor
+	}
+	pinned, err := s.getPinnedList(ctx, id)
+	if err != nil {
+		return nil, err
+	} else if pinned == nil {
+		return nil, ErrCursorExpired
+	}
+	return pinned.Products, nil
+}
+
+// nextPin returns the pin of the cursor after a page of the anchor's list,
+// pinning the list of a first page so later pages are cut from it.
+func (s *RecommendationService) nextPin(ctx context.Context, cursor *PageCursor, anchor string, products []models.ProductRecommendation) string {
+	if cursor != nil {
+		return cursor.Pin
+	}
+	id, err := s.pinList(ctx, products)
+	if err != nil {
+		fmt.Printf("Error pinning recommendations of %s: %v\n", anchor, err)
+	}
+	return anchor + ":" + id
+}
+
+// cachedBlend is a computed blended list. It is cached per user and blend
+// configuration, and kept under its own ID for paginated reads.
+type cachedBlend struct {
+	ID      string `json:"id"`
+	UserID  string `json:"userId"`
+	Version int    `json:"version"`
+	// Basis fingerprints the stored list the blend was computed from.
+	Basis    string                         `json:"basis"`
+	Products []models.ProductRecommendation `json:"products"`
+}
+
+func (s *RecommendationService) blendKey(userID string, blend config.BlendConfig) string {
+	return s.cfg.Cache.Key("blended_recommendations:" + userID + ":" + blendKeyHash(blend))
+}
+
+func (s *RecommendationService) pinnedBlendKey(id string) string {
+	return s.cfg.Cache.Key("blended_recommendations:" + id)
+}
+
+// getPinnedBlend returns a previously computed blend that is kept for
+// paginated reads, or nil once it has expired.
+func (s *RecommendationService) getPinnedBlend(ctx context.Context, id string) (*cachedBlend, error) {
+	return s.readBlendKey(ctx, s.pinnedBlendKey(id))
+}
+
+func (s *RecommendationService) readBlendKey(ctx context.Context, key string) (*cachedBlend, error) {
+	if s.local != nil {
+		if value, ok := s.local.Get(key); ok {
+			return value.(*cachedBlend), nil
+		}
+	}
+
+	raw, err := s.lists.getList(ctx, key)
+	if raw == nil || err != nil {
+		return nil, err
+	}
+
+	var blended cachedBlend
+	if err := json.Unmarshal(raw, &blended); err != nil {
+		fmt.Printf("Error unmarshaling cached blended recommendations: %v\n", err)
+		return nil, nil
+	}
+
+	if s.local != nil {
+		s.local.Set(key, &blended)
+	}
+	return &blended, nil
+}
+
+// cacheBlend keeps the blend for as long as cursors into it stay valid and,
+// when reusable, stores it as the user's current blend. Local entries need
+// no invalidation: a current blend is only served for the stored list it was
+// computed from, and a pinned one never changes.
+func (s *RecommendationService) cacheBlend(ctx context.Context, key string, blended *cachedBlend, reusable bool) error {
+	jsonData, err := json.Marshal(blended)
+	if err != nil {
+		return err
+	}
+
+	ttl := s.cfg.Blend.CacheTTL
+	lists := []listEntry{{key: s.pinnedBlendKey(blended.ID), value: jsonData, ttl: ttl + s.cfg.Cache.CursorTTL}}
+	if reusable {
+		lists = append(lists, listEntry{key: key, value: jsonData, ttl: ttl})
+	}
+	if err := s.lists.setLists(ctx, lists...); err != nil {
+		return err
+	}
+
+	if s.local != nil && reusable {
+		s.local.Set(key, blended)
+	}
+	return nil
+}
@@ -0,0 +1,203 @@
+package services
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+
+	"polyforge-recommendation/internal/messaging"
+	"polyforge-recommendation/internal/models"
+)
+
+// ErrInvalidImport reports an import file that cannot be parsed.
+var ErrInvalidImport = errors.New("invalid import file")
+
+const (
+	catalogImportBatchSize = 500
+	// catalogImportMaxErrors caps the per-record errors kept in a report.
+	catalogImportMaxErrors = 20
+)
+
+// ImportCatalog bulk loads a catalog dump, either a JSON array of products or
+// NDJSON with one product per line, in batches. Records without a product ID
+// or that cannot be decoded are skipped and reported; a dump that cannot be
+// parsed at all fails with ErrInvalidImport.
+func (s *ProductService) ImportCatalog(ctx context.Context, r io.Reader) (models.CatalogImportReport, error) {
+	report := models.CatalogImportReport{}
+	reader := bufio.NewReader(r)
+
+	first, err := firstNonSpace(reader)
+	if err == io.EOF {
+		return report, nil
+	} else if err != nil {
+		return report, err
+	}
+
+	batch := make([]models.Product, 0, catalogImportBatchSize)
+	flush := func() error {
+		count, err := s.UpsertProducts(ctx, batch)
+		if err != nil {
+			return err
+		}
+		report.Imported += count
+		batch = batch[:0]
+		return nil
+	}
+	add := func(record int, raw []byte) error {
+		var product models.Product
+		if err := json.Unmarshal(raw, &product); err != nil || product.ProductID == "" {
+			skipCatalogRecord(&report, record, err)
+			return nil
+		}
+		batch = append(batch, product)
+		if len(batch) == catalogImportBatchSize {
+			return flush()
+		}
+		return nil
+	}
+
+	if first == '[' {
+		decoder := json.NewDecoder(reader)
+		// the array is read from the dump alone, so every decoding error,
+		// a truncated array included, is the dump's
+		if _, err := decoder.Token(); err != nil {
+			return report, fmt.Errorf("%w: %w", ErrInvalidImport, err)
+		}
+		for record := 1; decoder.More(); record++ {
+			var raw json.RawMessage
+			if err := decoder.Decode(&raw); err != nil {
+				return report, fmt.Errorf("%w: record %d: %w", ErrInvalidImport, record, err)
+			}
+			if err := add(record, raw); err != nil {
+				return report, err
+			}
+		}
+	} else {
+		scanner := bufio.NewScanner(reader)
+		scanner.Buffer(make([]byte, 64*1024), 4*1024*1024)
+		for line := 1; scanner.Scan(); line++ {
+			raw := bytes.TrimSpace(scanner.Bytes())
+			if len(raw) == 0 {
+				continue
+			}
+			if err := add(line, raw); err != nil {
+				return report, err
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			return report, malformedImport(err)
+		}
+	}
+
+	if len(batch) > 0 {
+		if err := flush(); err != nil {
+			return report, err
+		}
+	}
+	return report, nil
+}
+
+func skipCatalogRecord(report *models.CatalogImportReport, record int, err error) {
+	report.Skipped++
+	if len(report.Errors) >= catalogImportMaxErrors {
+		return
+	}
+
+	reason := "missing productId"
+	if err != nil {
+		reason = err.Error()
+	}
+	report.Errors = append(report.Errors, fmt.Sprintf("record %d: %s", record, reason))
+}
+
+// malformedImport marks the errors of line and CSV parsing as
+// ErrInvalidImport. Other errors, such as failed writes, are returned as
+// they are.
+func malformedImport(err error) error {
+	var syntaxErr *json.SyntaxError
+	var parseErr *csv.ParseError
+	if errors.As(err, &syntaxErr) || errors.As(err, &parseErr) || errors.Is(err, bufio.ErrTooLong) {
+		return fmt.Errorf("%w: %w", ErrInvalidImport, err)
+	}
+	return err
+}
+
+// firstNonSpace peeks at the first significant byte of the dump without
+// consuming it.
+func firstNonSpace(reader *bufio.Reader) (byte, error) {
+	for {
+		b, err := reader.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		switch b {
+		case ' ', '\t', '\r', '\n':
+			continue
+		}
+		return b, reader.UnreadByte()
+	}
+}
+
+// HandleProductChanged applies a catalog update message, which carries the
+// fields of a product of the bulk import that changed. Fields missing from
+// the message keep their stored values; a field sent as null is cleared.
+func (s *ProductService) HandleProductChanged(ctx context.Context, body []byte) error {
+	productID, update, err := productChange(body, time.Now())
+	if err != nil {
+		return fmt.Errorf("%w: product change %s", messaging.ErrMalformedMessage, body)
+	}
+
+	_, err = s.db.Collection("products").UpdateOne(ctx, bson.M{"productId": productID}, update, options.UpdateOne().SetUpsert(true))
+	return err
+}
+
+// productChange returns the product a catalog update message is about and
+// the update setting the fields the message carries.
+func productChange(body []byte, now time.Time) (string, bson.M, error) {
+	var product models.Product
+	if err := json.Unmarshal(body, &product); err != nil {
+		return "", nil, err
+	}
+	if product.ProductID == "" {
+		return "", nil, errors.New("missing productId")
+	}
+	var present map[string]json.RawMessage
+	if err := json.Unmarshal(body, &present); err != nil {
+		return "", nil, err
+	}
+
+	set := bson.M{"updatedAt": now}
+	for field, value := range catalogFields(product) {
+		if _, ok := present[field]; ok {
+			set[field] = value
+		}
+	}
+	return product.ProductID, bson.M{"$set": set, "$setOnInsert": bson.M{"createdAt": now}}, nil
+}
+
+// ExpandProducts returns a copy of the list with the catalog data of every
+// known product embedded. Unknown products are returned without it.
+func (s *ProductService) ExpandProducts(ctx context.Context, products []models.ProductRecommendation) ([]models.ProductRecommendation, error) {
+	metadata, err := s.GetProducts(ctx, productIDs(products))
+	if err != nil {
+		return nil, err
+	}
+
+	expanded := make([]models.ProductRecommendation, len(products))
+	for i, product := range products {
+		expanded[i] = product
+		if info, ok := metadata[product.ProductID]; ok {
+			expanded[i].Product = info.Summary()
+		}
+	}
+	return expanded, nil
+}
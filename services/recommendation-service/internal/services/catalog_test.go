@@ -0,0 +1,38 @@
+package services
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+func TestProductChangeSetsOnlyTheFieldsItCarries(t *testing.T) {
+	now := time.Now()
+	productID, update, err := productChange([]byte(`{"productId": "p1", "price": 12.5, "brand": null}`), now)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if productID != "p1" {
+		t.Errorf("expected product p1, got %q", productID)
+	}
+
+	// the stored name, category and the rest are left alone, the brand is
+	// cleared
+	want := bson.M{
+		"$set":         bson.M{"price": 12.5, "brand": "", "updatedAt": now},
+		"$setOnInsert": bson.M{"createdAt": now},
+	}
+	if !reflect.DeepEqual(update, want) {
+		t.Errorf("expected %v, got %v", want, update)
+	}
+}
+
+func TestProductChangeRejectsMalformedMessages(t *testing.T) {
+	for _, body := range []string{`{"name": "Shoe"}`, `{"productId": 7}`, `not json`} {
+		if _, _, err := productChange([]byte(body), time.Now()); err == nil {
+			t.Errorf("expected %s to be rejected", body)
+		}
+	}
+}
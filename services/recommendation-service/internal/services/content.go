@@ -0,0 +1,295 @@
+package services
+
+import (
+	"context"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"golang.org/x/sync/singleflight"
+
+	"polyforge-recommendation/internal/config"
+	"polyforge-recommendation/internal/models"
+)
+
+// Attribute weights of the content model. Text terms are many and noisy, so
+// a single term counts for much less than a shared category or brand.
+const (
+	categoryFeatureWeight = 3.0
+	brandFeatureWeight    = 2.0
+	tagFeatureWeight      = 1.5
+	textFeatureWeight     = 1.0
+
+	// maxSimilarProducts bounds the similar-products list before filtering
+	// and pagination.
+	maxSimilarProducts = 100
+)
+
+var contentStopWords = map[string]bool{
+	"and": true, "the": true, "for": true, "with": true, "from": true,
+	"this": true, "that": true, "your": true, "you": true, "are": true,
+	"not": true, "all": true, "its": true, "our": true, "into": true,
+}
+
+// featureVector is an L2-normalised sparse TF-IDF vector.
+type featureVector map[string]float64
+
+func (v featureVector) dot(other featureVector) float64 {
+	if len(other) < len(v) {
+		v, other = other, v
+	}
+	var sum float64
+	for feature, weight := range v {
+		sum += weight * other[feature]
+	}
+	return sum
+}
+
+func (v featureVector) normalize() featureVector {
+	var norm float64
+	for _, weight := range v {
+		norm += weight * weight
+	}
+	if norm == 0 {
+		return v
+	}
+	norm = math.Sqrt(norm)
+	for feature := range v {
+		v[feature] /= norm
+	}
+	return v
+}
+
+// contentModel holds a vector for every product with catalog data. Products
+// are similar when they share categories, brands, tags and description terms,
+// with rare features weighing more than common ones.
+type contentModel struct {
+	vectors map[string]featureVector
+	builtAt time.Time
+}
+
+func productFeatures(product models.Product) map[string]float64 {
+	features := map[string]float64{}
+	if product.Category != "" {
+		features["category:"+strings.ToLower(product.Category)] = categoryFeatureWeight
+	}
+	if product.Brand != "" {
+		features["brand:"+strings.ToLower(product.Brand)] = brandFeatureWeight
+	}
+	for _, tag := range product.Tags {
+		if tag = strings.ToLower(strings.TrimSpace(tag)); tag != "" {
+			features["tag:"+tag] = tagFeatureWeight
+		}
+	}
+	for _, term := range contentTerms(product.Name + " " + product.Description) {
+		features["term:"+term] += textFeatureWeight
+	}
+	return features
+}
+
+func contentTerms(text string) []string {
+	fields := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+
+	terms := fields[:0]
+	for _, field := range fields {
+		if len([]rune(field)) >= 3 && !contentStopWords[field] {
+			terms = append(terms, field)
+		}
+	}
+	return terms
+}
+
+func buildContentModel(products []models.Product) *contentModel {
+	raw := make(map[string]map[string]float64, len(products))
+	frequency := map[string]int{}
+	for _, product := range products {
+		features := productFeatures(product)
+		if len(features) == 0 {
+			continue
+		}
+		raw[product.ProductID] = features
+		for feature := range features {
+			frequency[feature]++
+		}
+	}
+
+	count := float64(len(raw))
+	model := &contentModel{vectors: make(map[string]featureVector, len(raw)), builtAt: time.Now()}
+	for productID, features := range raw {
+		vector := make(featureVector, len(features))
+		for feature, weight := range features {
+			idf := math.Log((1+count)/(1+float64(frequency[feature]))) + 1
+			vector[feature] = weight * idf
+		}
+		model.vectors[productID] = vector.normalize()
+	}
+	return model
+}
+
+// nearest returns the products most similar to the vector, best first, with
+// the cosine similarity as score. Excluded products are skipped.
+func (m *contentModel) nearest(vector featureVector, exclude map[string]bool, limit int) []models.ProductRecommendation {
+	results := []models.ProductRecommendation{}
+	for productID, candidate := range m.vectors {
+		if exclude[productID] {
+			continue
+		}
+		if similarity := vector.dot(candidate); similarity > 0 {
+			results = append(results, models.ProductRecommendation{ProductID: productID, Score: math.Round(similarity*1000) / 1000})
+		}
+	}
+
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Score != results[j].Score {
+			return results[i].Score > results[j].Score
+		}
+		return results[i].ProductID < results[j].ProductID
+	})
+	if len(results) > limit {
+		results = results[:limit]
+	}
+	return results
+}
+
+// contentRecommender keeps the content model in memory and rebuilds it from
+// the products collection once it is older than the configured TTL.
+type contentRecommender struct {
+	db    *mongo.Database
+	cfg   config.Config
+	group singleflight.Group
+	mu    sync.RWMutex
+	model *contentModel
+}
+
+func (r *contentRecommender) currentModel(ctx context.Context) (*contentModel, error) {
+	r.mu.RLock()
+	model := r.model
+	r.mu.RUnlock()
+	if model != nil && time.Since(model.builtAt) < r.cfg.Content.ModelTTL {
+		return model, nil
+	}
+
+	result, err, _ := r.group.Do("model", func() (interface{}, error) {
+		ctx, cancel := sharedLoadContext(ctx)
+		defer cancel()
+
+		cursor, err := r.db.Collection("products").Find(ctx, bson.M{})
+		if err != nil {
+			return nil, err
+		}
+		var products []models.Product
+		if err := cursor.All(ctx, &products); err != nil {
+			return nil, err
+		}
+
+		model := buildContentModel(products)
+		r.mu.Lock()
+		r.model = model
+		r.mu.Unlock()
+		return model, nil
+	})
+	if err != nil {
+		// keep serving the previous model while the catalog is unreachable
+		if model != nil {
+			return model, nil
+		}
+		return nil, err
+	}
+	return result.(*contentModel), nil
+}
+
+// similarProducts returns the products closest to the given one by catalog
+// attributes, or none when the product has no catalog data.
+func (r *contentRecommender) similarProducts(ctx context.Context, productID string, limit int) ([]models.ProductRecommendation, error) {
+	model, err := r.currentModel(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	vector, ok := model.vectors[productID]
+	if !ok {
+		return []models.ProductRecommendation{}, nil
+	}
+	return model.nearest(vector, map[string]bool{productID: true}, limit), nil
+}
+
+// userProducts returns products matching the user's attribute affinity, a
+// profile summing the vectors of recently interacted products weighted by
+// event type. Products the user already interacted with are excluded.
+func (r *contentRecommender) userProducts(ctx context.Context, userID string, limit int) ([]models.ProductRecommendation, error) {
+	model, err := r.currentModel(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	events, err := recentUserEvents(ctx, r.db, userID, r.cfg.Scoring.HistoryLimit)
+	if err != nil {
+		return nil, err
+	}
+
+	profile := featureVector{}
+	seen := make(map[string]bool, len(events))
+	for _, event := range events {
+		seen[event.ProductID] = true
+
+		weight := eventWeight(r.cfg.Scoring.Personal, event.EventType)
+		for feature, value := range model.vectors[event.ProductID] {
+			profile[feature] += weight * value
+		}
+	}
+	if len(profile) == 0 {
+		return []models.ProductRecommendation{}, nil
+	}
+
+	return model.nearest(profile.normalize(), seen, limit), nil
+}
+
+// GetSimilarProducts returns a page of the products most similar to the given
+// one by catalog attributes. The first page follows the current content
+// model; later pages are cut from the list the first page came from, even
+// after the model was rebuilt.
+func (s *RecommendationService) GetSimilarProducts(ctx context.Context, productID string, query RecommendationQuery) ([]models.ProductRecommendation, string, error) {
+	cursor, err := DecodeCursor(query.Cursor, cursorKindSimilar)
+	if err != nil {
+		return nil, "", err
+	}
+	query = query.withRuleLog()
+	offset := 0
+	if cursor != nil {
+		offset = cursor.Offset
+	}
+
+	similar, err := s.pagedList(ctx, cursor, productID, func(ctx context.Context) ([]models.ProductRecommendation, error) {
+		return s.content.similarProducts(ctx, productID, maxSimilarProducts)
+	})
+	if err != nil {
+		return nil, "", err
+	}
+
+	explored := explorationFrom(cursor)
+	stages := append(s.listStages(query), s.rankingStages(query, PlacementSimilar, explored)...)
+	products, err := applyStages(ctx, similar, stages)
+	if err != nil {
+		return nil, "", err
+	}
+
+	products, nextOffset := paginate(products, offset, query.Limit)
+	if products, err = s.expand(ctx, query, products); err != nil {
+		return nil, "", err
+	}
+	if products, err = s.explain(ctx, query, "", productID, sourceContent, products); err != nil {
+		return nil, "", err
+	}
+	if nextOffset < 0 {
+		return products, "", nil
+	}
+	pin := s.nextPin(ctx, cursor, productID, similar)
+	return products, EncodeCursor(explored.nextCursor(&PageCursor{Kind: cursorKindSimilar, Pin: pin, Offset: nextOffset}, products)), nil
+}
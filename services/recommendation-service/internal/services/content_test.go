@@ -0,0 +1,65 @@
+package services
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"polyforge-recommendation/internal/config"
+	"polyforge-recommendation/internal/models"
+)
+
+// shoes returns a catalog of shoes where the given products share brand x
+// with product a.
+func shoes(sameBrand ...string) []models.Product {
+	catalog := []models.Product{{ProductID: "a", Category: "shoes", Brand: "x"}}
+	for _, id := range []string{"b", "c", "d", "e"} {
+		product := models.Product{ProductID: id, Category: "shoes", Brand: "y"}
+		for _, same := range sameBrand {
+			if id == same {
+				product.Brand = "x"
+			}
+		}
+		catalog = append(catalog, product)
+	}
+	return append(catalog, models.Product{ProductID: "f", Category: "bags"})
+}
+
+func TestSimilarProductPagesStayOnTheFirstPageList(t *testing.T) {
+	s := newTestService(&fakeStore{}, newFakeCache())
+	s.content = &contentRecommender{cfg: config.Config{Content: config.ContentConfig{ModelTTL: time.Hour}}, model: buildContentModel(shoes("e"))}
+
+	page, next, err := s.GetSimilarProducts(context.Background(), "a", RecommendationQuery{Limit: 2})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	served := listedIDs(page)
+
+	// the catalog changes and the model is rebuilt before the next pages
+	s.content.model = buildContentModel(shoes("b"))
+
+	if _, _, err := s.GetSimilarProducts(context.Background(), "b", RecommendationQuery{Limit: 2, Cursor: next}); err != ErrInvalidCursor {
+		t.Errorf("expected %v for the cursor of another product, got %v", ErrInvalidCursor, err)
+	}
+	for next != "" {
+		if page, next, err = s.GetSimilarProducts(context.Background(), "a", RecommendationQuery{Limit: 2, Cursor: next}); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		served = append(served, listedIDs(page)...)
+	}
+
+	want := []string{"e", "b", "c", "d"}
+	if !reflect.DeepEqual(served, want) {
+		t.Errorf("expected the pages to serve %v, got %v", want, served)
+	}
+}
+
+func TestSimilarProductCursorExpiresWithItsList(t *testing.T) {
+	s := newTestService(&fakeStore{}, newFakeCache())
+
+	cursor := EncodeCursor(&PageCursor{Kind: cursorKindSimilar, Pin: "a:gone", Offset: 2})
+	if _, _, err := s.GetSimilarProducts(context.Background(), "a", RecommendationQuery{Limit: 2, Cursor: cursor}); err != ErrCursorExpired {
+		t.Errorf("expected %v, got %v", ErrCursorExpired, err)
+	}
+}
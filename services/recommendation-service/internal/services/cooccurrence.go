@@ -0,0 +1,136 @@
+package services
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+
+	"polyforge-recommendation/internal/models"
+)
+
+// maxCoVisitors bounds how many other users' histories are read to find the
+// products co-visited with a user's recent ones.
+const maxCoVisitors = 500
+
+// coVisitedProducts returns the products most often interacted with by users
+// who also interacted with the given ones ("users who viewed this also
+// viewed"), scored by the number of such users. The given products are
+// excluded, as is the user whose history they come from.
+func (s *RecommendationService) coVisitedProducts(ctx context.Context, userID string, productIDs []string, limit int) ([]models.ProductRecommendation, error) {
+	products := []models.ProductRecommendation{}
+	if len(productIDs) == 0 {
+		return products, nil
+	}
+
+	events := s.db.Collection("events")
+	userCursor, err := events.Aggregate(ctx, []bson.M{
+		{"$match": bson.M{"productId": bson.M{"$in": productIDs}, "userId": bson.M{"$ne": userID}}},
+		{"$group": bson.M{"_id": "$userId"}},
+		{"$limit": maxCoVisitors},
+	})
+	if err != nil {
+		return nil, err
+	}
+	var visitors []struct {
+		UserID string `bson:"_id"`
+	}
+	if err := userCursor.All(ctx, &visitors); err != nil {
+		return nil, err
+	}
+	if len(visitors) == 0 {
+		return products, nil
+	}
+
+	userIDs := make([]string, len(visitors))
+	for i, visitor := range visitors {
+		userIDs[i] = visitor.UserID
+	}
+
+	cursor, err := events.Aggregate(ctx, []bson.M{
+		{"$match": bson.M{"userId": bson.M{"$in": userIDs}, "productId": bson.M{"$nin": productIDs}}},
+		// count every co-visitor once per product
+		{"$group": bson.M{"_id": bson.M{"productId": "$productId", "userId": "$userId"}, "lastInteraction": bson.M{"$max": "$timestamp"}}},
+		{"$group": bson.M{"_id": "$_id.productId", "count": bson.M{"$sum": 1}, "lastInteraction": bson.M{"$max": "$lastInteraction"}}},
+		{"$sort": bson.D{{Key: "count", Value: -1}, {Key: "_id", Value: 1}}},
+		{"$limit": limit},
+		{"$project": bson.M{"_id": 0, "productId": "$_id", "count": 1, "score": "$count", "lastInteraction": 1}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := cursor.All(ctx, &products); err != nil {
+		return nil, err
+	}
+	return products, nil
+}
+
+// itemItemProducts returns the products co-visited with the user's recent
+// history.
+func (s *RecommendationService) itemItemProducts(ctx context.Context, userID string, limit int) ([]models.ProductRecommendation, error) {
+	events, err := recentUserEvents(ctx, s.db, userID, s.cfg.Scoring.HistoryLimit)
+	if err != nil {
+		return nil, err
+	}
+
+	seen := map[string]bool{}
+	var history []string
+	for _, event := range events {
+		if !seen[event.ProductID] {
+			seen[event.ProductID] = true
+			history = append(history, event.ProductID)
+		}
+	}
+	return s.coVisitedProducts(ctx, userID, history, limit)
+}
+
+// productVisitors returns, for each given product, a sample of up to
+// maxCoVisitors users who interacted with it.
+func (s *RecommendationService) productVisitors(ctx context.Context, productIDs []string) (map[string]map[string]bool, error) {
+	visitors := make(map[string]map[string]bool, len(productIDs))
+	if len(productIDs) == 0 {
+		return visitors, nil
+	}
+
+	cursor, err := s.db.Collection("events").Aggregate(ctx, []bson.M{
+		{"$match": bson.M{"productId": bson.M{"$in": productIDs}}},
+		{"$group": bson.M{"_id": "$productId", "users": bson.M{"$addToSet": "$userId"}}},
+		{"$project": bson.M{"users": bson.M{"$slice": []interface{}{"$users", maxCoVisitors}}}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	var results []struct {
+		ProductID string   `bson:"_id"`
+		Users     []string `bson:"users"`
+	}
+	if err := cursor.All(ctx, &results); err != nil {
+		return nil, err
+	}
+
+	for _, result := range results {
+		users := make(map[string]bool, len(result.Users))
+		for _, user := range result.Users {
+			users[user] = true
+		}
+		visitors[result.ProductID] = users
+	}
+	return visitors, nil
+}
+
+// jaccard is the overlap of two visitor sets.
+func jaccard(a, b map[string]bool) float64 {
+	if len(a) == 0 || len(b) == 0 {
+		return 0
+	}
+	if len(b) < len(a) {
+		a, b = b, a
+	}
+
+	shared := 0
+	for user := range a {
+		if b[user] {
+			shared++
+		}
+	}
+	return float64(shared) / float64(len(a)+len(b)-shared)
+}
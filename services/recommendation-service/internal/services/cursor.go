@@ -0,0 +1,76 @@
+package services
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+
+	"polyforge-recommendation/internal/models"
+)
+
+var (
+	ErrInvalidCursor = errors.New("invalid cursor")
+	ErrCursorExpired = errors.New("cursor has expired")
+)
+
+const (
+	cursorKindUser      = "user"
+	cursorKindTrending  = "trending"
+	cursorKindSimilar   = "similar"
+	cursorKindPlacement = "placement"
+)
+
+// PageCursor points into a ranked list. Pin identifies the list the first
+// page came from (a blend ID for user lists, a computation ID for trending,
+// the anchor and ID of a pinned list for similar and co-visited products)
+// so later pages neither shift nor repeat when the list is rebuilt in the
+// meantime. Explored lists the products a bandit put on the first page,
+// which later pages leave out; Offset then counts only the other products.
+type PageCursor struct {
+	Kind     string   `json:"k"`
+	Pin      string   `json:"p"`
+	Offset   int      `json:"o"`
+	Explored []string `json:"x,omitempty"`
+}
+
+func EncodeCursor(cursor *PageCursor) string {
+	if cursor == nil {
+		return ""
+	}
+	data, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// DecodeCursor parses an opaque cursor of the given kind; an empty string
+// means the first page.
+func DecodeCursor(raw, kind string) (*PageCursor, error) {
+	if raw == "" {
+		return nil, nil
+	}
+
+	data, err := base64.RawURLEncoding.DecodeString(raw)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	var cursor PageCursor
+	if err := json.Unmarshal(data, &cursor); err != nil || cursor.Kind != kind || cursor.Pin == "" || cursor.Offset < 0 {
+		return nil, ErrInvalidCursor
+	}
+	return &cursor, nil
+}
+
+// paginate returns the page of products starting at offset together with
+// the offset of the next page, or -1 when this is the last one. The page
+// is capped so appending to it never writes into the shared list.
+func paginate(products []models.ProductRecommendation, offset, limit int) ([]models.ProductRecommendation, int) {
+	if offset >= len(products) {
+		return []models.ProductRecommendation{}, -1
+	}
+
+	end := offset + limit
+	if end >= len(products) {
+		return products[offset:len(products):len(products)], -1
+	}
+	return products[offset:end:end], end
+}
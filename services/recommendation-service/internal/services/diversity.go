@@ -0,0 +1,153 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strings"
+
+	"polyforge-recommendation/internal/config"
+	"polyforge-recommendation/internal/models"
+)
+
+// Placements of the list endpoints, used when a request names none.
+const (
+	PlacementPersonal = "personal"
+	PlacementTrending = "trending"
+	PlacementSimilar  = "similar"
+)
+
+// diversityStage re-ranks the head of a list with the given settings. Only
+// the first Window products are re-ranked; the rest keep their order behind
+// them.
+func (s *RecommendationService) diversityStage(placement string, settings config.DiversitySettings) ListStage {
+	return func(ctx context.Context, products []models.ProductRecommendation) ([]models.ProductRecommendation, error) {
+		window := settings.Window
+		if window <= 0 || window > len(products) {
+			window = len(products)
+		}
+		if window < 2 {
+			return products, nil
+		}
+
+		var head []models.ProductRecommendation
+		var err error
+		switch settings.Method {
+		case config.DiversityMethodMMR:
+			head, err = s.rerankMMR(ctx, products[:window], settings.Lambda)
+		case config.DiversityMethodCategoryCap:
+			var tail []models.ProductRecommendation
+			head, tail, err = s.capCategories(ctx, products[:window], settings.CategoryCap)
+			head = append(head, tail...)
+		default:
+			return products, nil
+		}
+		if err != nil {
+			// an undiversified list is better than none
+			fmt.Printf("Error diversifying %s recommendations: %v\n", placement, err)
+			return products, nil
+		}
+
+		return append(head, products[window:]...), nil
+	}
+}
+
+// rerankMMR orders the products by maximal marginal relevance: each next
+// product maximises lambda * relevance - (1 - lambda) * its highest
+// similarity to the products already picked. Relevance is the score relative
+// to the best one.
+func (s *RecommendationService) rerankMMR(ctx context.Context, products []models.ProductRecommendation, lambda float64) ([]models.ProductRecommendation, error) {
+	similarity, err := s.itemSimilarity(ctx, productIDs(products))
+	if err != nil {
+		return nil, err
+	}
+
+	var best float64
+	for _, product := range products {
+		best = math.Max(best, product.Score)
+	}
+
+	remaining := append([]models.ProductRecommendation{}, products...)
+	// maxSimilarity[i] is the highest similarity of remaining[i] to a picked product
+	maxSimilarity := make([]float64, len(remaining))
+	ranked := make([]models.ProductRecommendation, 0, len(products))
+	for len(remaining) > 0 {
+		pick, pickValue := 0, math.Inf(-1)
+		for i, product := range remaining {
+			relevance := 1.0
+			if best > 0 {
+				relevance = product.Score / best
+			}
+			if value := lambda*relevance - (1-lambda)*maxSimilarity[i]; value > pickValue {
+				pick, pickValue = i, value
+			}
+		}
+
+		picked := remaining[pick]
+		ranked = append(ranked, picked)
+		remaining = append(remaining[:pick], remaining[pick+1:]...)
+		maxSimilarity = append(maxSimilarity[:pick], maxSimilarity[pick+1:]...)
+		for i, product := range remaining {
+			maxSimilarity[i] = math.Max(maxSimilarity[i], similarity(picked.ProductID, product.ProductID))
+		}
+	}
+	return ranked, nil
+}
+
+// capCategories keeps at most limit products of every category and moves the
+// others behind them, preserving order. Products without a known category
+// are never capped.
+func (s *RecommendationService) capCategories(ctx context.Context, products []models.ProductRecommendation, limit int) ([]models.ProductRecommendation, []models.ProductRecommendation, error) {
+	metadata, err := s.products.GetProducts(ctx, productIDs(products))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	counts := map[string]int{}
+	kept := make([]models.ProductRecommendation, 0, len(products))
+	var overflow []models.ProductRecommendation
+	for _, product := range products {
+		category := strings.ToLower(metadata[product.ProductID].Category)
+		if category != "" && counts[category] >= limit {
+			overflow = append(overflow, product)
+			continue
+		}
+		counts[category]++
+		kept = append(kept, product)
+	}
+	return kept, overflow, nil
+}
+
+// itemSimilarity returns a similarity between two of the given products. It
+// uses catalog attributes when both products have them and falls back to
+// the overlap of the users who interacted with them otherwise.
+func (s *RecommendationService) itemSimilarity(ctx context.Context, ids []string) (func(a, b string) float64, error) {
+	model, err := s.content.currentModel(ctx)
+	if err != nil {
+		fmt.Printf("Error loading content model for diversity: %v\n", err)
+		model = &contentModel{}
+	}
+
+	var missing []string
+	for _, id := range ids {
+		if _, ok := model.vectors[id]; !ok {
+			missing = append(missing, id)
+		}
+	}
+
+	visitors := map[string]map[string]bool{}
+	if len(missing) > 0 {
+		if visitors, err = s.productVisitors(ctx, ids); err != nil {
+			return nil, err
+		}
+	}
+
+	return func(a, b string) float64 {
+		va, okA := model.vectors[a]
+		vb, okB := model.vectors[b]
+		if okA && okB {
+			return va.dot(vb)
+		}
+		return jaccard(visitors[a], visitors[b])
+	}, nil
+}
@@ -0,0 +1,177 @@
+package services
+
+import (
+	"context"
+	"sort"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+
+	"polyforge-recommendation/internal/config"
+	"polyforge-recommendation/internal/models"
+)
+
+// DryRunRebuild scores users with the given weights without persisting
+// anything and compares the top K of each result with the list stored in the
+// active snapshot. A positive sample scores that many randomly chosen users
+// instead of every user.
+func (s *RecommendationService) DryRunRebuild(ctx context.Context, weights config.ScoringWeights, topK, sample int) (models.RebuildDiffReport, error) {
+	report := models.RebuildDiffReport{TopK: topK, Sample: sample, Users: []models.UserRecommendationDiff{}}
+
+	version, err := s.GetActiveSnapshotVersion(ctx)
+	if err != nil {
+		return report, err
+	}
+	report.BaseVersion = version
+
+	var userIDs []string
+	if sample > 0 {
+		userIDs, err = s.sampleUserIDs(ctx, sample)
+	} else {
+		userIDs, err = s.distinctUserIDs(ctx)
+	}
+	if err != nil {
+		return report, err
+	}
+
+	var jaccardSum, correlationSum float64
+	var correlationCount int
+	for _, userID := range userIDs {
+		candidate, err := s.scoreUserProducts(ctx, userID, weights)
+		if err != nil {
+			return report, err
+		}
+
+		var stored models.UserRecommendation
+		err = s.db.Collection("user_recommendations").FindOne(ctx, userRecommendationFilter(userID, version)).Decode(&stored)
+		hasHistory := err != mongo.ErrNoDocuments
+		if err != nil && hasHistory {
+			return report, err
+		}
+
+		diff := diffRecommendations(userID, topProductIDs(stored.Products, topK), topProductIDs(candidate, topK))
+		report.Users = append(report.Users, diff)
+
+		report.Summary.ItemsAdded += len(diff.Added)
+		report.Summary.ItemsRemoved += len(diff.Removed)
+		if len(diff.Added) > 0 || len(diff.Removed) > 0 || (diff.RankCorrelation != nil && *diff.RankCorrelation < 1) {
+			report.Summary.UsersChanged++
+		}
+
+		// new users have nothing to be compared against
+		if !hasHistory {
+			report.Summary.UsersWithoutHistory++
+			continue
+		}
+		report.Summary.UsersCompared++
+		jaccardSum += diff.Jaccard
+		if diff.RankCorrelation != nil {
+			correlationSum += *diff.RankCorrelation
+			correlationCount++
+		}
+	}
+
+	if report.Summary.UsersCompared > 0 {
+		report.Summary.MeanJaccard = jaccardSum / float64(report.Summary.UsersCompared)
+	}
+	if correlationCount > 0 {
+		mean := correlationSum / float64(correlationCount)
+		report.Summary.MeanRankCorrelation = &mean
+	}
+
+	return report, nil
+}
+
+// sampleUserIDs returns up to size users with events, chosen at random.
+func (s *RecommendationService) sampleUserIDs(ctx context.Context, size int) ([]string, error) {
+	cursor, err := s.db.Collection("events").Aggregate(ctx, []bson.M{
+		{"$group": bson.M{"_id": "$userId"}},
+		{"$sample": bson.M{"size": size}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	var users []struct {
+		UserID string `bson:"_id"`
+	}
+	if err := cursor.All(ctx, &users); err != nil {
+		return nil, err
+	}
+
+	userIDs := make([]string, len(users))
+	for i, user := range users {
+		userIDs[i] = user.UserID
+	}
+	return userIDs, nil
+}
+
+func topProductIDs(products []models.ProductRecommendation, k int) []string {
+	if len(products) > k {
+		products = products[:k]
+	}
+	ids := make([]string, len(products))
+	for i, product := range products {
+		ids[i] = product.ProductID
+	}
+	return ids
+}
+
+// diffRecommendations compares two ranked lists. The rank correlation is
+// Spearman's rho over the products present in both lists and is undefined
+// when fewer than two products are shared.
+func diffRecommendations(userID string, before, after []string) models.UserRecommendationDiff {
+	diff := models.UserRecommendationDiff{UserID: userID, Added: []string{}, Removed: []string{}}
+
+	beforeRank := make(map[string]int, len(before))
+	for i, id := range before {
+		beforeRank[id] = i
+	}
+	afterRank := make(map[string]int, len(after))
+	for i, id := range after {
+		afterRank[id] = i
+	}
+
+	var shared []string
+	for _, id := range after {
+		if _, ok := beforeRank[id]; ok {
+			shared = append(shared, id)
+		} else {
+			diff.Added = append(diff.Added, id)
+		}
+	}
+	for _, id := range before {
+		if _, ok := afterRank[id]; !ok {
+			diff.Removed = append(diff.Removed, id)
+		}
+	}
+
+	union := len(shared) + len(diff.Added) + len(diff.Removed)
+	if union == 0 {
+		diff.Jaccard = 1
+	} else {
+		diff.Jaccard = float64(len(shared)) / float64(union)
+	}
+
+	n := len(shared)
+	if n < 2 {
+		return diff
+	}
+
+	// shared is in "after" order; rank it again by its "before" order
+	byBefore := append([]string(nil), shared...)
+	sort.Slice(byBefore, func(i, j int) bool { return beforeRank[byBefore[i]] < beforeRank[byBefore[j]] })
+	sharedBeforeRank := make(map[string]int, n)
+	for i, id := range byBefore {
+		sharedBeforeRank[id] = i
+	}
+
+	var squaredDiff float64
+	for i, id := range shared {
+		d := float64(i - sharedBeforeRank[id])
+		squaredDiff += d * d
+	}
+	rho := 1 - 6*squaredDiff/float64(n*(n*n-1))
+	diff.RankCorrelation = &rho
+
+	return diff
+}
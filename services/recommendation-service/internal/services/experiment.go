@@ -0,0 +1,411 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+	"golang.org/x/sync/singleflight"
+
+	"polyforge-recommendation/internal/config"
+	"polyforge-recommendation/internal/models"
+)
+
+var (
+	ErrExperimentNotFound = errors.New("experiment not found")
+	ErrInvalidExperiment  = errors.New("invalid experiment")
+)
+
+// wilsonZ is the z-score of the 95% confidence intervals in results.
+const wilsonZ = 1.96
+
+// ExperimentService stores experiments, assigns users to variants and
+// reports per-variant results. Running experiments are kept in memory and
+// reloaded once they are older than the configured TTL.
+type ExperimentService struct {
+	db       *mongo.Database
+	cfg      config.Config
+	group    singleflight.Group
+	mu       sync.RWMutex
+	running  []models.Experiment
+	loadedAt time.Time
+}
+
+func NewExperimentService(db *mongo.Database, cfg config.Config) *ExperimentService {
+	return &ExperimentService{db: db, cfg: cfg}
+}
+
+func (s *ExperimentService) ListExperiments(ctx context.Context) ([]models.Experiment, error) {
+	cursor, err := s.db.Collection("experiments").Find(ctx, bson.M{},
+		options.Find().SetSort(bson.M{"createdAt": -1}),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	experiments := []models.Experiment{}
+	if err := cursor.All(ctx, &experiments); err != nil {
+		return nil, err
+	}
+	return experiments, nil
+}
+
+func (s *ExperimentService) GetExperiment(ctx context.Context, experimentID string) (*models.Experiment, error) {
+	id, err := bson.ObjectIDFromHex(experimentID)
+	if err != nil {
+		return nil, ErrExperimentNotFound
+	}
+
+	var experiment models.Experiment
+	err = s.db.Collection("experiments").FindOne(ctx, bson.M{"_id": id}).Decode(&experiment)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrExperimentNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	return &experiment, nil
+}
+
+// CreateExperiment stores a new experiment, which starts running at once.
+func (s *ExperimentService) CreateExperiment(ctx context.Context, experiment models.Experiment) (*models.Experiment, error) {
+	if len(experiment.Variants) < 2 {
+		return nil, fmt.Errorf("%w: at least two variants are needed", ErrInvalidExperiment)
+	}
+	names := map[string]bool{}
+	for _, variant := range experiment.Variants {
+		if variant.Weight <= 0 {
+			return nil, fmt.Errorf("%w: variant %s needs a positive weight", ErrInvalidExperiment, variant.Name)
+		}
+		if names[variant.Name] {
+			return nil, fmt.Errorf("%w: duplicate variant %s", ErrInvalidExperiment, variant.Name)
+		}
+		names[variant.Name] = true
+		if err := validateStrategy(variant); err != nil {
+			return nil, err
+		}
+	}
+
+	experiment.ID = bson.NewObjectID()
+	experiment.Status = models.ExperimentStatusRunning
+	experiment.CreatedAt = time.Now()
+	if _, err := s.db.Collection("experiments").InsertOne(ctx, experiment); err != nil {
+		return nil, err
+	}
+
+	s.resetExperiments()
+	return &experiment, nil
+}
+
+// validateStrategy checks a variant's overrides against the values the
+// configuration accepts for the same settings.
+func validateStrategy(variant models.ExperimentVariant) error {
+	strategy := variant.Strategy
+	switch strategy.BlendMode {
+	case "", config.BlendModeWeighted, config.BlendModeInterleave:
+	default:
+		return fmt.Errorf("%w: variant %s has unknown blend mode %q", ErrInvalidExperiment, variant.Name, strategy.BlendMode)
+	}
+
+	positive := false
+	for source, weight := range strategy.BlendWeights {
+		if !isBlendSource(source) {
+			return fmt.Errorf("%w: variant %s has unknown blend source %q", ErrInvalidExperiment, variant.Name, source)
+		}
+		if !(weight >= 0) {
+			return fmt.Errorf("%w: variant %s needs non-negative blend weights", ErrInvalidExperiment, variant.Name)
+		}
+		positive = positive || weight > 0
+	}
+	if len(strategy.BlendWeights) > 0 && !positive {
+		return fmt.Errorf("%w: variant %s needs a positive blend weight", ErrInvalidExperiment, variant.Name)
+	}
+
+	switch strategy.DiversityMethod {
+	case "", config.DiversityMethodNone, config.DiversityMethodMMR, config.DiversityMethodCategoryCap:
+	default:
+		return fmt.Errorf("%w: variant %s has unknown diversity method %q", ErrInvalidExperiment, variant.Name, strategy.DiversityMethod)
+	}
+	if lambda := strategy.DiversityLambda; lambda != nil && !(*lambda >= 0 && *lambda <= 1) {
+		return fmt.Errorf("%w: variant %s needs a diversity lambda between 0 and 1", ErrInvalidExperiment, variant.Name)
+	}
+	return nil
+}
+
+// StopExperiment ends an experiment and keeps its results available. Its
+// users move to the next oldest experiment running on their placement, or
+// back to the configured ranking; other replicas only notice once their
+// running experiments are reloaded.
+func (s *ExperimentService) StopExperiment(ctx context.Context, experimentID string) (*models.Experiment, error) {
+	id, err := bson.ObjectIDFromHex(experimentID)
+	if err != nil {
+		return nil, ErrExperimentNotFound
+	}
+
+	var experiment models.Experiment
+	err = s.db.Collection("experiments").FindOneAndUpdate(ctx,
+		bson.M{"_id": id},
+		bson.M{"$set": bson.M{"status": models.ExperimentStatusStopped, "stoppedAt": time.Now()}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&experiment)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrExperimentNotFound
+	} else if err != nil {
+		return nil, err
+	}
+
+	s.resetExperiments()
+	return &experiment, nil
+}
+
+// resetExperiments makes the next assignment reload the running
+// experiments, so changes apply at once on this replica.
+func (s *ExperimentService) resetExperiments() {
+	s.mu.Lock()
+	s.loadedAt = time.Time{}
+	s.mu.Unlock()
+}
+
+func (s *ExperimentService) runningExperiments(ctx context.Context) ([]models.Experiment, error) {
+	s.mu.RLock()
+	running, loadedAt := s.running, s.loadedAt
+	s.mu.RUnlock()
+	if time.Since(loadedAt) < s.cfg.Experiments.CacheTTL {
+		return running, nil
+	}
+
+	result, err, _ := s.group.Do("running", func() (interface{}, error) {
+		ctx, cancel := sharedLoadContext(ctx)
+		defer cancel()
+
+		cursor, err := s.db.Collection("experiments").Find(ctx,
+			bson.M{"status": models.ExperimentStatusRunning},
+			options.Find().SetSort(bson.M{"createdAt": 1}),
+		)
+		if err != nil {
+			return nil, err
+		}
+		running := []models.Experiment{}
+		if err := cursor.All(ctx, &running); err != nil {
+			return nil, err
+		}
+
+		s.mu.Lock()
+		s.running, s.loadedAt = running, time.Now()
+		s.mu.Unlock()
+		return running, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result.([]models.Experiment), nil
+}
+
+// Assign returns the user's variant in the oldest running experiment of the
+// placement, or nil when no experiment runs there. The same user always gets
+// the same variant of an experiment.
+func (s *ExperimentService) Assign(ctx context.Context, userID, placement string) (*models.ExperimentAssignment, error) {
+	if userID == "" {
+		return nil, nil
+	}
+
+	running, err := s.runningExperiments(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, experiment := range running {
+		if len(experiment.Placements) > 0 && !containsFold(experiment.Placements, placement) {
+			continue
+		}
+		variant := bucketVariant(experiment, userID)
+		return &models.ExperimentAssignment{
+			ExperimentID: experiment.ID.Hex(),
+			Variant:      variant.Name,
+			Strategy:     variant.Strategy,
+		}, nil
+	}
+	return nil, nil
+}
+
+// bucketVariant hashes the user into one of 10000 buckets, split between the
+// variants in proportion to their weights. Hashing with the experiment ID
+// keeps assignments independent between experiments.
+func bucketVariant(experiment models.Experiment, userID string) models.ExperimentVariant {
+	hash := fnv.New64a()
+	hash.Write([]byte(experiment.ID.Hex() + ":" + userID))
+	bucket := int(hash.Sum64() % 10000)
+
+	total := 0
+	for _, variant := range experiment.Variants {
+		total += variant.Weight
+	}
+	threshold := 0
+	for _, variant := range experiment.Variants {
+		threshold += variant.Weight
+		if bucket < threshold*10000/total {
+			return variant
+		}
+	}
+	return experiment.Variants[len(experiment.Variants)-1]
+}
+
+// RecordImpression counts a list page served to a variant.
+func (s *ExperimentService) RecordImpression(ctx context.Context, assignment *models.ExperimentAssignment) error {
+	_, err := s.db.Collection("experiment_impressions").UpdateOne(ctx,
+		bson.M{"experimentId": assignment.ExperimentID, "variant": assignment.Variant},
+		bson.M{"$inc": bson.M{"count": 1}},
+		options.UpdateOne().SetUpsert(true),
+	)
+	return err
+}
+
+// Results reports impressions, clicks (attributed views) and conversions
+// (attributed purchases) per variant.
+func (s *ExperimentService) Results(ctx context.Context, experimentID string) (*models.ExperimentResults, error) {
+	experiment, err := s.GetExperiment(ctx, experimentID)
+	if err != nil {
+		return nil, err
+	}
+
+	impressions := map[string]int64{}
+	cursor, err := s.db.Collection("experiment_impressions").Find(ctx, bson.M{"experimentId": experimentID})
+	if err != nil {
+		return nil, err
+	}
+	var counters []struct {
+		Variant string `bson:"variant"`
+		Count   int64  `bson:"count"`
+	}
+	if err := cursor.All(ctx, &counters); err != nil {
+		return nil, err
+	}
+	for _, counter := range counters {
+		impressions[counter.Variant] = counter.Count
+	}
+
+	cursor, err = s.db.Collection("events").Aggregate(ctx, []bson.M{
+		{"$match": bson.M{"experimentId": experimentID}},
+		{"$group": bson.M{
+			"_id":         "$variant",
+			"clicks":      eventTypeCounter("VIEW"),
+			"conversions": eventTypeCounter("PURCHASE"),
+		}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	var outcomes []struct {
+		Variant     string `bson:"_id"`
+		Clicks      int64  `bson:"clicks"`
+		Conversions int64  `bson:"conversions"`
+	}
+	if err := cursor.All(ctx, &outcomes); err != nil {
+		return nil, err
+	}
+	byVariant := map[string]models.VariantResult{}
+	for _, outcome := range outcomes {
+		byVariant[outcome.Variant] = models.VariantResult{Clicks: outcome.Clicks, Conversions: outcome.Conversions}
+	}
+
+	results := &models.ExperimentResults{Experiment: *experiment, Variants: []models.VariantResult{}}
+	for _, variant := range experiment.Variants {
+		result := byVariant[variant.Name]
+		result.Variant = variant.Name
+		result.Impressions = impressions[variant.Name]
+		result.CTR, result.CTRInterval = rateWithInterval(result.Clicks, result.Impressions)
+		result.ConversionRate, result.ConversionInterval = rateWithInterval(result.Conversions, result.Impressions)
+		results.Variants = append(results.Variants, result)
+	}
+	return results, nil
+}
+
+// rateWithInterval returns the rate of successes and its 95% Wilson score
+// interval, which stays within [0, 1] and behaves for small samples.
+func rateWithInterval(successes, trials int64) (float64, [2]float64) {
+	if trials <= 0 {
+		return 0, [2]float64{0, 0}
+	}
+
+	n := float64(trials)
+	p := math.Min(float64(successes)/n, 1)
+	z2 := wilsonZ * wilsonZ
+	denominator := 1 + z2/n
+	center := (p + z2/(2*n)) / denominator
+	margin := wilsonZ * math.Sqrt(p*(1-p)/n+z2/(4*n*n)) / denominator
+	return round4(p), [2]float64{round4(math.Max(0, center-margin)), round4(math.Min(1, center+margin))}
+}
+
+func round4(value float64) float64 {
+	return math.Round(value*10000) / 10000
+}
+
+// blendConfig returns the blending of the query, with the overrides of the
+// user's experiment variant.
+func (s *RecommendationService) blendConfig(query RecommendationQuery) config.BlendConfig {
+	blend := s.cfg.Blend
+	if query.Experiment == nil {
+		return blend
+	}
+
+	strategy := query.Experiment.Strategy
+	if strategy.BlendMode != "" {
+		blend.Mode = strategy.BlendMode
+	}
+	if len(strategy.BlendWeights) > 0 {
+		blend.Weights = strategy.BlendWeights
+	}
+	return blend
+}
+
+// diversitySettings returns the diversity settings of the placement, with
+// the overrides of the user's experiment variant.
+func (s *RecommendationService) diversitySettings(query RecommendationQuery, placement string) config.DiversitySettings {
+	settings := s.cfg.Diversity.Settings(placement)
+	if query.Experiment == nil {
+		return settings
+	}
+
+	strategy := query.Experiment.Strategy
+	if strategy.DiversityMethod != "" {
+		settings.Method = strategy.DiversityMethod
+	}
+	if strategy.DiversityLambda != nil {
+		settings.Lambda = *strategy.DiversityLambda
+	}
+	return settings
+}
+
+// AssignExperiment returns the user's experiment variant for the placement,
+// or nil when the user takes part in no experiment there. Failures only cost
+// the experiment, never the list.
+func (s *RecommendationService) AssignExperiment(ctx context.Context, userID, placement string) *models.ExperimentAssignment {
+	assignment, err := s.experiments.Assign(ctx, userID, placement)
+	if err != nil {
+		fmt.Printf("Error assigning experiment variant for user %s: %v\n", userID, err)
+		return nil
+	}
+	return assignment
+}
+
+// RecordExperimentImpression counts a list page served to a variant in the
+// background.
+func (s *RecommendationService) RecordExperimentImpression(assignment *models.ExperimentAssignment) {
+	if assignment == nil {
+		return
+	}
+
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), refreshTimeout)
+		defer cancel()
+		if err := s.experiments.RecordImpression(ctx, assignment); err != nil {
+			fmt.Printf("Error recording impression of experiment %s: %v\n", assignment.ExperimentID, err)
+		}
+	}()
+}
@@ -0,0 +1,122 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+
+	"polyforge-recommendation/internal/models"
+)
+
+func TestBucketVariantIsDeterministic(t *testing.T) {
+	experiment := models.Experiment{
+		ID:       bson.NewObjectID(),
+		Variants: []models.ExperimentVariant{{Name: "control", Weight: 1}, {Name: "treatment", Weight: 1}},
+	}
+	// a copy loaded from the store assigns the same variants
+	reloaded := experiment
+	reloaded.Variants = append([]models.ExperimentVariant{}, experiment.Variants...)
+
+	for i := 0; i < 100; i++ {
+		userID := fmt.Sprintf("user-%d", i)
+		first := bucketVariant(experiment, userID)
+		if again := bucketVariant(reloaded, userID); again.Name != first.Name {
+			t.Fatalf("user %s got %s and then %s", userID, first.Name, again.Name)
+		}
+	}
+}
+
+func TestBucketVariantSplitsUsersByWeight(t *testing.T) {
+	experiment := models.Experiment{
+		ID: bson.ObjectID{0x65, 0x2f, 0x1a},
+		Variants: []models.ExperimentVariant{
+			{Name: "control", Weight: 1},
+			{Name: "small", Weight: 1},
+			{Name: "large", Weight: 2},
+		},
+	}
+
+	const users = 40000
+	counts := map[string]int{}
+	for i := 0; i < users; i++ {
+		counts[bucketVariant(experiment, fmt.Sprintf("user-%d", i)).Name]++
+	}
+
+	for _, variant := range experiment.Variants {
+		want := float64(users) * float64(variant.Weight) / 4
+		// within about five standard deviations of the binomial count
+		if got := float64(counts[variant.Name]); math.Abs(got-want) > 0.01*users {
+			t.Errorf("expected about %.0f users in %s, got %.0f", want, variant.Name, got)
+		}
+	}
+}
+
+func TestRateWithInterval(t *testing.T) {
+	tests := []struct {
+		successes, trials int64
+		rate              float64
+		interval          [2]float64
+	}{
+		{successes: 0, trials: 0, rate: 0, interval: [2]float64{0, 0}},
+		{successes: 50, trials: 100, rate: 0.5, interval: [2]float64{0.4038, 0.5962}},
+		{successes: 0, trials: 10, rate: 0, interval: [2]float64{0, 0.2775}},
+		{successes: 10, trials: 10, rate: 1, interval: [2]float64{0.7225, 1}},
+		// more successes than trials, e.g. several clicks per impression,
+		// cap the rate at 1
+		{successes: 15, trials: 10, rate: 1, interval: [2]float64{0.7225, 1}},
+	}
+
+	for _, tt := range tests {
+		rate, interval := rateWithInterval(tt.successes, tt.trials)
+		if rate != tt.rate || interval != tt.interval {
+			t.Errorf("rateWithInterval(%d, %d) = %v %v, expected %v %v", tt.successes, tt.trials, rate, interval, tt.rate, tt.interval)
+		}
+	}
+}
+
+func TestCreateExperimentRejectsInvalidStrategies(t *testing.T) {
+	lambda := func(value float64) *float64 { return &value }
+	tests := map[string]models.VariantStrategy{
+		"unknown blend mode":       {BlendMode: "stacked"},
+		"unknown blend source":     {BlendWeights: map[string]float64{"personal": 1, "social": 0.5}},
+		"negative blend weight":    {BlendWeights: map[string]float64{"personal": 1, "trending": -0.5}},
+		"no positive blend weight": {BlendWeights: map[string]float64{"personal": 0}},
+		"unknown diversity method": {DiversityMethod: "shuffle"},
+		"lambda below 0":           {DiversityMethod: "mmr", DiversityLambda: lambda(-0.1)},
+		"lambda above 1":           {DiversityMethod: "mmr", DiversityLambda: lambda(1.5)},
+		"lambda not a number":      {DiversityLambda: lambda(math.NaN())},
+	}
+
+	s := &ExperimentService{}
+	for name, strategy := range tests {
+		experiment := models.Experiment{
+			Variants: []models.ExperimentVariant{
+				{Name: "control", Weight: 1},
+				{Name: "treatment", Weight: 1, Strategy: strategy},
+			},
+		}
+		if _, err := s.CreateExperiment(context.Background(), experiment); !errors.Is(err, ErrInvalidExperiment) {
+			t.Errorf("%s: expected %v, got %v", name, ErrInvalidExperiment, err)
+		}
+	}
+}
+
+func TestValidateStrategyAcceptsConfiguredValues(t *testing.T) {
+	lambda := 0.5
+	strategies := []models.VariantStrategy{
+		{},
+		{BlendMode: "interleave", BlendWeights: map[string]float64{"personal": 1, "item_item": 0, "content": 0.4, "trending": 0.2}},
+		{BlendMode: "weighted", DiversityMethod: "category_cap"},
+		{DiversityMethod: "mmr", DiversityLambda: &lambda},
+		{DiversityMethod: "none"},
+	}
+	for _, strategy := range strategies {
+		if err := validateStrategy(models.ExperimentVariant{Name: "treatment", Weight: 1, Strategy: strategy}); err != nil {
+			t.Errorf("expected %+v to be valid, got %v", strategy, err)
+		}
+	}
+}
@@ -0,0 +1,275 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"polyforge-recommendation/internal/config"
+	"polyforge-recommendation/internal/models"
+	"polyforge-recommendation/internal/scoring"
+)
+
+// eventVerbs describe a user's event in explanations.
+var eventVerbs = map[string]string{
+	"VIEW":     "viewed",
+	"CART_ADD": "added to your cart",
+	"PURCHASE": "bought",
+}
+
+// explanationContext is what explaining a page needs beyond the products:
+// the user's recent products, their names and how they relate to the page.
+type explanationContext struct {
+	history   []recentEvent
+	names     map[string]string
+	anchors   map[string]string
+	ruleNames map[string][]string
+}
+
+// explain sets the reason of every product of a page when the query asks
+// for it. Products without a source get defaultSource; anchorID is the
+// product the whole list was built from, if any.
+func (s *RecommendationService) explain(ctx context.Context, query RecommendationQuery, userID, anchorID, defaultSource string, products []models.ProductRecommendation) ([]models.ProductRecommendation, error) {
+	if !query.Explain || len(products) == 0 {
+		return products, nil
+	}
+
+	explained := make([]models.ProductRecommendation, len(products))
+	copy(explained, products)
+	for i := range explained {
+		if explained[i].Source == "" {
+			explained[i].Source = defaultSource
+		}
+	}
+
+	ec, err := s.explanationContext(ctx, query, userID, anchorID, explained)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, product := range explained {
+		reason := &models.RecommendationReason{Source: product.Source, Rules: ec.ruleNames[product.ProductID]}
+		weights := s.cfg.Scoring.Trending
+		switch product.Source {
+		case sourcePersonal:
+			weights = s.cfg.Scoring.Personal
+			reason.Message = activityMessage(product)
+		case sourceItemItem, sourceContent:
+			related := anchorID
+			if related == "" {
+				related = ec.anchors[product.ProductID]
+			}
+			reason.Message = ec.relatedMessage(product.Source, related, anchorID != "")
+			if related != "" {
+				reason.RelatedProductIDs = []string{related}
+			}
+		case config.FallbackSourceSegment:
+			reason.Message = "Popular in your segment"
+		case config.FallbackSourceCategory:
+			reason.Message = "Trending in categories you like"
+		case sourceExploration:
+			reason.Message = "New in the catalog"
+		case sourceMerchandising:
+			reason.Message = "Featured"
+		default:
+			reason.Message = "Trending now"
+		}
+		reason.Contributions = scoreContributions(product, weights)
+		explained[i].Reason = reason
+	}
+	return explained, nil
+}
+
+// explanationContext loads what the page's explanations refer to: the user's
+// history for products found from it, and the names of related products.
+func (s *RecommendationService) explanationContext(ctx context.Context, query RecommendationQuery, userID, anchorID string, products []models.ProductRecommendation) (*explanationContext, error) {
+	ec := &explanationContext{names: map[string]string{}, anchors: map[string]string{}, ruleNames: map[string][]string{}}
+	if query.Debug != nil {
+		for _, rule := range query.Debug.AppliedRules {
+			for _, productID := range rule.ProductIDs {
+				ec.ruleNames[productID] = append(ec.ruleNames[productID], rule.Name)
+			}
+		}
+	}
+
+	var itemItem, content []string
+	for _, product := range products {
+		switch product.Source {
+		case sourceItemItem:
+			itemItem = append(itemItem, product.ProductID)
+		case sourceContent:
+			content = append(content, product.ProductID)
+		}
+	}
+
+	related := []string{}
+	if anchorID != "" {
+		related = append(related, anchorID)
+	} else if len(itemItem)+len(content) > 0 {
+		events, err := recentUserEvents(ctx, s.db, userID, s.cfg.Scoring.HistoryLimit)
+		if err != nil {
+			return nil, err
+		}
+		seen := map[string]bool{}
+		for _, event := range events {
+			if !seen[event.ProductID] {
+				seen[event.ProductID] = true
+				ec.history = append(ec.history, event)
+			}
+		}
+
+		if err := s.anchorItemItem(ctx, ec, itemItem); err != nil {
+			return nil, err
+		}
+		if err := s.anchorContent(ctx, ec, content); err != nil {
+			return nil, err
+		}
+		for _, productID := range ec.anchors {
+			related = append(related, productID)
+		}
+	}
+	if len(related) == 0 {
+		return ec, nil
+	}
+
+	metadata, err := s.products.GetProducts(ctx, related)
+	if err != nil {
+		return nil, err
+	}
+	for _, productID := range related {
+		if name := metadata[productID].Name; name != "" {
+			ec.names[productID] = name
+		}
+	}
+	return ec, nil
+}
+
+// anchorItemItem relates every co-visited product to the product of the
+// user's history whose visitors overlap with its visitors most.
+func (s *RecommendationService) anchorItemItem(ctx context.Context, ec *explanationContext, productIDs []string) error {
+	if len(productIDs) == 0 || len(ec.history) == 0 {
+		return nil
+	}
+
+	ids := append([]string{}, productIDs...)
+	for _, event := range ec.history {
+		ids = append(ids, event.ProductID)
+	}
+	visitors, err := s.productVisitors(ctx, ids)
+	if err != nil {
+		return err
+	}
+
+	for _, productID := range productIDs {
+		ec.anchors[productID] = bestAnchor(ec.history, func(anchorID string) float64 {
+			return jaccard(visitors[productID], visitors[anchorID])
+		})
+	}
+	return nil
+}
+
+// anchorContent relates every content-based product to the product of the
+// user's history it is most similar to.
+func (s *RecommendationService) anchorContent(ctx context.Context, ec *explanationContext, productIDs []string) error {
+	if len(productIDs) == 0 || len(ec.history) == 0 {
+		return nil
+	}
+
+	model, err := s.content.currentModel(ctx)
+	if err != nil {
+		return err
+	}
+	for _, productID := range productIDs {
+		vector := model.vectors[productID]
+		ec.anchors[productID] = bestAnchor(ec.history, func(anchorID string) float64 {
+			return vector.dot(model.vectors[anchorID])
+		})
+	}
+	return nil
+}
+
+// bestAnchor returns the history product with the highest positive
+// similarity, or an empty string when none is similar.
+func bestAnchor(history []recentEvent, similarity func(anchorID string) float64) string {
+	best, bestSimilarity := "", 0.0
+	for _, event := range history {
+		if value := similarity(event.ProductID); value > bestSimilarity {
+			best, bestSimilarity = event.ProductID, value
+		}
+	}
+	return best
+}
+
+func (ec *explanationContext) relatedMessage(source, related string, listAnchor bool) string {
+	if related == "" {
+		if source == sourceContent {
+			return "Matches products you interacted with"
+		}
+		return "Popular with customers who like what you like"
+	}
+
+	name := ec.names[related]
+	if name == "" {
+		name = related
+	}
+	if listAnchor {
+		if source == sourceItemItem {
+			return "Frequently viewed with " + name
+		}
+		return "Similar to " + name
+	}
+
+	verb := "viewed"
+	for _, event := range ec.history {
+		if event.ProductID == related {
+			verb = eventVerbs[event.EventType]
+			break
+		}
+	}
+	if source == sourceContent {
+		return fmt.Sprintf("Similar to %s, which you %s", name, verb)
+	}
+	return fmt.Sprintf("Because you %s %s", verb, name)
+}
+
+// activityMessage describes the user's own events on a product.
+func activityMessage(product models.ProductRecommendation) string {
+	var parts []string
+	for _, part := range []struct {
+		count int
+		noun  string
+	}{
+		{product.ViewCount, "view"},
+		{product.CartAddCount, "cart add"},
+		{product.PurchaseCount, "purchase"},
+	} {
+		if part.count == 1 {
+			parts = append(parts, "1 "+part.noun)
+		} else if part.count > 1 {
+			parts = append(parts, fmt.Sprintf("%d %ss", part.count, part.noun))
+		}
+	}
+	if len(parts) == 0 {
+		return "Based on your activity"
+	}
+	if len(parts) > 1 {
+		parts = []string{strings.Join(parts[:len(parts)-1], ", "), parts[len(parts)-1]}
+	}
+	return "Based on your " + strings.Join(parts, " and ")
+}
+
+// scoreContributions splits a product's event score between event types,
+// or returns nil for products not scored from events.
+func scoreContributions(product models.ProductRecommendation, weights config.ScoringWeights) *models.ScoreContributions {
+	if product.ViewCount+product.CartAddCount+product.PurchaseCount == 0 || product.Count == 0 {
+		return nil
+	}
+
+	contributions := scoring.Contributions(scoring.Counts{
+		Count:    product.Count,
+		View:     product.ViewCount,
+		CartAdd:  product.CartAddCount,
+		Purchase: product.PurchaseCount,
+	}, weights)
+	return &contributions
+}
@@ -0,0 +1,224 @@
+package services
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+
+	"polyforge-recommendation/internal/models"
+)
+
+var ErrInvalidExport = errors.New("invalid export")
+
+const (
+	ExportDatasetUsers    = "users"
+	ExportDatasetTrending = "trending"
+
+	ExportFormatNDJSON = "ndjson"
+	ExportFormatCSV    = "csv"
+)
+
+// ExportOptions selects what an export writes and how.
+type ExportOptions struct {
+	Dataset string
+	Format  string
+	Gzip    bool
+	// Version is the snapshot to export user lists from; 0 means the
+	// active one.
+	Version int
+	// UpdatedSince skips user lists stored before it when set.
+	UpdatedSince time.Time
+}
+
+func (o ExportOptions) Validate() error {
+	if o.Dataset != ExportDatasetUsers && o.Dataset != ExportDatasetTrending {
+		return fmt.Errorf("%w: unknown dataset %q", ErrInvalidExport, o.Dataset)
+	}
+	if o.Format != ExportFormatNDJSON && o.Format != ExportFormatCSV {
+		return fmt.Errorf("%w: unknown format %q", ErrInvalidExport, o.Format)
+	}
+	if o.Version < 0 {
+		return fmt.Errorf("%w: version must not be negative", ErrInvalidExport)
+	}
+	return nil
+}
+
+// FileName is a name for a file holding the export.
+func (o ExportOptions) FileName() string {
+	name := "recommendations-" + o.Dataset + "." + o.Format
+	if o.Gzip {
+		name += ".gz"
+	}
+	return name
+}
+
+// exportedUserList is a stored user list as exported.
+type exportedUserList struct {
+	UserID    string                         `json:"userId" bson:"userId"`
+	Version   int                            `json:"version" bson:"version"`
+	UpdatedAt *time.Time                     `json:"updatedAt,omitempty" bson:"updatedAt,omitempty"`
+	Products  []models.ProductRecommendation `json:"products" bson:"products"`
+}
+
+// exportWriter writes records in the chosen format. NDJSON writes a record
+// per line; CSV writes a row per product of a record.
+type exportWriter struct {
+	format string
+	json   *json.Encoder
+	csv    *csv.Writer
+}
+
+func newExportWriter(w io.Writer, format string, header []string) (*exportWriter, error) {
+	if format == ExportFormatNDJSON {
+		return &exportWriter{format: format, json: json.NewEncoder(w)}, nil
+	}
+
+	writer := &exportWriter{format: format, csv: csv.NewWriter(w)}
+	if err := writer.csv.Write(header); err != nil {
+		return nil, err
+	}
+	return writer, nil
+}
+
+func (w *exportWriter) write(record interface{}, rows [][]string) error {
+	if w.json != nil {
+		return w.json.Encode(record)
+	}
+	return w.csv.WriteAll(rows)
+}
+
+func (w *exportWriter) flush() error {
+	if w.csv != nil {
+		w.csv.Flush()
+		return w.csv.Error()
+	}
+	return nil
+}
+
+// Export streams a dataset to w and returns how many lists it wrote. User
+// lists are read from the collection with a cursor, so exports of any size
+// run in constant memory.
+func (s *RecommendationService) Export(ctx context.Context, w io.Writer, opts ExportOptions) (int, error) {
+	if err := opts.Validate(); err != nil {
+		return 0, err
+	}
+	if !opts.Gzip {
+		return s.exportDataset(ctx, w, opts)
+	}
+
+	// closing writes the gzip footer; an export without it is truncated
+	compressed := gzip.NewWriter(w)
+	count, err := s.exportDataset(ctx, compressed, opts)
+	if closeErr := compressed.Close(); err == nil {
+		err = closeErr
+	}
+	return count, err
+}
+
+func (s *RecommendationService) exportDataset(ctx context.Context, w io.Writer, opts ExportOptions) (int, error) {
+	if opts.Dataset == ExportDatasetTrending {
+		return s.exportTrending(ctx, w, opts)
+	}
+	return s.exportUserLists(ctx, w, opts)
+}
+
+func (s *RecommendationService) exportUserLists(ctx context.Context, w io.Writer, opts ExportOptions) (int, error) {
+	version := opts.Version
+	if version == 0 {
+		var err error
+		if version, err = s.store.ActiveSnapshotVersion(ctx); err != nil {
+			return 0, err
+		}
+	}
+	if err := s.ensureUserListIndex(ctx); err != nil {
+		return 0, err
+	}
+
+	filter := snapshotFilter(version)
+	if !opts.UpdatedSince.IsZero() {
+		filter["updatedAt"] = bson.M{"$gte": opts.UpdatedSince}
+	}
+	cursor, err := s.db.Collection("user_recommendations").Find(ctx, filter, options.Find().SetSort(bson.M{"userId": 1}))
+	if err != nil {
+		return 0, err
+	}
+	defer cursor.Close(ctx)
+
+	writer, err := newExportWriter(w, opts.Format, []string{"userId", "version", "rank", "productId", "score", "count", "lastInteraction"})
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	for cursor.Next(ctx) {
+		var list exportedUserList
+		if err := cursor.Decode(&list); err != nil {
+			return count, err
+		}
+		if list.Products == nil {
+			list.Products = []models.ProductRecommendation{}
+		}
+
+		rows := make([][]string, len(list.Products))
+		for i, product := range list.Products {
+			rows[i] = append([]string{list.UserID, strconv.Itoa(list.Version)}, productRow(i, product)...)
+		}
+		if err := writer.write(list, rows); err != nil {
+			return count, err
+		}
+		count++
+	}
+	if err := cursor.Err(); err != nil {
+		return count, err
+	}
+	return count, writer.flush()
+}
+
+// ensureUserListIndex indexes user lists by snapshot and user, so exports
+// read a snapshot in user order without sorting the collection in memory.
+// The index is created by the first export.
+func (s *RecommendationService) ensureUserListIndex(ctx context.Context) error {
+	return s.userListIndex.ensure(ctx, s.db.Collection("user_recommendations"), mongo.IndexModel{
+		Keys: bson.D{{Key: "version", Value: 1}, {Key: "userId", Value: 1}},
+	})
+}
+
+func (s *RecommendationService) exportTrending(ctx context.Context, w io.Writer, opts ExportOptions) (int, error) {
+	trending, err := s.getTrending(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	writer, err := newExportWriter(w, opts.Format, []string{"rank", "productId", "score", "count", "lastInteraction"})
+	if err != nil {
+		return 0, err
+	}
+	rows := make([][]string, len(trending.Products))
+	for i, product := range trending.Products {
+		rows[i] = productRow(i, product)
+	}
+	if err := writer.write(trending, rows); err != nil {
+		return 0, err
+	}
+	return 1, writer.flush()
+}
+
+func productRow(index int, product models.ProductRecommendation) []string {
+	return []string{
+		strconv.Itoa(index + 1),
+		product.ProductID,
+		strconv.FormatFloat(product.Score, 'f', -1, 64),
+		strconv.Itoa(product.Count),
+		product.LastInteraction.UTC().Format(time.RFC3339),
+	}
+}
@@ -0,0 +1,176 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+
+	"polyforge-recommendation/internal/config"
+	"polyforge-recommendation/internal/models"
+)
+
+// fallbackStage fills lists shorter than the configured minimum, or than the
+// requested page, from the fallback sources, in the configured order.
+// Fallback products go through the same availability and filter stages as
+// the list itself and are labelled with the source that provided them. A
+// failing source is skipped.
+func (s *RecommendationService) fallbackStage(userID string, query RecommendationQuery) ListStage {
+	return func(ctx context.Context, products []models.ProductRecommendation) ([]models.ProductRecommendation, error) {
+		target := max(s.cfg.Fallback.MinItems, query.Limit)
+		if len(products) >= target {
+			return products, nil
+		}
+
+		// the target follows the requested limit, so it does not size anything
+		filled := append([]models.ProductRecommendation{}, products...)
+		listed := make(map[string]bool, len(products))
+		for _, product := range products {
+			listed[product.ProductID] = true
+		}
+
+		for _, source := range s.cfg.Fallback.Chain {
+			candidates, err := s.fallbackCandidates(ctx, source, userID, query)
+			if err == nil {
+				candidates, err = applyStages(ctx, candidates, s.listStages(query))
+			}
+			if err != nil {
+				fmt.Printf("Error reading %s fallback for user %s: %v\n", source, userID, err)
+				continue
+			}
+
+			for _, candidate := range candidates {
+				if len(filled) >= target {
+					return filled, nil
+				}
+				if listed[candidate.ProductID] {
+					continue
+				}
+				listed[candidate.ProductID] = true
+				candidate.Source = source
+				filled = append(filled, candidate)
+			}
+		}
+		return filled, nil
+	}
+}
+
+func (s *RecommendationService) fallbackCandidates(ctx context.Context, source, userID string, query RecommendationQuery) ([]models.ProductRecommendation, error) {
+	switch source {
+	case config.FallbackSourceSegment:
+		if query.Segment == "" {
+			return nil, nil
+		}
+		return s.getSegmentPopular(ctx, query.Segment)
+	case config.FallbackSourceCategory:
+		categories, err := s.userCategories(ctx, userID, query)
+		if err != nil || len(categories) == 0 {
+			return nil, err
+		}
+		return s.getCategoryTrending(ctx, categories)
+	case config.FallbackSourceTrending:
+		trending, err := s.getTrending(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return trending.Products, nil
+	default:
+		return nil, fmt.Errorf("unknown fallback source %q", source)
+	}
+}
+
+func (s *RecommendationService) segmentKey(segment string) string {
+	return s.cfg.Cache.Key("segment_recommendations:" + segment)
+}
+
+// getSegmentPopular returns the products popular among users of a segment,
+// scored like trending and cached for as long as the trending list.
+func (s *RecommendationService) getSegmentPopular(ctx context.Context, segment string) ([]models.ProductRecommendation, error) {
+	key := s.segmentKey(segment)
+	cached, err := s.readTrendingKey(ctx, key)
+	if err != nil {
+		fmt.Printf("Error reading cached segment recommendations: %v\n", err)
+	}
+	if cached != nil {
+		return cached.Products, nil
+	}
+
+	result, err, _ := s.group.Do(key, func() (interface{}, error) {
+		ctx, cancel := sharedLoadContext(ctx)
+		defer cancel()
+
+		popular := &cachedTrending{ID: bson.NewObjectID().Hex(), Products: []models.ProductRecommendation{}}
+		cursor, err := s.db.Collection("events").Aggregate(ctx, scoringPipeline(bson.M{"segment": segment}, s.cfg.Scoring.Trending))
+		if err != nil {
+			return nil, err
+		}
+		if err := cursor.All(ctx, &popular.Products); err != nil {
+			return nil, err
+		}
+
+		if jsonData, err := json.Marshal(popular); err != nil {
+			fmt.Printf("Error marshaling segment recommendations: %v\n", err)
+		} else if err := s.lists.setLists(ctx, listEntry{key: key, value: jsonData, ttl: s.cfg.Cache.TrendingTTL}); err != nil {
+			fmt.Printf("Error caching segment recommendations: %v\n", err)
+		} else if s.local != nil {
+			s.local.Set(key, popular)
+		}
+		return popular, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result.(*cachedTrending).Products, nil
+}
+
+// userCategories returns the categories the fallback should favour: the
+// requested ones, or those of the products the user recently interacted with.
+func (s *RecommendationService) userCategories(ctx context.Context, userID string, query RecommendationQuery) ([]string, error) {
+	if len(query.Filter.Categories) > 0 {
+		return query.Filter.Categories, nil
+	}
+
+	events, err := recentUserEvents(ctx, s.db, userID, s.cfg.Scoring.HistoryLimit)
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]string, len(events))
+	for i, event := range events {
+		ids[i] = event.ProductID
+	}
+	products, err := s.products.GetProducts(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+
+	var categories []string
+	for _, product := range products {
+		if product.Category != "" && !containsFold(categories, product.Category) {
+			categories = append(categories, product.Category)
+		}
+	}
+	return categories, nil
+}
+
+// getCategoryTrending returns the trending products of the given categories.
+func (s *RecommendationService) getCategoryTrending(ctx context.Context, categories []string) ([]models.ProductRecommendation, error) {
+	trending, err := s.getTrending(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	metadata, err := s.products.GetProducts(ctx, productIDs(trending.Products))
+	if err != nil {
+		return nil, err
+	}
+
+	products := []models.ProductRecommendation{}
+	for _, product := range trending.Products {
+		if info, ok := metadata[product.ProductID]; ok && containsFold(categories, info.Category) {
+			products = append(products, product)
+		}
+	}
+	return products, nil
+}
@@ -0,0 +1,44 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+const (
+	invalidateAll        = "all"
+	invalidateUserPrefix = "user:"
+)
+
+func (s *RecommendationService) cacheInvalidationChannel() string {
+	return fmt.Sprintf("%s:cache_invalidation", s.cfg.Cache.Prefix)
+}
+
+// publishCacheInvalidation tells every replica to drop the given scope from
+// its local tier. Messages are "<instance>|<scope>" so the sender can skip
+// its own, already up to date, tier.
+func (s *RecommendationService) publishCacheInvalidation(ctx context.Context, scope string) error {
+	if s.local != nil && scope == invalidateAll {
+		s.local.Purge()
+	}
+	return s.cache.Publish(ctx, s.cacheInvalidationChannel(), s.instanceID+"|"+scope).Err()
+}
+
+func (s *RecommendationService) subscribeCacheInvalidation(ctx context.Context) {
+	pubsub := s.cache.Subscribe(ctx, s.cacheInvalidationChannel())
+	defer pubsub.Close()
+
+	for message := range pubsub.Channel() {
+		origin, scope, found := strings.Cut(message.Payload, "|")
+		if !found || origin == s.instanceID {
+			continue
+		}
+
+		if scope == invalidateAll {
+			s.local.Purge()
+		} else if userID, ok := strings.CutPrefix(scope, invalidateUserPrefix); ok {
+			s.local.Delete(s.cfg.Cache.UserRecommendationKey(userID))
+		}
+	}
+}
@@ -0,0 +1,400 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+	"golang.org/x/sync/singleflight"
+
+	"polyforge-recommendation/internal/config"
+	"polyforge-recommendation/internal/models"
+)
+
+var (
+	ErrRuleNotFound = errors.New("merchandising rule not found")
+	ErrInvalidRule  = errors.New("invalid merchandising rule")
+)
+
+const sourceMerchandising = "merchandising"
+
+// MerchandisingService stores merchandising rules and applies the active ones
+// to returned lists. Rules are kept in memory and reloaded once they are
+// older than the configured TTL.
+type MerchandisingService struct {
+	db       *mongo.Database
+	cfg      config.Config
+	products *ProductService
+	group    singleflight.Group
+	mu       sync.RWMutex
+	rules    []models.MerchandisingRule
+	loadedAt time.Time
+}
+
+func NewMerchandisingService(db *mongo.Database, cfg config.Config) *MerchandisingService {
+	return &MerchandisingService{db: db, cfg: cfg, products: NewProductService(db, cfg)}
+}
+
+func (s *MerchandisingService) ListRules(ctx context.Context) ([]models.MerchandisingRule, error) {
+	cursor, err := s.db.Collection("merchandising_rules").Find(ctx, bson.M{},
+		options.Find().SetSort(bson.M{"createdAt": -1}),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	rules := []models.MerchandisingRule{}
+	if err := cursor.All(ctx, &rules); err != nil {
+		return nil, err
+	}
+	return rules, nil
+}
+
+func (s *MerchandisingService) GetRule(ctx context.Context, ruleID string) (*models.MerchandisingRule, error) {
+	id, err := bson.ObjectIDFromHex(ruleID)
+	if err != nil {
+		return nil, ErrRuleNotFound
+	}
+
+	var rule models.MerchandisingRule
+	err = s.db.Collection("merchandising_rules").FindOne(ctx, bson.M{"_id": id}).Decode(&rule)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrRuleNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	return &rule, nil
+}
+
+func (s *MerchandisingService) CreateRule(ctx context.Context, rule models.MerchandisingRule) (*models.MerchandisingRule, error) {
+	if err := validateRule(rule); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	rule.ID = bson.NewObjectID()
+	rule.CreatedAt = now
+	rule.UpdatedAt = now
+	if _, err := s.db.Collection("merchandising_rules").InsertOne(ctx, rule); err != nil {
+		return nil, err
+	}
+
+	s.resetRules()
+	return &rule, nil
+}
+
+// UpdateRule replaces the rule with the given one, keeping its ID and
+// creation time.
+func (s *MerchandisingService) UpdateRule(ctx context.Context, ruleID string, rule models.MerchandisingRule) (*models.MerchandisingRule, error) {
+	existing, err := s.GetRule(ctx, ruleID)
+	if err != nil {
+		return nil, err
+	}
+	if err := validateRule(rule); err != nil {
+		return nil, err
+	}
+
+	rule.ID = existing.ID
+	rule.CreatedAt = existing.CreatedAt
+	rule.UpdatedAt = time.Now()
+	if _, err := s.db.Collection("merchandising_rules").ReplaceOne(ctx, bson.M{"_id": existing.ID}, rule); err != nil {
+		return nil, err
+	}
+
+	s.resetRules()
+	return &rule, nil
+}
+
+func (s *MerchandisingService) DeleteRule(ctx context.Context, ruleID string) error {
+	id, err := bson.ObjectIDFromHex(ruleID)
+	if err != nil {
+		return ErrRuleNotFound
+	}
+
+	result, err := s.db.Collection("merchandising_rules").DeleteOne(ctx, bson.M{"_id": id})
+	if err != nil {
+		return err
+	} else if result.DeletedCount == 0 {
+		return ErrRuleNotFound
+	}
+
+	s.resetRules()
+	return nil
+}
+
+func validateRule(rule models.MerchandisingRule) error {
+	match := rule.Match
+	if len(match.ProductIDs) == 0 && len(match.Brands) == 0 && len(match.Categories) == 0 && len(match.Tags) == 0 {
+		return fmt.Errorf("%w: match at least one product, brand, category or tag", ErrInvalidRule)
+	}
+	if rule.StartsAt != nil && rule.EndsAt != nil && !rule.EndsAt.After(*rule.StartsAt) {
+		return fmt.Errorf("%w: endsAt must be after startsAt", ErrInvalidRule)
+	}
+
+	switch rule.Action {
+	case models.RuleActionPin:
+		if len(match.ProductIDs) == 0 || len(match.Brands)+len(match.Categories)+len(match.Tags) > 0 {
+			return fmt.Errorf("%w: pins match product IDs only", ErrInvalidRule)
+		}
+		if rule.Position < 1 {
+			return fmt.Errorf("%w: pins need a position of at least 1", ErrInvalidRule)
+		}
+	case models.RuleActionBoost:
+		if rule.Factor <= 0 {
+			return fmt.Errorf("%w: boosts need a positive factor", ErrInvalidRule)
+		}
+	case models.RuleActionBury, models.RuleActionBlock:
+	default:
+		return fmt.Errorf("%w: unknown action %q", ErrInvalidRule, rule.Action)
+	}
+	return nil
+}
+
+// resetRules makes the next list reload the rules, so changes apply at once
+// on this replica.
+func (s *MerchandisingService) resetRules() {
+	s.mu.Lock()
+	s.loadedAt = time.Time{}
+	s.mu.Unlock()
+}
+
+// activeRules returns the rules that currently apply to the placement.
+func (s *MerchandisingService) activeRules(ctx context.Context, placement string) ([]models.MerchandisingRule, error) {
+	s.mu.RLock()
+	rules, loadedAt := s.rules, s.loadedAt
+	s.mu.RUnlock()
+
+	if time.Since(loadedAt) >= s.cfg.Rules.CacheTTL {
+		result, err, _ := s.group.Do("rules", func() (interface{}, error) {
+			ctx, cancel := sharedLoadContext(ctx)
+			defer cancel()
+
+			rules, err := s.ListRules(ctx)
+			if err != nil {
+				return nil, err
+			}
+			s.mu.Lock()
+			s.rules, s.loadedAt = rules, time.Now()
+			s.mu.Unlock()
+			return rules, nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		rules = result.([]models.MerchandisingRule)
+	}
+
+	now := time.Now()
+	active := []models.MerchandisingRule{}
+	for _, rule := range rules {
+		if rule.ActiveAt(now) && (len(rule.Placements) == 0 || containsFold(rule.Placements, placement)) {
+			active = append(active, rule)
+		}
+	}
+	return active, nil
+}
+
+func ruleMatches(rule models.MerchandisingRule, productID string, metadata map[string]models.Product) bool {
+	match := rule.Match
+	for _, id := range match.ProductIDs {
+		if id == productID {
+			return true
+		}
+	}
+
+	product, ok := metadata[productID]
+	if !ok {
+		return false
+	}
+	if containsFold(match.Brands, product.Brand) || containsFold(match.Categories, product.Category) {
+		return true
+	}
+	for _, tag := range product.Tags {
+		if containsFold(match.Tags, tag) {
+			return true
+		}
+	}
+	return false
+}
+
+// ruleMetadata loads catalog data for the products when any rule matches on
+// attributes.
+func (s *MerchandisingService) ruleMetadata(ctx context.Context, rules []models.MerchandisingRule, products []models.ProductRecommendation) (map[string]models.Product, error) {
+	for _, rule := range rules {
+		if len(rule.Match.Brands)+len(rule.Match.Categories)+len(rule.Match.Tags) > 0 {
+			return s.products.GetProducts(ctx, productIDs(products))
+		}
+	}
+	return map[string]models.Product{}, nil
+}
+
+// RankStage applies the block, boost and bury rules of the placement:
+// blocked products are removed, boosted ones have their score multiplied and
+// the list is re-sorted, and buried ones are moved to the end. It runs
+// before diversity so the re-ranking sees the boosted scores.
+func (s *MerchandisingService) RankStage(placement string, debug *ListDebug) ListStage {
+	return func(ctx context.Context, products []models.ProductRecommendation) ([]models.ProductRecommendation, error) {
+		rules, err := s.activeRules(ctx, placement)
+		if err != nil || len(rules) == 0 {
+			return products, err
+		}
+		metadata, err := s.ruleMetadata(ctx, rules, products)
+		if err != nil {
+			return nil, err
+		}
+		return rankProducts(rules, metadata, products, debug), nil
+	}
+}
+
+// rankProducts applies the block, boost and bury rules to a list, with the
+// catalog data of its products for rules matching on attributes.
+func rankProducts(rules []models.MerchandisingRule, metadata map[string]models.Product, products []models.ProductRecommendation, debug *ListDebug) []models.ProductRecommendation {
+	ranked := append([]models.ProductRecommendation{}, products...)
+	boosted := false
+	for _, action := range []string{models.RuleActionBlock, models.RuleActionBoost, models.RuleActionBury} {
+		for _, rule := range rules {
+			if rule.Action != action {
+				continue
+			}
+
+			kept := make([]models.ProductRecommendation, 0, len(ranked))
+			var matched []models.ProductRecommendation
+			var affected []string
+			for _, product := range ranked {
+				if !ruleMatches(rule, product.ProductID, metadata) {
+					kept = append(kept, product)
+					continue
+				}
+				affected = append(affected, product.ProductID)
+				if action == models.RuleActionBoost {
+					product.Score *= rule.Factor
+					boosted = true
+					kept = append(kept, product)
+				} else if action == models.RuleActionBury {
+					matched = append(matched, product)
+				}
+			}
+			ranked = append(kept, matched...)
+			debug.recordRule(rule, affected)
+		}
+
+		// re-sort before burying so buried products stay at the end
+		if action == models.RuleActionBoost && boosted {
+			sort.SliceStable(ranked, func(i, j int) bool {
+				return ranked[i].Score > ranked[j].Score
+			})
+		}
+	}
+	return ranked
+}
+
+// PinStage places the pinned products of the placement at their positions,
+// after every other ranking step. Pinned products missing from the list are
+// added if they pass the list's availability and filter stages; blocked
+// products are never pinned.
+func (s *MerchandisingService) PinStage(placement string, listStages []ListStage, debug *ListDebug) ListStage {
+	return func(ctx context.Context, products []models.ProductRecommendation) ([]models.ProductRecommendation, error) {
+		rules, err := s.activeRules(ctx, placement)
+		if err != nil {
+			return nil, err
+		}
+
+		var pins, blocks []models.MerchandisingRule
+		for _, rule := range rules {
+			switch rule.Action {
+			case models.RuleActionPin:
+				pins = append(pins, rule)
+			case models.RuleActionBlock:
+				blocks = append(blocks, rule)
+			}
+		}
+		if len(pins) == 0 {
+			return products, nil
+		}
+		sort.SliceStable(pins, func(i, j int) bool { return pins[i].Position < pins[j].Position })
+
+		var pinned []models.ProductRecommendation
+		for _, rule := range pins {
+			for _, id := range rule.Match.ProductIDs {
+				pinned = append(pinned, models.ProductRecommendation{ProductID: id, Source: sourceMerchandising})
+			}
+		}
+		metadata, err := s.ruleMetadata(ctx, blocks, pinned)
+		if err != nil {
+			return nil, err
+		}
+
+		listed := make(map[string]models.ProductRecommendation, len(products))
+		for _, product := range products {
+			listed[product.ProductID] = product
+		}
+
+		// products already listed passed the stages; only new ones need to
+		var candidates []models.ProductRecommendation
+		for _, product := range pinned {
+			if _, ok := listed[product.ProductID]; !ok {
+				candidates = append(candidates, product)
+			}
+		}
+		if candidates, err = applyStages(ctx, candidates, listStages); err != nil {
+			return nil, err
+		}
+		eligible := make(map[string]bool, len(candidates))
+		for _, product := range candidates {
+			eligible[product.ProductID] = true
+		}
+
+		result := append([]models.ProductRecommendation{}, products...)
+		for _, rule := range pins {
+			var affected []string
+			for _, id := range rule.Match.ProductIDs {
+				product, inList := listed[id]
+				if !inList && !eligible[id] {
+					continue
+				}
+				if isBlocked(blocks, id, metadata) {
+					continue
+				}
+				if !inList {
+					product = models.ProductRecommendation{ProductID: id, Source: sourceMerchandising}
+				}
+
+				result = removeProduct(result, id)
+				// only the rule's products placed so far push this one down
+				position := rule.Position - 1 + len(affected)
+				if position > len(result) {
+					position = len(result)
+				}
+				result = append(result[:position], append([]models.ProductRecommendation{product}, result[position:]...)...)
+				affected = append(affected, id)
+			}
+			debug.recordRule(rule, affected)
+		}
+		return result, nil
+	}
+}
+
+func isBlocked(blocks []models.MerchandisingRule, productID string, metadata map[string]models.Product) bool {
+	for _, rule := range blocks {
+		if ruleMatches(rule, productID, metadata) {
+			return true
+		}
+	}
+	return false
+}
+
+func removeProduct(products []models.ProductRecommendation, productID string) []models.ProductRecommendation {
+	for i, product := range products {
+		if product.ProductID == productID {
+			return append(products[:i], products[i+1:]...)
+		}
+	}
+	return products
+}
@@ -0,0 +1,99 @@
+package services
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"polyforge-recommendation/internal/config"
+	"polyforge-recommendation/internal/models"
+)
+
+// fakeRules returns a service holding the given rules as freshly loaded, so
+// the stages never reach the database.
+func fakeRules(rules ...models.MerchandisingRule) *MerchandisingService {
+	for i := range rules {
+		rules[i].Enabled = true
+	}
+	cfg := config.Config{Rules: config.RulesConfig{CacheTTL: time.Hour}}
+	return &MerchandisingService{cfg: cfg, rules: rules, loadedAt: time.Now()}
+}
+
+func ruleFor(action string, productIDs ...string) models.MerchandisingRule {
+	return models.MerchandisingRule{Action: action, Match: models.RuleMatch{ProductIDs: productIDs}}
+}
+
+func listedIDs(products []models.ProductRecommendation) []string {
+	ids := make([]string, len(products))
+	for i, product := range products {
+		ids[i] = product.ProductID
+	}
+	return ids
+}
+
+func TestRankStageBlocksThenBoostsThenBuries(t *testing.T) {
+	boostP4 := ruleFor(models.RuleActionBoost, "p4")
+	boostP4.Factor = 10
+	boostBlocked := ruleFor(models.RuleActionBoost, "p1", "p3")
+	boostBlocked.Factor = 3
+	// rules are listed out of action order to show it does not matter
+	rules := fakeRules(
+		ruleFor(models.RuleActionBury, "p0"),
+		boostP4,
+		ruleFor(models.RuleActionBury, "p3"),
+		boostBlocked,
+		ruleFor(models.RuleActionBlock, "p1"),
+	)
+
+	ranked, err := rules.RankStage(PlacementPersonal, nil)(context.Background(), productList(6))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// p1 is blocked before it can be boosted, p4 and p3 are boosted above
+	// p0, and the buried p0 and p3 end up last in the order of their rules
+	want := []string{"p4", "p2", "p5", "p0", "p3"}
+	if got := listedIDs(ranked); !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %v, got %v", want, got)
+	}
+	if ranked[0].Score != 20 {
+		t.Errorf("expected the boosted score 20, got %v", ranked[0].Score)
+	}
+}
+
+func TestPinStagePlacesPinnedProductsAfterSkippedOnes(t *testing.T) {
+	pin := ruleFor(models.RuleActionPin, "p1", "p5", "missing", "new", "p6")
+	pin.Position = 2
+	rules := fakeRules(pin, ruleFor(models.RuleActionBlock, "p1"))
+
+	// the list stages reject the pinned product that is out of stock
+	inStock := func(ctx context.Context, products []models.ProductRecommendation) ([]models.ProductRecommendation, error) {
+		kept := []models.ProductRecommendation{}
+		for _, product := range products {
+			if product.ProductID != "missing" {
+				kept = append(kept, product)
+			}
+		}
+		return kept, nil
+	}
+
+	debug := &ListDebug{}
+	pinned, err := rules.PinStage(PlacementPersonal, []ListStage{inStock}, debug)(context.Background(), productList(7))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// the blocked and the rejected products take no position, so the placed
+	// ones follow each other from position 2
+	want := []string{"p0", "p5", "new", "p6", "p1", "p2", "p3", "p4"}
+	if got := listedIDs(pinned); !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %v, got %v", want, got)
+	}
+	if pinned[2].Source != sourceMerchandising {
+		t.Errorf("expected the added product to be labelled %s, got %q", sourceMerchandising, pinned[2].Source)
+	}
+	if len(debug.AppliedRules) != 1 || !reflect.DeepEqual(debug.AppliedRules[0].ProductIDs, []string{"p5", "new", "p6"}) {
+		t.Errorf("expected the pin to report the placed products, got %+v", debug.AppliedRules)
+	}
+}
@@ -0,0 +1,182 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"strconv"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+
+	"polyforge-recommendation/internal/config"
+	"polyforge-recommendation/internal/models"
+)
+
+var ErrUnknownPlacement = errors.New("unknown placement")
+
+// maxCoVisitedProducts bounds the list of a co-visited placement.
+const maxCoVisitedProducts = 100
+
+// PlacementContext is what the caller of a placement knows about the
+// surface: who looks at it, the product shown and the cart's contents.
+type PlacementContext struct {
+	UserID    string
+	ProductID string
+	Cart      []string
+}
+
+// productIDs returns the context's products, the shown one first.
+func (c PlacementContext) productIDs() []string {
+	var ids []string
+	seen := map[string]bool{}
+	for _, id := range append([]string{c.ProductID}, c.Cart...) {
+		if id != "" && !seen[id] {
+			seen[id] = true
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// GetPlacementRecommendations returns a page of a registered placement's
+// list: its strategy run with its limit, filters and exclusions on top of
+// the query, and its merchandising rules, diversity, bandit and experiments
+// selected by its name. A query limit overrides the placement's.
+func (s *RecommendationService) GetPlacementRecommendations(ctx context.Context, name string, pc PlacementContext, query RecommendationQuery) ([]models.ProductRecommendation, string, error) {
+	placement, ok := s.cfg.Placements[name]
+	if !ok {
+		return nil, "", fmt.Errorf("%w: %s", ErrUnknownPlacement, name)
+	}
+
+	query.Placement = name
+	if query.Limit <= 0 {
+		query.Limit = placement.Limit
+	}
+	if query.Limit <= 0 {
+		query.Limit = 10
+	}
+	if len(query.Filter.Categories) == 0 {
+		query.Filter.Categories = placement.Categories
+	}
+	if len(query.Filter.Brands) == 0 {
+		query.Filter.Brands = placement.Brands
+	}
+	query.Filter.InStock = query.Filter.InStock || placement.InStock
+
+	for _, exclusion := range placement.Exclude {
+		switch exclusion {
+		case config.PlacementExcludeContext:
+			query.Exclude = append(query.Exclude, pc.productIDs()...)
+		case config.PlacementExcludePurchased:
+			purchased, err := s.purchasedProducts(ctx, pc.UserID)
+			if err != nil {
+				return nil, "", err
+			}
+			query.Exclude = append(query.Exclude, purchased...)
+		}
+	}
+
+	switch {
+	case placement.Strategy == config.PlacementStrategyPersonal && pc.UserID != "":
+		recommendations, next, _, err := s.GetUserRecommendations(ctx, pc.UserID, query)
+		return recommendations.Products, next, err
+	case placement.Strategy == config.PlacementStrategySimilar && pc.ProductID != "":
+		return s.GetSimilarProducts(ctx, pc.ProductID, query)
+	case placement.Strategy == config.PlacementStrategyCoVisited && len(pc.productIDs()) > 0:
+		return s.getCoVisitedPage(ctx, pc, query)
+	default:
+		// anonymous callers and missing context products get trending
+		return s.GetTrendingPage(ctx, query)
+	}
+}
+
+// getCoVisitedPage returns a page of the products co-visited with the
+// context's products. Later pages are cut from the list the first page came
+// from; their cursors are pinned to the placement and the context, since
+// another product or cart has a different list.
+func (s *RecommendationService) getCoVisitedPage(ctx context.Context, pc PlacementContext, query RecommendationQuery) ([]models.ProductRecommendation, string, error) {
+	cursor, err := DecodeCursor(query.Cursor, cursorKindPlacement)
+	if err != nil {
+		return nil, "", err
+	}
+	contextIDs := pc.productIDs()
+	anchor := query.Placement + ":" + contextHash(contextIDs)
+	offset := 0
+	if cursor != nil {
+		offset = cursor.Offset
+	}
+	query = query.withRuleLog()
+
+	coVisited, err := s.pagedList(ctx, cursor, anchor, func(ctx context.Context) ([]models.ProductRecommendation, error) {
+		products, err := s.coVisitedProducts(ctx, pc.UserID, contextIDs, maxCoVisitedProducts)
+		for i := range products {
+			products[i].Source = sourceItemItem
+		}
+		return products, err
+	})
+	if err != nil {
+		return nil, "", err
+	}
+
+	explored := explorationFrom(cursor)
+	stages := append(s.listStages(query), s.rankingStages(query, query.Placement, explored)...)
+	products, err := applyStages(ctx, coVisited, stages)
+	if err != nil {
+		return nil, "", err
+	}
+
+	products, nextOffset := paginate(products, offset, query.Limit)
+	if products, err = s.expand(ctx, query, products); err != nil {
+		return nil, "", err
+	}
+	if products, err = s.explain(ctx, query, pc.UserID, contextIDs[0], sourceItemItem, products); err != nil {
+		return nil, "", err
+	}
+	if nextOffset < 0 {
+		return products, "", nil
+	}
+	pin := s.nextPin(ctx, cursor, anchor, coVisited)
+	return products, EncodeCursor(explored.nextCursor(&PageCursor{Kind: cursorKindPlacement, Pin: pin, Offset: nextOffset}, products)), nil
+}
+
+// contextHash identifies a placement context's products in cursors.
+func contextHash(productIDs []string) string {
+	hash := fnv.New64a()
+	for _, id := range productIDs {
+		fmt.Fprintf(hash, "%s|", id)
+	}
+	return strconv.FormatUint(hash.Sum64(), 16)
+}
+
+// purchasedProducts returns the products the user has bought.
+func (s *RecommendationService) purchasedProducts(ctx context.Context, userID string) ([]string, error) {
+	if userID == "" {
+		return nil, nil
+	}
+
+	result := s.db.Collection("events").Distinct(ctx, "productId", bson.M{"userId": userID, "eventType": "PURCHASE"})
+	var productIDs []string
+	if err := result.Decode(&productIDs); err != nil {
+		return nil, err
+	}
+	return productIDs, nil
+}
+
+// excludeStage removes the given products from a list.
+func excludeStage(productIDs []string) ListStage {
+	excluded := make(map[string]bool, len(productIDs))
+	for _, id := range productIDs {
+		excluded[id] = true
+	}
+
+	return func(ctx context.Context, products []models.ProductRecommendation) ([]models.ProductRecommendation, error) {
+		kept := make([]models.ProductRecommendation, 0, len(products))
+		for _, product := range products {
+			if !excluded[product.ProductID] {
+				kept = append(kept, product)
+			}
+		}
+		return kept, nil
+	}
+}
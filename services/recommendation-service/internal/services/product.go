@@ -0,0 +1,305 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+
+	"polyforge-recommendation/internal/config"
+	"polyforge-recommendation/internal/messaging"
+	"polyforge-recommendation/internal/models"
+)
+
+type ProductService struct {
+	db    *mongo.Database
+	store ProductStore
+	cfg   config.Config
+}
+
+// ProductStore is the persistent source of catalog data, stock included.
+type ProductStore interface {
+	// FindProducts returns the known products among the given IDs, keyed by
+	// ID.
+	FindProducts(ctx context.Context, productIDs []string) (map[string]models.Product, error)
+}
+
+func NewProductService(db *mongo.Database, cfg config.Config) *ProductService {
+	return &ProductService{db: db, store: &mongoProductStore{db: db}, cfg: cfg}
+}
+
+// UpsertProducts stores catalog data for the given products. Stock is owned
+// by the inventory service and left untouched.
+func (s *ProductService) UpsertProducts(ctx context.Context, products []models.Product) (int, error) {
+	if len(products) == 0 {
+		return 0, nil
+	}
+
+	now := time.Now()
+	writes := make([]mongo.WriteModel, 0, len(products))
+	for _, product := range products {
+		set := catalogFields(product)
+		set["updatedAt"] = now
+		writes = append(writes, mongo.NewUpdateOneModel().
+			SetFilter(bson.M{"productId": product.ProductID}).
+			SetUpdate(bson.M{
+				"$set":         set,
+				"$setOnInsert": bson.M{"createdAt": now},
+			}).
+			SetUpsert(true),
+		)
+	}
+
+	result, err := s.db.Collection("products").BulkWrite(ctx, writes, options.BulkWrite().SetOrdered(false))
+	if err != nil {
+		return 0, err
+	}
+	return int(result.UpsertedCount + result.ModifiedCount), nil
+}
+
+// catalogFields returns the catalog data of a product keyed by document
+// field, which matches its JSON name.
+func catalogFields(product models.Product) bson.M {
+	return bson.M{
+		"sku":         product.SKU,
+		"name":        product.Name,
+		"description": product.Description,
+		"category":    product.Category,
+		"brand":       product.Brand,
+		"tags":        product.Tags,
+		"price":       product.Price,
+		"currency":    product.Currency,
+		"imageUrl":    product.ImageURL,
+	}
+}
+
+// UpdateStock records the available stock of a product, which the
+// availability stage and the inStock filter read to suppress it once it is
+// out of stock.
+func (s *ProductService) UpdateStock(ctx context.Context, productID string, available int) error {
+	_, err := s.db.Collection("products").UpdateOne(ctx,
+		bson.M{"productId": productID},
+		bson.M{
+			"$set":         bson.M{"available": available, "updatedAt": time.Now()},
+			"$setOnInsert": bson.M{"createdAt": time.Now()},
+		},
+		options.UpdateOne().SetUpsert(true),
+	)
+	return err
+}
+
+// StockChangedMessage is published by the inventory service whenever the
+// available stock of a product changes. Inventory keys products by SKU, so
+// the product ID is optional and resolved from the SKU when missing.
+type StockChangedMessage struct {
+	ProductID string `json:"productId"`
+	SKU       string `json:"sku"`
+	Available *int   `json:"available"`
+}
+
+func (s *ProductService) HandleStockChanged(ctx context.Context, body []byte) error {
+	var message StockChangedMessage
+	if err := json.Unmarshal(body, &message); err != nil || message.Available == nil || (message.ProductID == "" && message.SKU == "") {
+		return fmt.Errorf("%w: stock change %s", messaging.ErrMalformedMessage, body)
+	}
+
+	productID := message.ProductID
+	if productID == "" {
+		var product models.Product
+		err := s.db.Collection("products").FindOne(ctx, bson.M{"sku": message.SKU}).Decode(&product)
+		if err == mongo.ErrNoDocuments {
+			fmt.Printf("Skipping stock change for unknown SKU %s\n", message.SKU)
+			return nil
+		} else if err != nil {
+			return err
+		}
+		productID = product.ProductID
+	}
+
+	return s.UpdateStock(ctx, productID, *message.Available)
+}
+
+// unavailableProducts returns which of the given products are out of stock.
+// Stock is read from the catalog, so the availability stage and the inStock
+// filter always agree.
+func (s *ProductService) unavailableProducts(ctx context.Context, ids []string) (map[string]bool, error) {
+	products, err := s.GetProducts(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+	return outOfStock(products), nil
+}
+
+// outOfStock returns which of the loaded products are out of stock.
+func outOfStock(products map[string]models.Product) map[string]bool {
+	unavailable := make(map[string]bool)
+	for id, product := range products {
+		if !product.InStock() {
+			unavailable[id] = true
+		}
+	}
+	return unavailable
+}
+
+// AvailabilityStage returns a list stage that removes out-of-stock products
+// or, in demote mode, moves them behind all available ones. When stock
+// cannot be read the list is served unfiltered rather than failing.
+func (s *ProductService) AvailabilityStage() ListStage {
+	return func(ctx context.Context, products []models.ProductRecommendation) ([]models.ProductRecommendation, error) {
+		if len(products) == 0 {
+			return products, nil
+		}
+
+		unavailable, err := s.unavailableProducts(ctx, productIDs(products))
+		if err != nil {
+			fmt.Printf("Error reading product availability: %v\n", err)
+			return products, nil
+		}
+		return s.applyAvailability(products, unavailable), nil
+	}
+}
+
+// applyAvailability removes the unavailable products from a list or, in
+// demote mode, moves them behind the available ones.
+func (s *ProductService) applyAvailability(products []models.ProductRecommendation, unavailable map[string]bool) []models.ProductRecommendation {
+	available := make([]models.ProductRecommendation, 0, len(products))
+	var demoted []models.ProductRecommendation
+	for _, product := range products {
+		if !unavailable[product.ProductID] {
+			available = append(available, product)
+		} else if s.cfg.Availability.Mode == config.AvailabilityModeDemote {
+			demoted = append(demoted, product)
+		}
+	}
+	return append(available, demoted...)
+}
+
+// NewestProducts returns the IDs of the products most recently added to the
+// catalog.
+func (s *ProductService) NewestProducts(ctx context.Context, limit int) ([]string, error) {
+	ids := []string{}
+	if limit <= 0 {
+		return ids, nil
+	}
+
+	cursor, err := s.db.Collection("products").Find(ctx,
+		bson.M{"createdAt": bson.M{"$exists": true}},
+		options.Find().
+			SetSort(bson.D{{Key: "createdAt", Value: -1}}).
+			SetLimit(int64(limit)).
+			SetProjection(bson.M{"productId": 1}),
+	)
+	if err != nil {
+		return nil, err
+	}
+	var products []models.Product
+	if err := cursor.All(ctx, &products); err != nil {
+		return nil, err
+	}
+	for _, product := range products {
+		ids = append(ids, product.ProductID)
+	}
+	return ids, nil
+}
+
+func (s *ProductService) GetProduct(ctx context.Context, productID string) (*models.Product, error) {
+	var product models.Product
+	err := s.db.Collection("products").FindOne(ctx, bson.M{"productId": productID}).Decode(&product)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return &product, nil
+}
+
+// GetProducts returns the known products among the given IDs, keyed by ID.
+func (s *ProductService) GetProducts(ctx context.Context, productIDs []string) (map[string]models.Product, error) {
+	return s.store.FindProducts(ctx, productIDs)
+}
+
+// ProductFilter restricts recommendations by product metadata. Products
+// without metadata never match a category, brand or price filter.
+type ProductFilter struct {
+	Categories []string
+	Brands     []string
+	MinPrice   *float64
+	MaxPrice   *float64
+	InStock    bool
+}
+
+func (f ProductFilter) IsEmpty() bool {
+	return len(f.Categories) == 0 && len(f.Brands) == 0 && f.MinPrice == nil && f.MaxPrice == nil && !f.InStock
+}
+
+func (f ProductFilter) needsAttributes() bool {
+	return len(f.Categories) > 0 || len(f.Brands) > 0 || f.MinPrice != nil || f.MaxPrice != nil
+}
+
+func (f ProductFilter) matches(product models.Product, known bool) bool {
+	if !known {
+		return !f.needsAttributes()
+	}
+	if len(f.Categories) > 0 && !containsFold(f.Categories, product.Category) {
+		return false
+	}
+	if len(f.Brands) > 0 && !containsFold(f.Brands, product.Brand) {
+		return false
+	}
+	if f.MinPrice != nil && product.Price < *f.MinPrice {
+		return false
+	}
+	if f.MaxPrice != nil && product.Price > *f.MaxPrice {
+		return false
+	}
+	if f.InStock && !product.InStock() {
+		return false
+	}
+	return true
+}
+
+// FilterStage returns a list stage keeping only the products matching the
+// filter, in their original order.
+func (s *ProductService) FilterStage(filter ProductFilter) ListStage {
+	return func(ctx context.Context, products []models.ProductRecommendation) ([]models.ProductRecommendation, error) {
+		if filter.IsEmpty() {
+			return products, nil
+		}
+
+		metadata, err := s.GetProducts(ctx, productIDs(products))
+		if err != nil {
+			return nil, err
+		}
+
+		filtered := make([]models.ProductRecommendation, 0, len(products))
+		for _, product := range products {
+			info, known := metadata[product.ProductID]
+			if filter.matches(info, known) {
+				filtered = append(filtered, product)
+			}
+		}
+		return filtered, nil
+	}
+}
+
+func productIDs(products []models.ProductRecommendation) []string {
+	ids := make([]string, len(products))
+	for i, product := range products {
+		ids[i] = product.ProductID
+	}
+	return ids
+}
+
+func containsFold(values []string, value string) bool {
+	for _, v := range values {
+		if strings.EqualFold(v, value) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,83 @@
+package services
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"polyforge-recommendation/internal/config"
+	"polyforge-recommendation/internal/models"
+)
+
+type fakeProductStore struct {
+	products map[string]models.Product
+}
+
+func (f *fakeProductStore) FindProducts(ctx context.Context, productIDs []string) (map[string]models.Product, error) {
+	products := make(map[string]models.Product, len(productIDs))
+	for _, id := range productIDs {
+		if product, ok := f.products[id]; ok {
+			products[id] = product
+		}
+	}
+	return products, nil
+}
+
+// fakeProducts returns a service whose catalog holds the given products.
+func fakeProducts(cfg config.Config, products ...models.Product) *ProductService {
+	store := &fakeProductStore{products: map[string]models.Product{}}
+	for _, product := range products {
+		store.products[product.ProductID] = product
+	}
+	return &ProductService{store: store, cfg: cfg}
+}
+
+func stock(available int) *int {
+	return &available
+}
+
+func TestAvailabilityStageAgreesWithInStockFilter(t *testing.T) {
+	products := fakeProducts(config.Config{},
+		models.Product{ProductID: "p0", Available: stock(3)},
+		models.Product{ProductID: "p1", Available: stock(0)},
+		// not reported on by the inventory service
+		models.Product{ProductID: "p2"},
+		models.Product{ProductID: "p3", Available: stock(-1)},
+	)
+	// p4 is not in the catalog at all
+	list := productList(5)
+
+	available, err := products.AvailabilityStage()(context.Background(), list)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	filtered, err := products.FilterStage(ProductFilter{InStock: true})(context.Background(), list)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	want := []string{"p0", "p2", "p4"}
+	if got := listedIDs(available); !reflect.DeepEqual(got, want) {
+		t.Errorf("expected the availability stage to keep %v, got %v", want, got)
+	}
+	if got := listedIDs(filtered); !reflect.DeepEqual(got, want) {
+		t.Errorf("expected the inStock filter to keep %v, got %v", want, got)
+	}
+}
+
+func TestAvailabilityStageDemotesOutOfStockProducts(t *testing.T) {
+	cfg := config.Config{Availability: config.AvailabilityConfig{Mode: config.AvailabilityModeDemote}}
+	products := fakeProducts(cfg,
+		models.Product{ProductID: "p0", Available: stock(0)},
+		models.Product{ProductID: "p2", Available: stock(0)},
+	)
+
+	demoted, err := products.AvailabilityStage()(context.Background(), productList(4))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := []string{"p1", "p3", "p0", "p2"}
+	if got := listedIDs(demoted); !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %v, got %v", want, got)
+	}
+}
@@ -0,0 +1,102 @@
+package services
+
+import (
+	"context"
+
+	"polyforge-recommendation/internal/models"
+)
+
+// RecommendationQuery describes which part of a recommendation list to
+// return and how to shape it.
+type RecommendationQuery struct {
+	Cursor string
+	Limit  int
+	Filter ProductFilter
+	// Exclude lists products never to return, such as those already in
+	// the caller's cart.
+	Exclude []string
+	// ExpandProduct embeds catalog data in every returned product.
+	ExpandProduct bool
+	// Segment is the caller's user segment, used by the cold-start fallback.
+	Segment string
+	// Placement selects placement-specific settings such as diversity; the
+	// endpoint's own placement is used when empty.
+	Placement string
+	// Experiment is the caller's experiment variant, whose strategy
+	// overrides the configured ranking.
+	Experiment *models.ExperimentAssignment
+	// Explain sets the reason of every returned product.
+	Explain bool
+	// Debug collects how the list was shaped when set.
+	Debug *ListDebug
+}
+
+// ListDebug describes how a returned list was shaped, for ?debug=true.
+type ListDebug struct {
+	AppliedRules []models.AppliedRule `json:"appliedRules"`
+}
+
+func (d *ListDebug) recordRule(rule models.MerchandisingRule, productIDs []string) {
+	if d == nil || len(productIDs) == 0 {
+		return
+	}
+	d.AppliedRules = append(d.AppliedRules, models.AppliedRule{
+		RuleID:     rule.ID.Hex(),
+		Name:       rule.Name,
+		Action:     rule.Action,
+		ProductIDs: productIDs,
+	})
+}
+
+// PlacementOr returns the query's placement, or the given default when the
+// query names none.
+func (q RecommendationQuery) PlacementOr(defaultPlacement string) string {
+	if q.Placement != "" {
+		return q.Placement
+	}
+	return defaultPlacement
+}
+
+// withRuleLog makes an explained query collect the rules applied to the
+// list, which explanations name, even when no debug output was asked for.
+func (q RecommendationQuery) withRuleLog() RecommendationQuery {
+	if q.Explain && q.Debug == nil {
+		q.Debug = &ListDebug{}
+	}
+	return q
+}
+
+// listStages returns the stages applied to a full list before pagination.
+func (s *RecommendationService) listStages(query RecommendationQuery) []ListStage {
+	stages := []ListStage{s.products.AvailabilityStage()}
+	if !query.Filter.IsEmpty() {
+		stages = append(stages, s.products.FilterStage(query.Filter))
+	}
+	if len(query.Exclude) > 0 {
+		stages = append(stages, excludeStage(query.Exclude))
+	}
+	return stages
+}
+
+// rankingStages returns the stages that end every list: merchandising
+// ranking, diversity, bandit exploration and pins, with the settings of the
+// query's placement. The bandit records what it explored in explored.
+func (s *RecommendationService) rankingStages(query RecommendationQuery, defaultPlacement string, explored *exploration) []ListStage {
+	placement := query.PlacementOr(defaultPlacement)
+	// products the bandit adds must pass the list's filters and blocks
+	candidateStages := append(s.listStages(query), s.rules.RankStage(placement, nil))
+	return []ListStage{
+		s.rules.RankStage(placement, query.Debug),
+		s.diversityStage(placement, s.diversitySettings(query, placement)),
+		s.banditStage(placement, query, explored, candidateStages),
+		s.rules.PinStage(placement, s.listStages(query), query.Debug),
+	}
+}
+
+// expand embeds catalog data in a returned page when the query asks for it.
+func (s *RecommendationService) expand(ctx context.Context, query RecommendationQuery, products []models.ProductRecommendation) ([]models.ProductRecommendation, error) {
+	if !query.ExpandProduct {
+		return products, nil
+	}
+	return s.products.ExpandProducts(ctx, products)
+}
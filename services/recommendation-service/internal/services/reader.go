@@ -0,0 +1,205 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"math/rand"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+
+	"polyforge-recommendation/internal/models"
+)
+
+const (
+	CacheStatusHit   = "HIT"
+	CacheStatusStale = "STALE"
+	CacheStatusMiss  = "MISS"
+
+	// earlyRefreshBeta tunes probabilistic early refresh; higher values
+	// refresh earlier.
+	earlyRefreshBeta = 1.0
+	refreshTimeout   = 10 * time.Second
+	// sharedLoadTimeout bounds a load that coalesced callers wait on.
+	sharedLoadTimeout = 30 * time.Second
+)
+
+// RecommendationStore is the persistent source of recommendation lists.
+type RecommendationStore interface {
+	ActiveSnapshotVersion(ctx context.Context) (int, error)
+	// FindUserRecommendation returns the user's full list in the given
+	// snapshot, with no products when the user has none.
+	FindUserRecommendation(ctx context.Context, userID string, version int) (models.UserRecommendation, error)
+	// FindUserRecommendations returns the lists of the given users in the
+	// given snapshot, keyed by user. Users without a list are left out.
+	FindUserRecommendations(ctx context.Context, userIDs []string, version int) (map[string]models.UserRecommendation, error)
+}
+
+// RecommendationCache holds full user lists in front of the store.
+type RecommendationCache interface {
+	// GetUserRecommendation returns the cached entry, nil on a miss, together
+	// with the current cache generation.
+	GetUserRecommendation(ctx context.Context, userID string) (*CachedUserRecommendation, int64, error)
+	// SetUserRecommendation stores the entry and sets its freshness deadline.
+	SetUserRecommendation(ctx context.Context, entry *CachedUserRecommendation) error
+	// GetUserRecommendations returns the cached entries of many users,
+	// whatever their generation or snapshot. Users without an entry are left
+	// out.
+	GetUserRecommendations(ctx context.Context, userIDs []string) (map[string]*CachedUserRecommendation, error)
+}
+
+// CachedUserRecommendation is the cached value for a user. Besides the full
+// list it records the cache generation it was built under and how long it
+// took to build, which drive stale-while-revalidate and early refresh.
+type CachedUserRecommendation struct {
+	UserID     string                         `json:"userId"`
+	Version    int                            `json:"version"`
+	Products   []models.ProductRecommendation `json:"products"`
+	Generation int64                          `json:"generation"`
+	FreshUntil time.Time                      `json:"freshUntil"`
+	ComputeMs  int64                          `json:"computeMs"`
+}
+
+// needsRefresh reports whether a cached entry should be rebuilt. Entries from
+// an older generation or past their freshness are always refreshed; fresh
+// entries are refreshed early with a probability that grows as expiry nears
+// (XFetch), so hot keys are rebuilt before they expire rather than all at once.
+func (e *CachedUserRecommendation) needsRefresh(generation int64, now time.Time) bool {
+	if e.Generation != generation || !now.Before(e.FreshUntil) {
+		return true
+	}
+	delta := float64(e.ComputeMs) * float64(time.Millisecond)
+	early := time.Duration(-delta * earlyRefreshBeta * math.Log(1-rand.Float64()))
+	return !now.Add(early).Before(e.FreshUntil)
+}
+
+// ListStage transforms a full ranked list before it is paginated, e.g. to
+// filter or re-rank it. The input may be shared with the cache, so stages
+// must return a new slice instead of modifying it.
+type ListStage func(ctx context.Context, products []models.ProductRecommendation) ([]models.ProductRecommendation, error)
+
+func applyStages(ctx context.Context, products []models.ProductRecommendation, stages []ListStage) ([]models.ProductRecommendation, error) {
+	for _, stage := range stages {
+		var err error
+		if products, err = stage(ctx, products); err != nil {
+			return nil, err
+		}
+	}
+	return products, nil
+}
+
+// RecommendationReader is the read path for user lists. It only ever caches
+// what the store holds, never writes back to the store, and always keeps the
+// full list so any limit can be served from a single cache entry.
+type RecommendationReader struct {
+	store RecommendationStore
+	cache RecommendationCache
+	group singleflight.Group
+}
+
+func NewRecommendationReader(store RecommendationStore, cache RecommendationCache) *RecommendationReader {
+	return &RecommendationReader{store: store, cache: cache}
+}
+
+// Read returns the first limit products of the user's list and whether it was
+// served from the cache (HIT), from a stale cache entry that is being
+// refreshed in the background (STALE) or from the store (MISS).
+func (r *RecommendationReader) Read(ctx context.Context, userID string, limit int) (models.UserRecommendation, string, error) {
+	recommendations, status, err := r.readCurrent(ctx, userID)
+	if err != nil {
+		return recommendations, status, err
+	}
+
+	// the products slice may be shared with the cache, so never modify it
+	if len(recommendations.Products) > limit {
+		recommendations.Products = recommendations.Products[:limit:limit]
+	}
+	return recommendations, status, nil
+}
+
+// ReadList returns the user's full list, served like Read, for callers that
+// shape and paginate it themselves. The list may be shared with the cache and
+// must not be modified.
+func (r *RecommendationReader) ReadList(ctx context.Context, userID string) (models.UserRecommendation, string, error) {
+	return r.readCurrent(ctx, userID)
+}
+
+// readCurrent returns the user's full list from the cache, whatever snapshot
+// it was built from, or from the active snapshot on a miss.
+func (r *RecommendationReader) readCurrent(ctx context.Context, userID string) (models.UserRecommendation, string, error) {
+	entry, generation, err := r.cache.GetUserRecommendation(ctx, userID)
+	if err != nil {
+		fmt.Printf("Error reading cached recommendations: %v\n", err)
+	}
+
+	if entry == nil {
+		recommendations, err := r.load(ctx, userID, generation)
+		return recommendations, CacheStatusMiss, err
+	}
+
+	status := CacheStatusHit
+	if entry.needsRefresh(generation, time.Now()) {
+		status = CacheStatusStale
+		r.refresh(userID, generation)
+	}
+	return models.UserRecommendation{UserID: userID, Version: entry.Version, Products: entry.Products}, status, nil
+}
+
+// sharedLoadContext returns the context of a load shared by coalesced
+// callers. It keeps the values of the first caller's context but not its
+// cancellation, so that caller going away does not fail the others.
+func sharedLoadContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.WithoutCancel(ctx), sharedLoadTimeout)
+}
+
+// load reads the full list of the active snapshot from the store and caches
+// it. Concurrent loads for the same user are coalesced so only one of them
+// reaches the store.
+func (r *RecommendationReader) load(ctx context.Context, userID string, generation int64) (models.UserRecommendation, error) {
+	result, err, _ := r.group.Do(userID, func() (interface{}, error) {
+		ctx, cancel := sharedLoadContext(ctx)
+		defer cancel()
+
+		started := time.Now()
+
+		version, err := r.store.ActiveSnapshotVersion(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		recommendations, err := r.store.FindUserRecommendation(ctx, userID, version)
+		if err != nil {
+			return nil, err
+		}
+
+		err = r.cache.SetUserRecommendation(ctx, &CachedUserRecommendation{
+			UserID:     userID,
+			Version:    recommendations.Version,
+			Products:   recommendations.Products,
+			Generation: generation,
+			ComputeMs:  time.Since(started).Milliseconds(),
+		})
+		if err != nil {
+			fmt.Printf("Error caching user recommendations: %v\n", err)
+		}
+		return recommendations, nil
+	})
+	if err != nil {
+		return models.UserRecommendation{UserID: userID}, err
+	}
+	return result.(models.UserRecommendation), nil
+}
+
+// refresh reloads a user's list in the background while the caller keeps
+// serving the stale one.
+func (r *RecommendationReader) refresh(userID string, generation int64) {
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), refreshTimeout)
+		defer cancel()
+
+		if _, err := r.load(ctx, userID, generation); err != nil {
+			fmt.Printf("Error refreshing recommendations for user %s: %v\n", userID, err)
+		}
+	}()
+}
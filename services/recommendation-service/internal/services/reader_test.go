@@ -0,0 +1,293 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"testing/synctest"
+	"time"
+
+	"polyforge-recommendation/internal/models"
+)
+
+type fakeStore struct {
+	mu      sync.Mutex
+	version int
+	lists   map[string][]models.ProductRecommendation
+	finds   int
+	release chan struct{}
+}
+
+func (f *fakeStore) ActiveSnapshotVersion(ctx context.Context) (int, error) {
+	return f.version, nil
+}
+
+func (f *fakeStore) FindUserRecommendation(ctx context.Context, userID string, version int) (models.UserRecommendation, error) {
+	f.mu.Lock()
+	f.finds++
+	f.mu.Unlock()
+
+	if f.release != nil {
+		select {
+		case <-f.release:
+		case <-ctx.Done():
+			return models.UserRecommendation{UserID: userID}, ctx.Err()
+		}
+	}
+
+	products := append([]models.ProductRecommendation{}, f.lists[userID]...)
+	return models.UserRecommendation{UserID: userID, Version: version, Products: products}, nil
+}
+
+func (f *fakeStore) FindUserRecommendations(ctx context.Context, userIDs []string, version int) (map[string]models.UserRecommendation, error) {
+	lists := map[string]models.UserRecommendation{}
+	for _, userID := range userIDs {
+		if products, ok := f.lists[userID]; ok {
+			lists[userID] = models.UserRecommendation{UserID: userID, Version: version, Products: products}
+		}
+	}
+	return lists, nil
+}
+
+func (f *fakeStore) findCount() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.finds
+}
+
+type fakeCache struct {
+	mu         sync.Mutex
+	entries    map[string]*CachedUserRecommendation
+	generation int64
+	sets       int
+}
+
+func newFakeCache() *fakeCache {
+	return &fakeCache{entries: map[string]*CachedUserRecommendation{}}
+}
+
+func (f *fakeCache) GetUserRecommendation(ctx context.Context, userID string) (*CachedUserRecommendation, int64, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.entries[userID], f.generation, nil
+}
+
+func (f *fakeCache) SetUserRecommendation(ctx context.Context, entry *CachedUserRecommendation) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	entry.FreshUntil = time.Now().Add(time.Hour)
+	f.entries[entry.UserID] = entry
+	f.sets++
+	return nil
+}
+
+func (f *fakeCache) GetUserRecommendations(ctx context.Context, userIDs []string) (map[string]*CachedUserRecommendation, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	entries := map[string]*CachedUserRecommendation{}
+	for _, userID := range userIDs {
+		if entry, ok := f.entries[userID]; ok {
+			entries[userID] = entry
+		}
+	}
+	return entries, nil
+}
+
+func (f *fakeCache) setCount() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.sets
+}
+
+func productList(n int) []models.ProductRecommendation {
+	products := make([]models.ProductRecommendation, n)
+	for i := range products {
+		products[i] = models.ProductRecommendation{ProductID: fmt.Sprintf("p%d", i), Score: float64(n - i)}
+	}
+	return products
+}
+
+func TestReadMissLoadsFullListAndTruncatesResponse(t *testing.T) {
+	store := &fakeStore{version: 3, lists: map[string][]models.ProductRecommendation{"u1": productList(20)}}
+	cache := newFakeCache()
+	reader := NewRecommendationReader(store, cache)
+
+	recommendations, status, err := reader.Read(context.Background(), "u1", 5)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if status != CacheStatusMiss {
+		t.Errorf("expected status %s, got %s", CacheStatusMiss, status)
+	}
+	if len(recommendations.Products) != 5 {
+		t.Errorf("expected 5 products, got %d", len(recommendations.Products))
+	}
+	if recommendations.Version != 3 {
+		t.Errorf("expected version 3, got %d", recommendations.Version)
+	}
+	if got := len(cache.entries["u1"].Products); got != 20 {
+		t.Errorf("expected the full list of 20 products to be cached, got %d", got)
+	}
+}
+
+func TestReadHitServesLargerLimitAfterSmallerOne(t *testing.T) {
+	store := &fakeStore{lists: map[string][]models.ProductRecommendation{"u1": productList(20)}}
+	cache := newFakeCache()
+	reader := NewRecommendationReader(store, cache)
+
+	if _, _, err := reader.Read(context.Background(), "u1", 3); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	recommendations, status, err := reader.Read(context.Background(), "u1", 15)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if status != CacheStatusHit {
+		t.Errorf("expected status %s, got %s", CacheStatusHit, status)
+	}
+	if len(recommendations.Products) != 15 {
+		t.Errorf("expected 15 products, got %d", len(recommendations.Products))
+	}
+	if store.findCount() != 1 {
+		t.Errorf("expected a single store read, got %d", store.findCount())
+	}
+	if cache.setCount() != 1 {
+		t.Errorf("expected the cache to be written once, got %d", cache.setCount())
+	}
+}
+
+func TestReadDoesNotModifyCachedList(t *testing.T) {
+	cache := newFakeCache()
+	cache.entries["u1"] = &CachedUserRecommendation{UserID: "u1", Products: productList(10), FreshUntil: time.Now().Add(time.Hour)}
+	reader := NewRecommendationReader(&fakeStore{}, cache)
+
+	recommendations, _, err := reader.Read(context.Background(), "u1", 2)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	recommendations.Products = append(recommendations.Products, models.ProductRecommendation{ProductID: "injected"})
+
+	if got := cache.entries["u1"].Products[2].ProductID; got != "p2" {
+		t.Errorf("expected cached list to be untouched, got %s at position 2", got)
+	}
+	if len(cache.entries["u1"].Products) != 10 {
+		t.Errorf("expected 10 cached products, got %d", len(cache.entries["u1"].Products))
+	}
+}
+
+func TestReadServesStaleEntryAndRefreshesInBackground(t *testing.T) {
+	store := &fakeStore{version: 2, lists: map[string][]models.ProductRecommendation{"u1": productList(4)}}
+	cache := newFakeCache()
+	cache.generation = 1
+	cache.entries["u1"] = &CachedUserRecommendation{UserID: "u1", Version: 1, Products: productList(2), Generation: 0, FreshUntil: time.Now().Add(time.Hour)}
+	reader := NewRecommendationReader(store, cache)
+
+	recommendations, status, err := reader.Read(context.Background(), "u1", 10)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if status != CacheStatusStale {
+		t.Errorf("expected status %s, got %s", CacheStatusStale, status)
+	}
+	if len(recommendations.Products) != 2 || recommendations.Version != 1 {
+		t.Errorf("expected the stale list to be served, got %d products of version %d", len(recommendations.Products), recommendations.Version)
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for cache.setCount() == 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if cache.setCount() != 1 {
+		t.Fatalf("expected the entry to be refreshed once, got %d writes", cache.setCount())
+	}
+
+	cache.mu.Lock()
+	refreshed := cache.entries["u1"]
+	cache.mu.Unlock()
+	if refreshed.Version != 2 || refreshed.Generation != 1 || len(refreshed.Products) != 4 {
+		t.Errorf("unexpected refreshed entry: version %d, generation %d, %d products", refreshed.Version, refreshed.Generation, len(refreshed.Products))
+	}
+}
+
+func TestReadCoalescesConcurrentMisses(t *testing.T) {
+	// the bubble lets the test wait until every reader is blocked rather
+	// than guess how long joining the load takes
+	synctest.Test(t, func(t *testing.T) {
+		store := &fakeStore{lists: map[string][]models.ProductRecommendation{"u1": productList(5)}, release: make(chan struct{})}
+		cache := newFakeCache()
+		reader := NewRecommendationReader(store, cache)
+
+		var wg sync.WaitGroup
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if _, _, err := reader.Read(context.Background(), "u1", 5); err != nil {
+					t.Errorf("unexpected error: %v", err)
+				}
+			}()
+		}
+
+		// one reader is blocked in the store and the others on its load
+		synctest.Wait()
+		close(store.release)
+		wg.Wait()
+
+		if store.findCount() != 1 {
+			t.Errorf("expected concurrent misses to share one store read, got %d", store.findCount())
+		}
+	})
+}
+
+func TestReadSharedLoadOutlivesFirstCaller(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		store := &fakeStore{lists: map[string][]models.ProductRecommendation{"u1": productList(5)}, release: make(chan struct{})}
+		reader := NewRecommendationReader(store, newFakeCache())
+
+		ctx, cancel := context.WithCancel(context.Background())
+		errs := make(chan error, 5)
+		go func() {
+			_, _, err := reader.Read(ctx, "u1", 5)
+			errs <- err
+		}()
+		synctest.Wait()
+		for i := 0; i < 4; i++ {
+			go func() {
+				_, _, err := reader.Read(context.Background(), "u1", 5)
+				errs <- err
+			}()
+		}
+		synctest.Wait()
+
+		// the first caller goes away while the others wait on its load
+		cancel()
+		synctest.Wait()
+		close(store.release)
+		for i := 0; i < 5; i++ {
+			if err := <-errs; err != nil {
+				t.Errorf("expected the shared load to complete, got %v", err)
+			}
+		}
+	})
+}
+
+func TestDecodeCursorRoundTrip(t *testing.T) {
+	raw := EncodeCursor(&PageCursor{Kind: cursorKindUser, Pin: "4", Offset: 20})
+
+	cursor, err := DecodeCursor(raw, cursorKindUser)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cursor.Pin != "4" || cursor.Offset != 20 {
+		t.Errorf("unexpected cursor: %+v", cursor)
+	}
+
+	if _, err := DecodeCursor(raw, cursorKindTrending); err != ErrInvalidCursor {
+		t.Errorf("expected a user cursor to be rejected for trending, got %v", err)
+	}
+	if _, err := DecodeCursor("not-a-cursor", cursorKindUser); err != ErrInvalidCursor {
+		t.Errorf("expected %v, got %v", ErrInvalidCursor, err)
+	}
+}
@@ -2,7 +2,6 @@ package services
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
 	"time"
 
@@ -10,392 +9,329 @@ import (
 	"go.mongodb.org/mongo-driver/v2/bson"
 	"go.mongodb.org/mongo-driver/v2/mongo"
 	"go.mongodb.org/mongo-driver/v2/mongo/options"
+	"golang.org/x/sync/singleflight"
 
 	"polyforge-recommendation/internal/config"
+	"polyforge-recommendation/internal/localcache"
 	"polyforge-recommendation/internal/models"
 )
 
 type RecommendationService struct {
-	db    *mongo.Database
-	cache *redis.Client
-	cfg   config.Config
+	db          *mongo.Database
+	cache       *redis.Client
+	cfg         config.Config
+	group       singleflight.Group
+	local       *localcache.LRU
+	instanceID  string
+	store       RecommendationStore
+	userCache   RecommendationCache
+	lists       listCache
+	reader      *RecommendationReader
+	products    *ProductService
+	content     *contentRecommender
+	rules       *MerchandisingService
+	experiments *ExperimentService
+
+	userListIndex mongoIndex
+	eventIDIndex  mongoIndex
 }
 
 func NewRecommendationService(db *mongo.Database, cache *redis.Client, cfg config.Config) *RecommendationService {
-	return &RecommendationService{db: db, cache: cache, cfg: cfg}
+	s := &RecommendationService{db: db, cache: cache, cfg: cfg, instanceID: bson.NewObjectID().Hex()}
+	if cfg.Cache.Local.Enabled {
+		s.local = localcache.NewLRU(cfg.Cache.Local.Size, cfg.Cache.Local.TTL)
+		go s.subscribeCacheInvalidation(context.Background())
+	}
+
+	s.store = &mongoRecommendationStore{db: db}
+	s.userCache = &redisRecommendationCache{client: cache, cfg: cfg.Cache, local: s.local}
+	s.lists = &redisListCache{client: cache}
+	s.reader = NewRecommendationReader(s.store, s.userCache)
+	s.products = NewProductService(db, cfg)
+	s.content = &contentRecommender{db: db, cfg: cfg}
+	s.rules = NewMerchandisingService(db, cfg)
+	s.experiments = NewExperimentService(db, cfg)
+	return s
 }
 
-func (s *RecommendationService) RecordUserInteraction(ctx context.Context, userID, productID, eventType string) (*models.UserActivity, error) {
-	collection := s.db.Collection("events")
-	now := time.Now()
-	_, err := collection.InsertOne(ctx, models.UserActivity{
-		UserID:    userID,
-		ProductID: productID,
-		EventType: eventType,
-		Timestamp: now,
-	})
-	if err != nil {
+// Rules returns the merchandising rules the service applies to its lists,
+// so rule changes made through it take effect on the next read.
+func (s *RecommendationService) Rules() *MerchandisingService {
+	return s.rules
+}
+
+// Experiments returns the experiments the service assigns users to, so
+// experiments started or stopped through it take effect on the next read.
+func (s *RecommendationService) Experiments() *ExperimentService {
+	return s.experiments
+}
+
+// RecordUserInteraction stores an event. Events on a placement are
+// attributed to the user's experiment variant there, if any, and views on a
+// bandit placement count as clicks on the product's arm.
+func (s *RecommendationService) RecordUserInteraction(ctx context.Context, activity models.UserActivity) (*models.UserActivity, error) {
+	if activity.Placement != "" {
+		if assignment := s.AssignExperiment(ctx, activity.UserID, activity.Placement); assignment != nil {
+			activity.ExperimentID = assignment.ExperimentID
+			activity.Variant = assignment.Variant
+		}
+	}
+
+	activity.Timestamp = time.Now()
+	if _, err := s.db.Collection("events").InsertOne(ctx, activity); err != nil {
 		return nil, err
 	}
-	return &models.UserActivity{
-		UserID:    userID,
-		ProductID: productID,
-		EventType: eventType,
-		Timestamp: now,
-	}, nil
+	s.recordBanditClick(ctx, activity)
+	return &activity, nil
 }
 
-func (s *RecommendationService) GetUserRecommendations(ctx context.Context, userID string, limit int) (models.UserRecommendation, error) {
-	var recommendations models.UserRecommendation
-	recommendations.UserID = userID
+// GetUserRecommendations serves a page of a user's blended list. The first
+// page blends the list of the cache or, on a miss, of the active snapshot,
+// and fills it from the fallback sources when it is short; later pages are
+// cut from the blend the first page came from, fallback products included,
+// so they neither shift nor repeat as the user keeps interacting. It returns the
+// cursor of the next page, empty after the last one, and the cache status of
+// the read.
+func (s *RecommendationService) GetUserRecommendations(ctx context.Context, userID string, query RecommendationQuery) (models.UserRecommendation, string, string, error) {
+	recommendations := models.UserRecommendation{UserID: userID}
+	cursor, err := DecodeCursor(query.Cursor, cursorKindUser)
+	if err != nil {
+		return recommendations, "", CacheStatusMiss, err
+	}
+	query = query.withRuleLog()
 
-	key := fmt.Sprintf("%s:user_recommendations:%s", s.cfg.Cache.Prefix, userID)
-	getCmd := s.cache.Get(ctx, key)
-	if getCmd.Err() == nil {
-		var products []models.ProductRecommendation
-		err := json.Unmarshal([]byte(getCmd.Val()), &products)
-		if err != nil {
-			fmt.Printf("Error unmarshaling cached recommendations: %v\n", err)
-		}
-		if len(products) < limit {
-			recommendations.Products = products
-		} else {
-			recommendations.Products = products[:limit]
+	blended, cacheStatus, err := s.userBlend(ctx, userID, cursor, s.blendConfig(query))
+	if err != nil {
+		return recommendations, "", cacheStatus, err
+	}
+	recommendations.Version = blended.Version
+
+	products, err := applyStages(ctx, blended.Products, s.listStages(query))
+	if err != nil {
+		return recommendations, "", cacheStatus, err
+	}
+	// later pages are cut from a blend the first page did not need to fill or
+	// from the filled one it pinned, so they never fall back themselves
+	listed := len(products)
+	if cursor == nil {
+		if products, err = s.fallbackStage(userID, query)(ctx, products); err != nil {
+			return recommendations, "", cacheStatus, err
 		}
-		return recommendations, nil
 	}
+	filled := products
 
-	collection := s.db.Collection("user_recommendations")
-	err := collection.FindOne(ctx, bson.M{"userId": userID}).Decode(&recommendations)
-	// if no recommendations found, return empty list
-	if err == mongo.ErrNoDocuments {
-		return recommendations, nil
-	} else if err != nil {
-		return recommendations, err
+	explored := explorationFrom(cursor)
+	if products, err = applyStages(ctx, products, s.rankingStages(query, PlacementPersonal, explored)); err != nil {
+		return recommendations, "", cacheStatus, err
 	}
 
-	if len(recommendations.Products) > limit {
-		recommendations.Products = recommendations.Products[:limit]
+	offset := 0
+	if cursor != nil {
+		offset = cursor.Offset
+	}
+	products, nextOffset := paginate(products, offset, query.Limit)
+	if products, err = s.expand(ctx, query, products); err != nil {
+		return recommendations, "", cacheStatus, err
 	}
+	if recommendations.Products, err = s.explain(ctx, query, userID, "", sourcePersonal, products); err != nil {
+		return recommendations, "", cacheStatus, err
+	}
+	if nextOffset < 0 {
+		return recommendations, "", cacheStatus, nil
+	}
+	if len(filled) > listed {
+		blended = s.pinFilledBlend(ctx, blended, filled)
+	}
+	return recommendations, EncodeCursor(explored.nextCursor(&PageCursor{Kind: cursorKindUser, Pin: blended.ID, Offset: nextOffset}, products)), cacheStatus, nil
+}
 
-	return recommendations, nil
+// pinFilledBlend pins a copy of the blend with the products the fallback
+// added to a first page, so that later pages are cut from the same fallback
+// products rather than from recomputed ones.
+func (s *RecommendationService) pinFilledBlend(ctx context.Context, blended *cachedBlend, filled []models.ProductRecommendation) *cachedBlend {
+	pinned := *blended
+	pinned.ID = bson.NewObjectID().Hex()
+	pinned.Products = filled
+	if err := s.cacheBlend(ctx, "", &pinned, false); err != nil {
+		fmt.Printf("Error caching filled blended recommendations: %v\n", err)
+	}
+	return &pinned
 }
 
-func (s *RecommendationService) SaveUserRecommendation(ctx context.Context, recommendation models.UserRecommendation) {
-	err := s.storeUserRecommendations(ctx, recommendation)
-	if err != nil {
-		fmt.Printf("Error storing user recommendations: %v\n", err)
-		return
+// userBlend returns the blend a page is cut from: the one the cursor is
+// pinned to, or the blend of the user's current list for a first page.
+func (s *RecommendationService) userBlend(ctx context.Context, userID string, cursor *PageCursor, blend config.BlendConfig) (*cachedBlend, string, error) {
+	if cursor != nil {
+		blended, err := s.getPinnedBlend(ctx, cursor.Pin)
+		switch {
+		case err != nil:
+			return nil, CacheStatusMiss, err
+		case blended == nil:
+			return nil, CacheStatusMiss, ErrCursorExpired
+		case blended.UserID != userID:
+			return nil, CacheStatusMiss, ErrInvalidCursor
+		}
+		return blended, CacheStatusHit, nil
 	}
 
-	err = s.cacheUserRecommendation(ctx, recommendation)
+	stored, cacheStatus, err := s.reader.ReadList(ctx, userID)
 	if err != nil {
-		fmt.Printf("Error caching user recommendations: %v\n", err)
-		return
+		return nil, cacheStatus, err
 	}
+	blended, err := s.blendedList(ctx, stored, blend)
+	return blended, cacheStatus, err
 }
 
 func (s *RecommendationService) storeUserRecommendations(ctx context.Context, recommendation models.UserRecommendation) error {
 	collection := s.db.Collection("user_recommendations")
 	_, err := collection.UpdateOne(ctx,
-		bson.M{"userId": recommendation.UserID},
-		bson.M{"$set": bson.M{"products": recommendation.Products}},
+		userRecommendationFilter(recommendation.UserID, recommendation.Version),
+		bson.M{"$set": bson.M{"version": recommendation.Version, "products": recommendation.Products, "updatedAt": time.Now()}},
 		options.UpdateOne().SetUpsert(true),
 	)
 
 	return err
 }
 
-func (s *RecommendationService) cacheUserRecommendation(ctx context.Context, recommendation models.UserRecommendation) error {
-	key := fmt.Sprintf("%s:user_recommendations:%s", s.cfg.Cache.Prefix, recommendation.UserID)
-
-	jsonData, err := json.Marshal(recommendation.Products)
+// ReCalculateUserRecommendations fills the given snapshot version with fresh
+// recommendations for every user and activates it once all users are written.
+// The previously active snapshot keeps serving reads until then.
+func (s *RecommendationService) ReCalculateUserRecommendations(ctx context.Context, version int) {
+	userIDs, err := s.distinctUserIDs(ctx)
 	if err != nil {
-		return err
+		fmt.Printf("Error fetching distinct user IDs: %v\n", err)
+		s.failSnapshot(ctx, version)
+		return
 	}
 
-	setCmd := s.cache.Set(ctx, key, string(jsonData), 12*time.Hour)
-	return setCmd.Err()
-}
-
-func (s *RecommendationService) clearUserRecommendationCache(ctx context.Context) error {
-	pattern := fmt.Sprintf("%s:user_recommendations:*", s.cfg.Cache.Prefix)
-	var cursor uint64
-	for {
-		keys, nextCursor, err := s.cache.Scan(ctx, cursor, pattern, 100).Result()
+	for _, userID := range userIDs {
+		products, err := s.scoreUserProducts(ctx, userID, s.cfg.Scoring.Personal)
 		if err != nil {
-			return err
-		}
-
-		if len(keys) > 0 {
-			if err := s.cache.Del(ctx, keys...).Err(); err != nil {
-				return err
-			}
+			fmt.Printf("Error aggregating recommendations for user %s: %v\n", userID, err)
+			s.failSnapshot(ctx, version)
+			return
 		}
 
-		cursor = nextCursor
-		if cursor == 0 {
-			break
+		err = s.storeUserRecommendations(ctx, models.UserRecommendation{
+			UserID:   userID,
+			Version:  version,
+			Products: products,
+		})
+		if err != nil {
+			fmt.Printf("Error storing recommendations for user %s: %v\n", userID, err)
+			s.failSnapshot(ctx, version)
+			return
 		}
 	}
-	return nil
-}
 
-func (s *RecommendationService) ReCalculateUserRecommendations(ctx context.Context) {
-	collection := s.db.Collection("events")
-
-	userIDsResult := collection.Distinct(ctx, "userId", bson.M{})
-	if userIDsResult.Err() != nil {
-		fmt.Printf("Error fetching distinct user IDs: %v\n", userIDsResult.Err())
+	if err := s.completeSnapshot(ctx, version, len(userIDs)); err != nil {
+		fmt.Printf("Error completing snapshot %d: %v\n", version, err)
+		s.failSnapshot(ctx, version)
 		return
 	}
 
-	var userIDs []string
-	if err := userIDsResult.Decode(&userIDs); err != nil {
-		fmt.Printf("Error decoding user IDs: %v\n", err)
+	// activation flips the pointer and invalidates the cache
+	if err := s.ActivateSnapshot(ctx, version); err != nil {
+		fmt.Printf("Error activating snapshot %d: %v\n", version, err)
 		return
 	}
 
-	for _, userID := range userIDs {
-		pipeline := []bson.M{
-			{"$match": bson.M{"userId": userID}},
-			{"$group": bson.M{
-				"_id":             "$productId",
-				"count":           bson.M{"$sum": 1},
-				"lastInteraction": bson.M{"$max": "$timestamp"},
-				"viewCount": bson.M{
-					"$sum": bson.M{
-						"$cond": bson.M{
-							"if":   bson.M{"$eq": []interface{}{"$eventType", "VIEW"}},
-							"then": 1,
-							"else": 0,
-						},
-					},
-				},
-				"cartAddCount": bson.M{
-					"$sum": bson.M{
-						"$cond": bson.M{
-							"if":   bson.M{"$eq": []interface{}{"$eventType", "CART_ADD"}},
-							"then": 1,
-							"else": 0,
-						},
-					},
-				},
-				"purchaseCount": bson.M{
-					"$sum": bson.M{
-						"$cond": bson.M{
-							"if":   bson.M{"$eq": []interface{}{"$eventType", "PURCHASE"}},
-							"then": 1,
-							"else": 0,
-						},
-					},
-				},
-			}},
-			// Calculate raw score first
-			{"$addFields": bson.M{
-				"rawScore": bson.M{
-					"$add": []interface{}{
-						bson.M{"$multiply": []interface{}{"$viewCount", 1}},
-						bson.M{"$multiply": []interface{}{"$cartAddCount", 3}},
-						bson.M{"$multiply": []interface{}{"$purchaseCount", 5}}, // Reduced from 10 to 5
-					},
-				},
-			}},
-			// Add count factor and normalize to max 10
-			{"$addFields": bson.M{
-				"countFactor": bson.M{
-					"$cond": bson.M{
-						"if":   bson.M{"$gte": []interface{}{"$count", 100}},
-						"then": 2.0,
-						"else": bson.M{
-							"$cond": bson.M{
-								"if":   bson.M{"$gte": []interface{}{"$count", 50}},
-								"then": 1.5,
-								"else": bson.M{
-									"$cond": bson.M{
-										"if":   bson.M{"$gte": []interface{}{"$count", 10}},
-										"then": 1.2,
-										"else": 1.0,
-									},
-								},
-							},
-						},
-					},
-				},
-			}},
-			// Calculate final score (max 10)
-			{"$addFields": bson.M{
-				"score": bson.M{
-					"$round": []interface{}{
-						bson.M{
-							"$min": []interface{}{
-								bson.M{
-									"$multiply": []interface{}{
-										bson.M{"$divide": []interface{}{"$rawScore", "$count"}}, // Average score per interaction
-										"$countFactor",
-										bson.M{"$ln": bson.M{"$add": []interface{}{"$count", 1}}}, // Log factor for count
-									},
-								},
-								10, // Cap at 10
-							},
-						},
-						2, // Round to 2 decimal places
-					},
-				},
-			}},
-			{"$project": bson.M{
-				"productId":       "$_id",
-				"count":           1,
-				"lastInteraction": 1,
-				"score":           1,
-				"viewCount":       1,
-				"cartAddCount":    1,
-				"purchaseCount":   1,
-				"_id":             0,
-			}},
-			{"$sort": bson.M{"score": -1}},
-		}
-
-		var recommendations models.UserRecommendation
-		recommendations.UserID = userID
-		cursor, err := collection.Aggregate(ctx, pipeline)
-		if err != nil {
-			fmt.Printf("Error aggregating recommendations for user %s: %v\n", userID, err)
-			return
-		}
-		defer cursor.Close(ctx)
+	if err := s.pruneSnapshots(ctx); err != nil {
+		fmt.Printf("Error pruning snapshots: %v\n", err)
+	}
+}
 
-		if err = cursor.All(ctx, &recommendations.Products); err != nil {
-			fmt.Printf("Error decoding recommendations for user %s: %v\n", userID, err)
-			return
-		}
+// GetTrendingRecommendations serves the global trending list from the cache
+// and only aggregates the whole events collection once per trending TTL.
+func (s *RecommendationService) GetTrendingRecommendations(ctx context.Context) ([]models.ProductRecommendation, error) {
+	trending, err := s.getTrending(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return trending.Products, nil
+}
 
-		err = s.storeUserRecommendations(ctx, recommendations)
-		if err != nil {
-			fmt.Printf("Error storing recommendations for user %s: %v\n", userID, err)
-			return
+// GetTrendingPage returns up to limit trending products starting at the
+// cursor and the cursor of the next page. Later pages are read from the
+// trending list the first page came from, even after it was recomputed.
+func (s *RecommendationService) GetTrendingPage(ctx context.Context, query RecommendationQuery) ([]models.ProductRecommendation, string, error) {
+	cursor, err := DecodeCursor(query.Cursor, cursorKindTrending)
+	if err != nil {
+		return nil, "", err
+	}
+	query = query.withRuleLog()
+
+	var trending *cachedTrending
+	offset := 0
+	if cursor == nil {
+		trending, err = s.getTrending(ctx)
+	} else {
+		offset = cursor.Offset
+		trending, err = s.getPinnedTrending(ctx, cursor.Pin)
+		if err == nil && trending == nil {
+			err = ErrCursorExpired
 		}
 	}
+	if err != nil {
+		return nil, "", err
+	}
 
-	err := s.clearUserRecommendationCache(ctx)
+	explored := explorationFrom(cursor)
+	stages := append(s.listStages(query), s.rankingStages(query, PlacementTrending, explored)...)
+	products, err := applyStages(ctx, trending.Products, stages)
 	if err != nil {
-		fmt.Printf("Error clearing recommendation cache: %v\n", err)
+		return nil, "", err
+	}
+
+	products, nextOffset := paginate(products, offset, query.Limit)
+	if products, err = s.expand(ctx, query, products); err != nil {
+		return nil, "", err
+	}
+	if products, err = s.explain(ctx, query, "", "", sourceTrending, products); err != nil {
+		return nil, "", err
 	}
+	if nextOffset < 0 {
+		return products, "", nil
+	}
+	return products, EncodeCursor(explored.nextCursor(&PageCursor{Kind: cursorKindTrending, Pin: trending.ID, Offset: nextOffset}, products)), nil
 }
 
-func (s *RecommendationService) GetTrendingRecommendations(ctx context.Context) ([]models.ProductRecommendation, error) {
-	collection := s.db.Collection("events")
-
-	pipeline := []bson.M{
-		{"$group": bson.M{
-			"_id":             "$productId",
-			"count":           bson.M{"$sum": 1},
-			"lastInteraction": bson.M{"$max": "$timestamp"},
-			"viewCount": bson.M{
-				"$sum": bson.M{
-					"$cond": bson.M{
-						"if":   bson.M{"$eq": []interface{}{"$eventType", "VIEW"}},
-						"then": 1,
-						"else": 0,
-					},
-				},
-			},
-			"cartAddCount": bson.M{
-				"$sum": bson.M{
-					"$cond": bson.M{
-						"if":   bson.M{"$eq": []interface{}{"$eventType", "CART_ADD"}},
-						"then": 1,
-						"else": 0,
-					},
-				},
-			},
-			"purchaseCount": bson.M{
-				"$sum": bson.M{
-					"$cond": bson.M{
-						"if":   bson.M{"$eq": []interface{}{"$eventType", "PURCHASE"}},
-						"then": 1,
-						"else": 0,
-					},
-				},
-			},
-		}},
-		// Calculate raw score first
-		{"$addFields": bson.M{
-			"rawScore": bson.M{
-				"$add": []interface{}{
-					bson.M{"$multiply": []interface{}{"$viewCount", 5}},
-					bson.M{"$multiply": []interface{}{"$cartAddCount", 3}},
-					bson.M{"$multiply": []interface{}{"$purchaseCount", 2}}, // Reduced from 10 to 5
-				},
-			},
-		}},
-		// Add count factor and normalize to max 10
-		{"$addFields": bson.M{
-			"countFactor": bson.M{
-				"$cond": bson.M{
-					"if":   bson.M{"$gte": []interface{}{"$count", 100}},
-					"then": 2.0,
-					"else": bson.M{
-						"$cond": bson.M{
-							"if":   bson.M{"$gte": []interface{}{"$count", 50}},
-							"then": 1.5,
-							"else": bson.M{
-								"$cond": bson.M{
-									"if":   bson.M{"$gte": []interface{}{"$count", 10}},
-									"then": 1.2,
-									"else": 1.0,
-								},
-							},
-						},
-					},
-				},
-			},
-		}},
-		// Calculate final score (max 10) and round to 2 decimal places
-		{"$addFields": bson.M{
-			"score": bson.M{
-				"$round": []interface{}{
-					bson.M{
-						"$min": []interface{}{
-							bson.M{
-								"$multiply": []interface{}{
-									bson.M{"$divide": []interface{}{"$rawScore", "$count"}}, // Average score per interaction
-									"$countFactor",
-									bson.M{"$ln": bson.M{"$add": []interface{}{"$count", 1}}}, // Log factor for count
-								},
-							},
-							10, // Cap at 10
-						},
-					},
-					2, // Round to 2 decimal places
-				},
-			},
-		}},
-		{"$project": bson.M{
-			"productId":       "$_id",
-			"count":           1,
-			"lastInteraction": 1,
-			"score":           1,
-			"viewCount":       1,
-			"cartAddCount":    1,
-			"purchaseCount":   1,
-			"_id":             0,
-		}},
-		{"$sort": bson.M{"score": -1}},
-	}
-
-	var trending []models.ProductRecommendation
-	cursor, err := collection.Aggregate(ctx, pipeline)
+func (s *RecommendationService) getTrending(ctx context.Context) (*cachedTrending, error) {
+	trending, err := s.getCachedTrending(ctx)
 	if err != nil {
-		fmt.Printf("Error aggregating trending recommendations: %v\n", err)
-		return nil, err
+		fmt.Printf("Error reading cached trending recommendations: %v\n", err)
 	}
-	defer cursor.Close(ctx)
+	if trending != nil {
+		return trending, nil
+	}
+
+	result, err, _ := s.group.Do(s.cfg.Cache.TrendingKey(), func() (interface{}, error) {
+		ctx, cancel := sharedLoadContext(ctx)
+		defer cancel()
 
-	if err = cursor.All(ctx, &trending); err != nil {
-		fmt.Printf("Error decoding trending recommendations: %v\n", err)
+		collection := s.db.Collection("events")
+
+		trending := &cachedTrending{ID: bson.NewObjectID().Hex(), Products: []models.ProductRecommendation{}}
+		cursor, err := collection.Aggregate(ctx, scoringPipeline(nil, s.cfg.Scoring.Trending))
+		if err != nil {
+			fmt.Printf("Error aggregating trending recommendations: %v\n", err)
+			return nil, err
+		}
+		defer cursor.Close(ctx)
+
+		if err = cursor.All(ctx, &trending.Products); err != nil {
+			fmt.Printf("Error decoding trending recommendations: %v\n", err)
+			return nil, err
+		}
+
+		if err := s.cacheTrending(ctx, trending); err != nil {
+			fmt.Printf("Error caching trending recommendations: %v\n", err)
+		}
+		return trending, nil
+	})
+	if err != nil {
 		return nil, err
 	}
 
-	return trending, nil
+	return result.(*cachedTrending), nil
 }
@@ -0,0 +1,186 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"polyforge-recommendation/internal/config"
+	"polyforge-recommendation/internal/models"
+)
+
+type fakeListCache struct {
+	values map[string][]byte
+}
+
+func newFakeListCache() *fakeListCache {
+	return &fakeListCache{values: map[string][]byte{}}
+}
+
+func (f *fakeListCache) getList(ctx context.Context, key string) ([]byte, error) {
+	return f.values[key], nil
+}
+
+func (f *fakeListCache) setLists(ctx context.Context, lists ...listEntry) error {
+	for _, list := range lists {
+		f.values[list.key] = list.value
+	}
+	return nil
+}
+
+// newTestService returns a service reading user lists through the given
+// fakes and blending the personal source only, so no other source is read.
+func newTestService(store *fakeStore, cache *fakeCache) *RecommendationService {
+	cfg := config.Config{Blend: config.BlendConfig{Weights: map[string]float64{sourcePersonal: 1}}}
+	return &RecommendationService{
+		cfg:       cfg,
+		store:     store,
+		userCache: cache,
+		reader:    NewRecommendationReader(store, cache),
+		lists:     newFakeListCache(),
+		products:  fakeProducts(cfg),
+		rules:     fakeRules(),
+	}
+}
+
+// rescore replaces the user's cached list, as a rebuild of the user does.
+func rescore(cache *fakeCache, userID string, products []models.ProductRecommendation) {
+	cache.entries[userID] = &CachedUserRecommendation{UserID: userID, Version: 1, Products: products, FreshUntil: time.Now().Add(time.Hour)}
+}
+
+func TestUserBlendWithExpiredPinExpires(t *testing.T) {
+	s := newTestService(&fakeStore{version: 1, lists: map[string][]models.ProductRecommendation{"u1": productList(5)}}, newFakeCache())
+
+	_, _, err := s.userBlend(context.Background(), "u1", &PageCursor{Kind: cursorKindUser, Pin: "gone", Offset: 2}, s.cfg.Blend)
+	if err != ErrCursorExpired {
+		t.Errorf("expected %v, got %v", ErrCursorExpired, err)
+	}
+}
+
+func TestUserBlendOfAnotherUserIsInvalid(t *testing.T) {
+	store := &fakeStore{version: 1, lists: map[string][]models.ProductRecommendation{"u1": productList(5), "u2": productList(3)}}
+	s := newTestService(store, newFakeCache())
+
+	first, _, err := s.userBlend(context.Background(), "u1", nil, s.cfg.Blend)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	_, _, err = s.userBlend(context.Background(), "u2", &PageCursor{Kind: cursorKindUser, Pin: first.ID, Offset: 2}, s.cfg.Blend)
+	if err != ErrInvalidCursor {
+		t.Errorf("expected %v, got %v", ErrInvalidCursor, err)
+	}
+}
+
+func TestUserBlendStaysOnThePinnedBlend(t *testing.T) {
+	cache := newFakeCache()
+	s := newTestService(&fakeStore{version: 1, lists: map[string][]models.ProductRecommendation{"u1": productList(5)}}, cache)
+
+	first, _, err := s.userBlend(context.Background(), "u1", nil, s.cfg.Blend)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	rescore(cache, "u1", productList(8))
+
+	current, _, err := s.userBlend(context.Background(), "u1", nil, s.cfg.Blend)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if current.ID == first.ID || len(current.Products) != 8 {
+		t.Fatalf("expected a new blend of the rescored list, got %s with %d products", current.ID, len(current.Products))
+	}
+
+	pinned, status, err := s.userBlend(context.Background(), "u1", &PageCursor{Kind: cursorKindUser, Pin: first.ID, Offset: 2}, s.cfg.Blend)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if pinned.ID != first.ID || !reflect.DeepEqual(listedIDs(pinned.Products), listedIDs(first.Products)) {
+		t.Errorf("expected the pinned blend %v, got %s with %v", listedIDs(first.Products), pinned.ID, listedIDs(pinned.Products))
+	}
+	if status != CacheStatusHit {
+		t.Errorf("expected %s, got %s", CacheStatusHit, status)
+	}
+}
+
+func TestGetUserRecommendationsPagesThroughThePinnedBlend(t *testing.T) {
+	cache := newFakeCache()
+	s := newTestService(&fakeStore{version: 1, lists: map[string][]models.ProductRecommendation{"u1": productList(5)}}, cache)
+
+	page, next, _, err := s.GetUserRecommendations(context.Background(), "u1", RecommendationQuery{Limit: 2})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	served := listedIDs(page.Products)
+
+	// the user is rescored between pages
+	rescore(cache, "u1", productList(8)[3:])
+
+	for next != "" {
+		if page, next, _, err = s.GetUserRecommendations(context.Background(), "u1", RecommendationQuery{Limit: 2, Cursor: next}); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		served = append(served, listedIDs(page.Products)...)
+	}
+
+	want := []string{"p0", "p1", "p2", "p3", "p4"}
+	if !reflect.DeepEqual(served, want) {
+		t.Errorf("expected the pages to serve %v, got %v", want, served)
+	}
+}
+
+// cacheTrending stores the products as the current trending list.
+func cacheTrending(t *testing.T, s *RecommendationService, id string, products []models.ProductRecommendation) {
+	t.Helper()
+	raw, err := json.Marshal(cachedTrending{ID: id, Products: products})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	s.lists.(*fakeListCache).values[s.cfg.Cache.TrendingKey()] = raw
+}
+
+func trendingList(prefix string, n int) []models.ProductRecommendation {
+	products := make([]models.ProductRecommendation, n)
+	for i := range products {
+		products[i] = models.ProductRecommendation{ProductID: fmt.Sprintf("%s%d", prefix, i), Score: float64(n - i)}
+	}
+	return products
+}
+
+func TestGetUserRecommendationsPagesThroughTheFallbackOfTheFirstPage(t *testing.T) {
+	s := newTestService(&fakeStore{version: 1, lists: map[string][]models.ProductRecommendation{"u1": productList(2)}}, newFakeCache())
+	s.cfg.Fallback = config.FallbackConfig{Chain: []string{config.FallbackSourceTrending}, MinItems: 6}
+	cacheTrending(t, s, "t", trendingList("t", 6))
+
+	page, next, _, err := s.GetUserRecommendations(context.Background(), "u1", RecommendationQuery{Limit: 3})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	served := listedIDs(page.Products)
+	if next == "" {
+		t.Fatalf("expected a second page of the filled list")
+	}
+
+	// trending is recomputed before the second page
+	cacheTrending(t, s, "x", trendingList("x", 6))
+
+	page, next, _, err = s.GetUserRecommendations(context.Background(), "u1", RecommendationQuery{Limit: 3, Cursor: next})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	served = append(served, listedIDs(page.Products)...)
+	if next != "" {
+		t.Errorf("expected the second page to be the last")
+	}
+
+	want := []string{"p0", "p1", "t0", "t1", "t2", "t3"}
+	if !reflect.DeepEqual(served, want) {
+		t.Errorf("expected the pages to serve %v, got %v", want, served)
+	}
+	for _, product := range page.Products {
+		if product.Source != config.FallbackSourceTrending {
+			t.Errorf("expected %s to be labelled %s, got %q", product.ProductID, config.FallbackSourceTrending, product.Source)
+		}
+	}
+}
@@ -0,0 +1,176 @@
+package services
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+
+	"polyforge-recommendation/internal/config"
+	"polyforge-recommendation/internal/models"
+	"polyforge-recommendation/internal/scoring"
+)
+
+// scoringPipeline aggregates events matching the given filter into scored
+// products, weighting each event type with the given weights. It computes
+// scoring.Score in Mongo.
+func scoringPipeline(match bson.M, weights config.ScoringWeights) []bson.M {
+	pipeline := []bson.M{}
+	if len(match) > 0 {
+		pipeline = append(pipeline, bson.M{"$match": match})
+	}
+
+	return append(pipeline,
+		bson.M{"$group": bson.M{
+			"_id":             "$productId",
+			"count":           bson.M{"$sum": 1},
+			"lastInteraction": bson.M{"$max": "$timestamp"},
+			"viewCount":       eventTypeCounter("VIEW"),
+			"cartAddCount":    eventTypeCounter("CART_ADD"),
+			"purchaseCount":   eventTypeCounter("PURCHASE"),
+		}},
+		// Calculate raw score first
+		bson.M{"$addFields": bson.M{
+			"rawScore": bson.M{
+				"$add": []interface{}{
+					bson.M{"$multiply": []interface{}{"$viewCount", weights.View}},
+					bson.M{"$multiply": []interface{}{"$cartAddCount", weights.CartAdd}},
+					bson.M{"$multiply": []interface{}{"$purchaseCount", weights.Purchase}},
+				},
+			},
+		}},
+		// Add count factor
+		bson.M{"$addFields": bson.M{
+			"countFactor": countFactorExpression(),
+		}},
+		// Calculate final score (capped) and round it
+		bson.M{"$addFields": bson.M{
+			"score": bson.M{
+				"$round": []interface{}{
+					bson.M{
+						"$min": []interface{}{
+							bson.M{
+								"$multiply": []interface{}{
+									bson.M{"$divide": []interface{}{"$rawScore", "$count"}}, // Average score per interaction
+									"$countFactor",
+									bson.M{"$ln": bson.M{"$add": []interface{}{"$count", 1}}}, // Log factor for count
+								},
+							},
+							scoring.MaxScore,
+						},
+					},
+					scoring.Decimals,
+				},
+			},
+		}},
+		bson.M{"$project": bson.M{
+			"productId":       "$_id",
+			"count":           1,
+			"lastInteraction": 1,
+			"score":           1,
+			"viewCount":       1,
+			"cartAddCount":    1,
+			"purchaseCount":   1,
+			"_id":             0,
+		}},
+		bson.M{"$sort": bson.M{"score": -1}},
+	)
+}
+
+// countFactorExpression picks the factor of the first count factor tier a
+// product's event count reaches, as scoring.CountFactor does.
+func countFactorExpression() interface{} {
+	var expression interface{} = 1.0
+	for i := len(scoring.CountFactorTiers) - 1; i >= 0; i-- {
+		tier := scoring.CountFactorTiers[i]
+		expression = bson.M{
+			"$cond": bson.M{
+				"if":   bson.M{"$gte": []interface{}{"$count", tier.MinCount}},
+				"then": tier.Factor,
+				"else": expression,
+			},
+		}
+	}
+	return expression
+}
+
+func eventTypeCounter(eventType string) bson.M {
+	return bson.M{
+		"$sum": bson.M{
+			"$cond": bson.M{
+				"if":   bson.M{"$eq": []interface{}{"$eventType", eventType}},
+				"then": 1,
+				"else": 0,
+			},
+		},
+	}
+}
+
+func (s *RecommendationService) scoreUserProducts(ctx context.Context, userID string, weights config.ScoringWeights) ([]models.ProductRecommendation, error) {
+	cursor, err := s.db.Collection("events").Aggregate(ctx, scoringPipeline(bson.M{"userId": userID}, weights))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	products := []models.ProductRecommendation{}
+	if err := cursor.All(ctx, &products); err != nil {
+		return nil, err
+	}
+	return products, nil
+}
+
+func (s *RecommendationService) distinctUserIDs(ctx context.Context) ([]string, error) {
+	result := s.db.Collection("events").Distinct(ctx, "userId", bson.M{})
+	if result.Err() != nil {
+		return nil, result.Err()
+	}
+
+	var userIDs []string
+	if err := result.Decode(&userIDs); err != nil {
+		return nil, err
+	}
+	return userIDs, nil
+}
+
+// recentEvent is the part of an event the candidate generators need.
+type recentEvent struct {
+	ProductID string `bson:"productId"`
+	EventType string `bson:"eventType"`
+}
+
+// recentUserEvents returns the user's most recent events, newest first.
+func recentUserEvents(ctx context.Context, db *mongo.Database, userID string, limit int) ([]recentEvent, error) {
+	events := []recentEvent{}
+	if userID == "" {
+		return events, nil
+	}
+
+	cursor, err := db.Collection("events").Find(ctx,
+		bson.M{"userId": userID},
+		options.Find().
+			SetSort(bson.M{"timestamp": -1}).
+			SetLimit(int64(limit)).
+			SetProjection(bson.M{"productId": 1, "eventType": 1}),
+	)
+	if err != nil {
+		return nil, err
+	}
+	if err := cursor.All(ctx, &events); err != nil {
+		return nil, err
+	}
+	return events, nil
+}
+
+// eventWeight returns the weight of a single event of the given type.
+func eventWeight(weights config.ScoringWeights, eventType string) float64 {
+	switch eventType {
+	case "CART_ADD":
+		return weights.CartAdd
+	case "PURCHASE":
+		return weights.Purchase
+	default:
+		return weights.View
+	}
+}
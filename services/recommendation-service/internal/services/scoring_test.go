@@ -0,0 +1,43 @@
+package services
+
+import (
+	"testing"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+
+	"polyforge-recommendation/internal/scoring"
+)
+
+// evalCountFactor evaluates the pipeline's count factor expression for a
+// product with the given event count.
+func evalCountFactor(t *testing.T, expression interface{}, count int) float64 {
+	t.Helper()
+	for {
+		switch e := expression.(type) {
+		case float64:
+			return e
+		case bson.M:
+			cond := e["$cond"].(bson.M)
+			operands := cond["if"].(bson.M)["$gte"].([]interface{})
+			if operands[0] != "$count" {
+				t.Fatalf("unexpected condition %v", cond["if"])
+			}
+			if count >= operands[1].(int) {
+				expression = cond["then"]
+			} else {
+				expression = cond["else"]
+			}
+		default:
+			t.Fatalf("unexpected expression %v", expression)
+		}
+	}
+}
+
+func TestPipelineCountFactorMatchesScoring(t *testing.T) {
+	expression := countFactorExpression()
+	for _, count := range []int{1, 9, 10, 49, 50, 99, 100, 1000} {
+		if got, want := evalCountFactor(t, expression, count), scoring.CountFactor(count); got != want {
+			t.Errorf("pipeline count factor for %d events = %v, scoring.CountFactor = %v", count, got, want)
+		}
+	}
+}
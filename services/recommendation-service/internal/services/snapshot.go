@@ -0,0 +1,202 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+
+	"polyforge-recommendation/internal/models"
+)
+
+var (
+	ErrSnapshotNotFound = errors.New("snapshot not found")
+	ErrSnapshotNotReady = errors.New("snapshot is not ready")
+)
+
+const (
+	activeSnapshotStateID   = "active_snapshot"
+	snapshotSequenceStateID = "snapshot_sequence"
+)
+
+// GetActiveSnapshotVersion returns the version that reads are served from.
+// Version 0 means no rebuild has completed yet and the legacy, unversioned
+// documents are still in use.
+func (s *RecommendationService) GetActiveSnapshotVersion(ctx context.Context) (int, error) {
+	return s.store.ActiveSnapshotVersion(ctx)
+}
+
+func (s *RecommendationService) ListSnapshots(ctx context.Context) ([]models.RecommendationSnapshot, error) {
+	activeVersion, err := s.GetActiveSnapshotVersion(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	cursor, err := s.db.Collection("recommendation_snapshots").Find(ctx, bson.M{},
+		options.Find().SetSort(bson.M{"version": -1}),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	snapshots := []models.RecommendationSnapshot{}
+	if err := cursor.All(ctx, &snapshots); err != nil {
+		return nil, err
+	}
+	for i := range snapshots {
+		snapshots[i].Active = snapshots[i].Version == activeVersion
+	}
+	return snapshots, nil
+}
+
+// CreateSnapshot allocates the next snapshot version and records it as
+// building. Rebuilds write into this version without touching the active one.
+func (s *RecommendationService) CreateSnapshot(ctx context.Context) (int, error) {
+	var sequence struct {
+		Value int `bson:"value"`
+	}
+	err := s.db.Collection("recommendation_state").FindOneAndUpdate(ctx,
+		bson.M{"_id": snapshotSequenceStateID},
+		bson.M{"$inc": bson.M{"value": 1}},
+		options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After),
+	).Decode(&sequence)
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = s.db.Collection("recommendation_snapshots").InsertOne(ctx, models.RecommendationSnapshot{
+		Version:   sequence.Value,
+		Status:    models.SnapshotStatusBuilding,
+		CreatedAt: time.Now(),
+	})
+	if err != nil {
+		return 0, err
+	}
+	return sequence.Value, nil
+}
+
+// ActivateSnapshot flips the active pointer to a ready snapshot. It is used
+// both at the end of a rebuild and to roll back to a retained version.
+func (s *RecommendationService) ActivateSnapshot(ctx context.Context, version int) error {
+	var snapshot models.RecommendationSnapshot
+	err := s.db.Collection("recommendation_snapshots").FindOne(ctx, bson.M{"version": version}).Decode(&snapshot)
+	if err == mongo.ErrNoDocuments {
+		return ErrSnapshotNotFound
+	} else if err != nil {
+		return err
+	}
+	if snapshot.Status != models.SnapshotStatusReady {
+		return ErrSnapshotNotReady
+	}
+
+	_, err = s.db.Collection("recommendation_state").UpdateOne(ctx,
+		bson.M{"_id": activeSnapshotStateID},
+		bson.M{"$set": bson.M{"version": version, "activatedAt": time.Now()}},
+		options.UpdateOne().SetUpsert(true),
+	)
+	if err != nil {
+		return err
+	}
+
+	if err := s.invalidateUserRecommendationCache(ctx); err != nil {
+		fmt.Printf("Error invalidating recommendation cache: %v\n", err)
+	}
+	return nil
+}
+
+func (s *RecommendationService) completeSnapshot(ctx context.Context, version, userCount int) error {
+	_, err := s.db.Collection("recommendation_snapshots").UpdateOne(ctx,
+		bson.M{"version": version},
+		bson.M{"$set": bson.M{
+			"status":      models.SnapshotStatusReady,
+			"userCount":   userCount,
+			"completedAt": time.Now(),
+		}},
+	)
+	return err
+}
+
+func (s *RecommendationService) failSnapshot(ctx context.Context, version int) {
+	_, err := s.db.Collection("recommendation_snapshots").UpdateOne(ctx,
+		bson.M{"version": version},
+		bson.M{"$set": bson.M{"status": models.SnapshotStatusFailed, "completedAt": time.Now()}},
+	)
+	if err != nil {
+		fmt.Printf("Error marking snapshot %d as failed: %v\n", version, err)
+	}
+}
+
+// pruneSnapshots keeps the newest finished snapshots up to the configured
+// retention, never dropping the active one, and removes the rest together
+// with their recommendation documents.
+func (s *RecommendationService) pruneSnapshots(ctx context.Context) error {
+	activeVersion, err := s.GetActiveSnapshotVersion(ctx)
+	if err != nil {
+		return err
+	}
+
+	cursor, err := s.db.Collection("recommendation_snapshots").Find(ctx,
+		bson.M{"status": bson.M{"$ne": models.SnapshotStatusBuilding}},
+		options.Find().SetSort(bson.M{"version": -1}),
+	)
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	var snapshots []models.RecommendationSnapshot
+	if err := cursor.All(ctx, &snapshots); err != nil {
+		return err
+	}
+
+	var expired []int
+	kept := 0
+	for _, snapshot := range snapshots {
+		if snapshot.Version == activeVersion {
+			continue
+		}
+		// the active snapshot always counts towards the retention limit
+		if kept < s.cfg.Snapshot.Retention-1 && snapshot.Status == models.SnapshotStatusReady {
+			kept++
+			continue
+		}
+		expired = append(expired, snapshot.Version)
+	}
+
+	if activeVersion > 0 {
+		// unversioned documents predate snapshots and are unreachable once one is active
+		if _, err := s.db.Collection("user_recommendations").DeleteMany(ctx, bson.M{"version": bson.M{"$exists": false}}); err != nil {
+			return err
+		}
+	}
+
+	if len(expired) == 0 {
+		return nil
+	}
+
+	if _, err := s.db.Collection("user_recommendations").DeleteMany(ctx, bson.M{"version": bson.M{"$in": expired}}); err != nil {
+		return err
+	}
+	_, err = s.db.Collection("recommendation_snapshots").DeleteMany(ctx, bson.M{"version": bson.M{"$in": expired}})
+	return err
+}
+
+func userRecommendationFilter(userID string, version int) bson.M {
+	filter := snapshotFilter(version)
+	filter["userId"] = userID
+	return filter
+}
+
+// snapshotFilter matches the user lists of a snapshot; version 0 also
+// matches lists stored before snapshots were versioned.
+func snapshotFilter(version int) bson.M {
+	if version == 0 {
+		return bson.M{"version": bson.M{"$in": bson.A{0, nil}}}
+	}
+	return bson.M{"version": version}
+}
@@ -0,0 +1,108 @@
+package services
+
+import (
+	"context"
+	"sync"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+
+	"polyforge-recommendation/internal/models"
+)
+
+// mongoIndex creates an index the first time it is needed rather than on
+// every call; a failed attempt is retried by the next one.
+type mongoIndex struct {
+	mu      sync.Mutex
+	created bool
+}
+
+func (i *mongoIndex) ensure(ctx context.Context, collection *mongo.Collection, model mongo.IndexModel) error {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if i.created {
+		return nil
+	}
+	if _, err := collection.Indexes().CreateOne(ctx, model); err != nil {
+		return err
+	}
+	i.created = true
+	return nil
+}
+
+type mongoRecommendationStore struct {
+	db *mongo.Database
+}
+
+func (m *mongoRecommendationStore) ActiveSnapshotVersion(ctx context.Context) (int, error) {
+	var state struct {
+		Version int `bson:"version"`
+	}
+	err := m.db.Collection("recommendation_state").FindOne(ctx, bson.M{"_id": activeSnapshotStateID}).Decode(&state)
+	if err == mongo.ErrNoDocuments {
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+	return state.Version, nil
+}
+
+func (m *mongoRecommendationStore) FindUserRecommendation(ctx context.Context, userID string, version int) (models.UserRecommendation, error) {
+	recommendations := models.UserRecommendation{UserID: userID, Version: version, Products: []models.ProductRecommendation{}}
+	err := m.db.Collection("user_recommendations").FindOne(ctx, userRecommendationFilter(userID, version)).Decode(&recommendations)
+	// if no recommendations found, return empty list
+	if err == mongo.ErrNoDocuments {
+		return recommendations, nil
+	}
+	return recommendations, err
+}
+
+// FindUserRecommendations returns the lists of many users in the given
+// snapshot with a single query. Users without a list are left out.
+func (m *mongoRecommendationStore) FindUserRecommendations(ctx context.Context, userIDs []string, version int) (map[string]models.UserRecommendation, error) {
+	lists := make(map[string]models.UserRecommendation, len(userIDs))
+	if len(userIDs) == 0 {
+		return lists, nil
+	}
+
+	filter := snapshotFilter(version)
+	filter["userId"] = bson.M{"$in": userIDs}
+	cursor, err := m.db.Collection("user_recommendations").Find(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	var found []models.UserRecommendation
+	if err := cursor.All(ctx, &found); err != nil {
+		return nil, err
+	}
+	for _, recommendation := range found {
+		lists[recommendation.UserID] = recommendation
+	}
+	return lists, nil
+}
+
+type mongoProductStore struct {
+	db *mongo.Database
+}
+
+func (m *mongoProductStore) FindProducts(ctx context.Context, productIDs []string) (map[string]models.Product, error) {
+	products := make(map[string]models.Product, len(productIDs))
+	if len(productIDs) == 0 {
+		return products, nil
+	}
+
+	cursor, err := m.db.Collection("products").Find(ctx, bson.M{"productId": bson.M{"$in": productIDs}})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var product models.Product
+		if err := cursor.Decode(&product); err != nil {
+			return nil, err
+		}
+		products[product.ProductID] = product
+	}
+	return products, cursor.Err()
+}
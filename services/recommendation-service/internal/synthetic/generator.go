@@ -0,0 +1,268 @@
+// Package synthetic generates realistic catalog and event data for local
+// development, demos and load tests. The same options and seed always give
+// the same data.
+package synthetic
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+	"time"
+
+	"polyforge-recommendation/internal/models"
+)
+
+var ErrInvalidOptions = errors.New("invalid generator options")
+
+var (
+	categoryNames = []string{"electronics", "books", "fashion", "home", "sports", "toys", "beauty", "garden", "grocery", "music"}
+	brandNames    = []string{"acme", "globex", "initech", "umbrella", "stark", "wayne", "hooli", "vandelay"}
+	segmentNames  = []string{"new", "regular", "vip"}
+)
+
+// Options shapes the generated data.
+type Options struct {
+	Seed     int64
+	Users    int
+	Products int
+	// Categories is how many categories the catalog spans.
+	Categories int
+	Start      time.Time
+	Days       int
+	// SessionsPerUser and ViewsPerSession are means; actual counts vary
+	// per user and session.
+	SessionsPerUser float64
+	ViewsPerSession float64
+	// ZipfS is the exponent of product popularity, above 1; higher values
+	// concentrate views on fewer products.
+	ZipfS float64
+	// CategoryAffinity is the chance that a view stays in the category the
+	// session is about rather than going to any product.
+	CategoryAffinity float64
+	// CartRate is the chance a view leads to a cart add, PurchaseRate the
+	// chance a cart add is bought at the end of the session.
+	CartRate     float64
+	PurchaseRate float64
+	// Seasonality, between 0 and 1, is how strongly sessions gather in the
+	// evening and at weekends; 0 spreads them evenly.
+	Seasonality float64
+}
+
+// DefaultOptions give a small but lively shop: about 30 000 events over the
+// last 30 days.
+func DefaultOptions() Options {
+	return Options{
+		Seed:             1,
+		Users:            1000,
+		Products:         500,
+		Categories:       8,
+		Start:            time.Now().UTC().AddDate(0, 0, -30).Truncate(24 * time.Hour),
+		Days:             30,
+		SessionsPerUser:  5,
+		ViewsPerSession:  6,
+		ZipfS:            1.2,
+		CategoryAffinity: 0.7,
+		CartRate:         0.1,
+		PurchaseRate:     0.4,
+		Seasonality:      0.5,
+	}
+}
+
+func (o Options) Validate() error {
+	switch {
+	case o.Users <= 0 || o.Products <= 0 || o.Days <= 0:
+		return fmt.Errorf("%w: users, products and days must be positive", ErrInvalidOptions)
+	case o.Categories <= 0 || o.Categories > len(categoryNames):
+		return fmt.Errorf("%w: categories must be between 1 and %d", ErrInvalidOptions, len(categoryNames))
+	case o.SessionsPerUser <= 0 || o.ViewsPerSession < 1:
+		return fmt.Errorf("%w: sessions per user must be positive and views per session at least 1", ErrInvalidOptions)
+	case o.ZipfS <= 1:
+		return fmt.Errorf("%w: the Zipf exponent must be above 1", ErrInvalidOptions)
+	}
+	for _, rate := range []struct {
+		name  string
+		value float64
+	}{
+		{"category affinity", o.CategoryAffinity},
+		{"cart rate", o.CartRate},
+		{"purchase rate", o.PurchaseRate},
+		{"seasonality", o.Seasonality},
+	} {
+		if rate.value < 0 || rate.value > 1 {
+			return fmt.Errorf("%w: %s must be between 0 and 1", ErrInvalidOptions, rate.name)
+		}
+	}
+	return nil
+}
+
+// User is a generated shopper.
+type User struct {
+	UserID  string
+	Segment string
+}
+
+// Dataset is generated data; events are ordered by time.
+type Dataset struct {
+	Products []models.Product
+	Users    []User
+	Events   []models.UserActivity
+}
+
+type generator struct {
+	opts     Options
+	random   *rand.Rand
+	products []models.Product
+	// byCategory lists each category's products, most popular first, with
+	// a Zipf sampler over them; index len(categories) spans the catalog.
+	byCategory [][]int
+	zipfs      []*rand.Zipf
+}
+
+// Generate builds a catalog, users and their sessions. A session picks a
+// time following the seasonality and a category the user is into, views a
+// few products, mostly of that category and mostly popular ones, adds some
+// to the cart and buys some of the cart when it ends.
+func Generate(opts Options) (Dataset, error) {
+	if err := opts.Validate(); err != nil {
+		return Dataset{}, err
+	}
+
+	g := &generator{opts: opts, random: rand.New(rand.NewSource(opts.Seed))}
+	g.generateCatalog()
+
+	dataset := Dataset{Products: g.products}
+	for i := 0; i < opts.Users; i++ {
+		user := User{UserID: g.uuid(), Segment: segmentNames[g.random.Intn(len(segmentNames))]}
+		dataset.Users = append(dataset.Users, user)
+
+		// users keep to a couple of favourite categories
+		favourites := []int{g.random.Intn(opts.Categories), g.random.Intn(opts.Categories)}
+		for s := g.count(opts.SessionsPerUser, 1); s > 0; s-- {
+			dataset.Events = g.session(dataset.Events, user, favourites[g.random.Intn(len(favourites))])
+		}
+	}
+
+	sort.SliceStable(dataset.Events, func(i, j int) bool {
+		return dataset.Events[i].Timestamp.Before(dataset.Events[j].Timestamp)
+	})
+	return dataset, nil
+}
+
+func (g *generator) generateCatalog() {
+	g.byCategory = make([][]int, g.opts.Categories+1)
+	for i := 0; i < g.opts.Products; i++ {
+		category := g.random.Intn(g.opts.Categories)
+		brand := brandNames[g.random.Intn(len(brandNames))]
+		g.products = append(g.products, models.Product{
+			ProductID: g.uuid(),
+			SKU:       fmt.Sprintf("SYN-%05d", i+1),
+			Name:      fmt.Sprintf("%s %s %d", brand, categoryNames[category], i+1),
+			Category:  categoryNames[category],
+			Brand:     brand,
+			Tags:      []string{categoryNames[category], brand},
+			Price:     math.Round((5+g.random.ExpFloat64()*45)*100) / 100,
+			Currency:  "USD",
+		})
+		g.byCategory[category] = append(g.byCategory[category], i)
+	}
+
+	// popularity ranks are random, so that they are not tied to the order
+	// of the catalog
+	all := g.random.Perm(g.opts.Products)
+	rank := make([]int, g.opts.Products)
+	for position, product := range all {
+		rank[product] = position
+	}
+	g.byCategory[g.opts.Categories] = all
+	for _, products := range g.byCategory[:g.opts.Categories] {
+		sort.Slice(products, func(i, j int) bool { return rank[products[i]] < rank[products[j]] })
+	}
+
+	g.zipfs = make([]*rand.Zipf, len(g.byCategory))
+	for i, products := range g.byCategory {
+		if len(products) > 0 {
+			g.zipfs[i] = rand.NewZipf(g.random, g.opts.ZipfS, 1, uint64(len(products)-1))
+		}
+	}
+}
+
+func (g *generator) session(events []models.UserActivity, user User, category int) []models.UserActivity {
+	at := g.sessionStart()
+	emit := func(productID, eventType string) {
+		events = append(events, models.UserActivity{
+			EventID:   g.uuid(),
+			UserID:    user.UserID,
+			ProductID: productID,
+			EventType: eventType,
+			Segment:   user.Segment,
+			Timestamp: at,
+		})
+	}
+
+	var cart []string
+	for v := g.count(g.opts.ViewsPerSession, 1); v > 0; v-- {
+		pool := g.opts.Categories
+		if g.random.Float64() < g.opts.CategoryAffinity && g.zipfs[category] != nil {
+			pool = category
+		}
+		product := g.products[g.byCategory[pool][g.zipfs[pool].Uint64()]].ProductID
+
+		emit(product, "VIEW")
+		at = at.Add(time.Duration(10+g.random.Intn(110)) * time.Second)
+		if g.random.Float64() < g.opts.CartRate {
+			emit(product, "CART_ADD")
+			cart = append(cart, product)
+			at = at.Add(time.Duration(5+g.random.Intn(25)) * time.Second)
+		}
+	}
+
+	for _, product := range cart {
+		if g.random.Float64() < g.opts.PurchaseRate {
+			emit(product, "PURCHASE")
+			at = at.Add(time.Second)
+		}
+	}
+	return events
+}
+
+// sessionStart samples a time in the generated period, favouring evenings
+// and weekends as much as the seasonality asks, by rejection.
+func (g *generator) sessionStart() time.Time {
+	span := time.Duration(g.opts.Days) * 24 * time.Hour
+	for {
+		at := g.opts.Start.Add(time.Duration(g.random.Int63n(int64(span)))).Truncate(time.Second)
+		hour := float64(at.Hour()) + float64(at.Minute())/60
+		// daily cycle peaking at 20:00, and a weekend lift
+		weight := 1 + g.opts.Seasonality*math.Cos(2*math.Pi*(hour-20)/24)
+		if day := at.Weekday(); day == time.Saturday || day == time.Sunday {
+			weight *= 1 + g.opts.Seasonality
+		}
+		if g.random.Float64()*(1+g.opts.Seasonality)*(1+g.opts.Seasonality) < weight {
+			return at
+		}
+	}
+}
+
+// count draws a whole number of at least minimum with the given mean, from a
+// geometric distribution, so that a few users and sessions are much busier
+// than the rest.
+func (g *generator) count(mean float64, minimum int) int {
+	extra := mean - float64(minimum)
+	if extra <= 0 {
+		return minimum
+	}
+	p := 1 / (extra + 1)
+	return minimum + int(math.Floor(math.Log(1-g.random.Float64())/math.Log(1-p)))
+}
+
+// uuid returns a random version 4 UUID drawn from the generator's source, so
+// IDs repeat with the seed and pass the event endpoint's validation.
+func (g *generator) uuid() string {
+	var b [16]byte
+	g.random.Read(b[:])
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
@@ -0,0 +1,177 @@
+package synthetic
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"regexp"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"polyforge-recommendation/internal/models"
+)
+
+var uuid4 = regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+
+func testOptions() Options {
+	opts := DefaultOptions()
+	opts.Users = 200
+	opts.Products = 100
+	opts.Start = time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	return opts
+}
+
+func TestGenerateIsDeterministic(t *testing.T) {
+	first, err := Generate(testOptions())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	second, _ := Generate(testOptions())
+	if !reflect.DeepEqual(first, second) {
+		t.Errorf("expected the same dataset for the same seed")
+	}
+
+	opts := testOptions()
+	opts.Seed = 2
+	other, _ := Generate(opts)
+	if reflect.DeepEqual(first.Events, other.Events) {
+		t.Errorf("expected another dataset for another seed")
+	}
+}
+
+func TestGenerateShapesEvents(t *testing.T) {
+	opts := testOptions()
+	dataset, err := Generate(opts)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(dataset.Products) != opts.Products || len(dataset.Users) != opts.Users {
+		t.Fatalf("expected %d products and %d users, got %d and %d", opts.Products, opts.Users, len(dataset.Products), len(dataset.Users))
+	}
+
+	end := opts.Start.AddDate(0, 0, opts.Days+1)
+	counts := map[string]int{}
+	views := map[string]int{}
+	eventIDs := map[string]bool{}
+	for i, event := range dataset.Events {
+		counts[event.EventType]++
+		if event.EventType == "VIEW" {
+			views[event.ProductID]++
+		}
+		if !uuid4.MatchString(event.ProductID) || !uuid4.MatchString(event.UserID) {
+			t.Fatalf("expected UUID v4 IDs, got user %s and product %s", event.UserID, event.ProductID)
+		}
+		if eventIDs[event.EventID] {
+			t.Fatalf("expected unique event IDs, got %s twice", event.EventID)
+		}
+		eventIDs[event.EventID] = true
+		if event.Timestamp.Before(opts.Start) || event.Timestamp.After(end) {
+			t.Fatalf("expected events within the period, got %v", event.Timestamp)
+		}
+		if i > 0 && event.Timestamp.Before(dataset.Events[i-1].Timestamp) {
+			t.Fatalf("expected events ordered by time")
+		}
+	}
+
+	// views lead to cart adds and cart adds to purchases at about the
+	// configured rates
+	if rate := float64(counts["CART_ADD"]) / float64(counts["VIEW"]); rate < opts.CartRate*0.8 || rate > opts.CartRate*1.2 {
+		t.Errorf("expected a cart rate near %.2f, got %.3f", opts.CartRate, rate)
+	}
+	if rate := float64(counts["PURCHASE"]) / float64(counts["CART_ADD"]); rate < opts.PurchaseRate*0.8 || rate > opts.PurchaseRate*1.2 {
+		t.Errorf("expected a purchase rate near %.2f, got %.3f", opts.PurchaseRate, rate)
+	}
+
+	// popularity is skewed: the top tenth of the products gets far more
+	// than a tenth of the views
+	perProduct := make([]int, 0, len(views))
+	for _, count := range views {
+		perProduct = append(perProduct, count)
+	}
+	sort.Sort(sort.Reverse(sort.IntSlice(perProduct)))
+	head := 0
+	for _, count := range perProduct[:opts.Products/10] {
+		head += count
+	}
+	if share := float64(head) / float64(counts["VIEW"]); share < 0.3 {
+		t.Errorf("expected the top products to get at least 30%% of the views, got %.2f", share)
+	}
+}
+
+func TestGenerateRejectsInvalidOptions(t *testing.T) {
+	for _, change := range []func(*Options){
+		func(o *Options) { o.Users = 0 },
+		func(o *Options) { o.ZipfS = 1 },
+		func(o *Options) { o.CartRate = 1.5 },
+		func(o *Options) { o.Categories = 100 },
+	} {
+		opts := testOptions()
+		change(&opts)
+		if _, err := Generate(opts); !errors.Is(err, ErrInvalidOptions) {
+			t.Errorf("expected ErrInvalidOptions for %+v, got %v", opts, err)
+		}
+	}
+}
+
+func TestReplayPostsEventsAsTheirUsers(t *testing.T) {
+	var mu sync.Mutex
+	received := map[string]string{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload struct {
+			ProductID string `json:"productId"`
+			EventType string `json:"eventType"`
+		}
+		json.NewDecoder(r.Body).Decode(&payload)
+		if r.URL.Path != "/recommendations/event" || payload.EventType == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if payload.ProductID == "rejected" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		mu.Lock()
+		received[r.Header.Get("x-user-id")] = payload.ProductID
+		mu.Unlock()
+	}))
+	defer server.Close()
+
+	events := []models.UserActivity{
+		{UserID: "u1", ProductID: "p1", EventType: "VIEW"},
+		{UserID: "u2", ProductID: "p2", EventType: "PURCHASE", Segment: "vip"},
+		{UserID: "u3", ProductID: "rejected", EventType: "VIEW"},
+	}
+	report, err := Replay(context.Background(), server.Client(), events, ReplayOptions{URL: server.URL + "/", Concurrency: 2})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if report.Sent != 3 || report.Failed != 1 || report.LastError == "" {
+		t.Errorf("expected 3 sent and 1 failed, got %+v", report)
+	}
+	if !reflect.DeepEqual(received, map[string]string{"u1": "p1", "u2": "p2"}) {
+		t.Errorf("expected the events posted as their users, got %v", received)
+	}
+}
+
+func TestReplayKeepsToTheRate(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	events := make([]models.UserActivity, 11)
+	for i := range events {
+		events[i] = models.UserActivity{UserID: "u1", ProductID: "p1", EventType: "VIEW"}
+	}
+	started := time.Now()
+	if _, err := Replay(context.Background(), server.Client(), events, ReplayOptions{URL: server.URL, Rate: 100}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// the 11th event is due 100ms after the first
+	if elapsed := time.Since(started); elapsed < 100*time.Millisecond {
+		t.Errorf("expected 11 events at 100/s to take at least 100ms, took %v", elapsed)
+	}
+}
@@ -0,0 +1,158 @@
+package synthetic
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"polyforge-recommendation/internal/models"
+)
+
+// replayProgressEvery is how many sent events apart replay progress is
+// reported.
+const replayProgressEvery = 1000
+
+// WriteEventsNDJSON writes one event per line, in the format the event
+// backfill and the offline evaluation read.
+func WriteEventsNDJSON(w io.Writer, events []models.UserActivity) error {
+	buffered := bufio.NewWriter(w)
+	encoder := json.NewEncoder(buffered)
+	for _, event := range events {
+		if err := encoder.Encode(event); err != nil {
+			return err
+		}
+	}
+	return buffered.Flush()
+}
+
+// WriteProductsNDJSON writes one product per line, in the format of the
+// catalog import.
+func WriteProductsNDJSON(w io.Writer, products []models.Product) error {
+	buffered := bufio.NewWriter(w)
+	encoder := json.NewEncoder(buffered)
+	for _, product := range products {
+		if err := encoder.Encode(product); err != nil {
+			return err
+		}
+	}
+	return buffered.Flush()
+}
+
+// ReplayOptions controls a replay.
+type ReplayOptions struct {
+	// URL is the service's base URL, e.g. http://localhost:8000.
+	URL string
+	// Rate is the target in events per second; 0 sends as fast as the
+	// workers can.
+	Rate float64
+	// Concurrency is how many requests may be in flight at once.
+	Concurrency int
+	// Progress, when set, receives the report every 1000 events.
+	Progress func(models.ReplayReport)
+}
+
+// Replay posts the events, in order, to POST /recommendations/event as their
+// users would, with the user and segment in the identity headers. The
+// service stamps events with the time it receives them, so the generated
+// timestamps are not kept. Failed requests are counted, not retried.
+func Replay(ctx context.Context, client *http.Client, events []models.UserActivity, opts ReplayOptions) (models.ReplayReport, error) {
+	report := models.ReplayReport{}
+	endpoint := strings.TrimRight(opts.URL, "/") + "/recommendations/event"
+	concurrency := max(opts.Concurrency, 1)
+
+	var mu sync.Mutex
+	start := time.Now()
+	record := func(err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		report.Sent++
+		if err != nil {
+			report.Failed++
+			report.LastError = err.Error()
+		}
+		if opts.Progress != nil && report.Sent%replayProgressEvery == 0 {
+			report.Rate = achievedRate(report.Sent, start)
+			opts.Progress(report)
+		}
+	}
+
+	jobs := make(chan models.UserActivity)
+	var workers sync.WaitGroup
+	for i := 0; i < concurrency; i++ {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			for event := range jobs {
+				record(postEvent(ctx, client, endpoint, event))
+			}
+		}()
+	}
+
+	// events are scheduled at start + i/rate rather than by a ticker, which
+	// keeps high rates accurate
+	var err error
+	for i, event := range events {
+		if opts.Rate > 0 {
+			due := start.Add(time.Duration(float64(i) / opts.Rate * float64(time.Second)))
+			if wait := time.Until(due); wait > 0 {
+				select {
+				case <-time.After(wait):
+				case <-ctx.Done():
+				}
+			}
+		}
+		if err = ctx.Err(); err != nil {
+			break
+		}
+		jobs <- event
+	}
+	close(jobs)
+	workers.Wait()
+
+	report.Rate = achievedRate(report.Sent, start)
+	return report, err
+}
+
+func postEvent(ctx context.Context, client *http.Client, endpoint string, event models.UserActivity) error {
+	body, err := json.Marshal(map[string]string{"productId": event.ProductID, "eventType": event.EventType})
+	if err != nil {
+		return err
+	}
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("x-user-id", event.UserID)
+	if event.Segment != "" {
+		request.Header.Set("x-user-segment", event.Segment)
+	}
+
+	response, err := client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	io.Copy(io.Discard, response.Body)
+	if response.StatusCode >= 300 {
+		return fmt.Errorf("%s returned %s", endpoint, response.Status)
+	}
+	return nil
+}
+
+func achievedRate(sent int, start time.Time) float64 {
+	elapsed := time.Since(start).Seconds()
+	if elapsed <= 0 {
+		return 0
+	}
+	return math.Round(float64(sent)/elapsed*10) / 10
+}
@@ -0,0 +1,68 @@
+package middleware
+
+import (
+	"bytes"
+	"io"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// bodyLimitApp streams request bodies, with /stream registered ahead of
+// BodyLimit and /buffered after it.
+func bodyLimitApp(limit int) *fiber.App {
+	app := fiber.New(fiber.Config{StreamRequestBody: true, BodyLimit: limit})
+	app.Post("/stream", func(c *fiber.Ctx) error {
+		read, err := io.Copy(io.Discard, c.Context().RequestBodyStream())
+		if err != nil {
+			return err
+		}
+		return c.SendString(strconv.FormatInt(read, 10))
+	})
+	app.Use(BodyLimit(limit))
+	app.Post("/buffered", func(c *fiber.Ctx) error {
+		return c.SendString(strconv.Itoa(len(c.Body())))
+	})
+	return app
+}
+
+func postBody(t *testing.T, app *fiber.App, path string, size int) (int, string) {
+	t.Helper()
+	resp, err := app.Test(httptest.NewRequest("POST", path, bytes.NewReader(make([]byte, size))), -1)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return resp.StatusCode, string(body)
+}
+
+func TestBodyLimitBuffersBodiesWithinTheLimit(t *testing.T) {
+	app := bodyLimitApp(1024)
+	for _, size := range []int{0, 10, 1024} {
+		status, body := postBody(t, app, "/buffered", size)
+		if status != fiber.StatusOK || body != strconv.Itoa(size) {
+			t.Errorf("expected a %d byte body to be buffered, got %d %q", size, status, body)
+		}
+	}
+}
+
+func TestBodyLimitRejectsLargerBodies(t *testing.T) {
+	app := bodyLimitApp(1024)
+	if status, _ := postBody(t, app, "/buffered", 4096); status != fiber.StatusRequestEntityTooLarge {
+		t.Errorf("expected 413 for a body over the limit, got %d", status)
+	}
+}
+
+func TestRoutesAheadOfBodyLimitStreamLargerBodies(t *testing.T) {
+	app := bodyLimitApp(1024)
+	status, body := postBody(t, app, "/stream", 1<<20)
+	if status != fiber.StatusOK || body != strconv.Itoa(1<<20) {
+		t.Errorf("expected the whole body to be streamed, got %d %q", status, body)
+	}
+}
@@ -7,9 +7,11 @@ import (
 func ContextTransformer(c *fiber.Ctx) error {
 	xUserID := c.Get("x-user-id")
 	xUserRole := c.Get("x-user-role")
+	xUserSegment := c.Get("x-user-segment")
 
 	c.Locals("userID", xUserID)
 	c.Locals("userRole", xUserRole)
+	c.Locals("userSegment", xUserSegment)
 
 	return c.Next()
 }